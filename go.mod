@@ -8,14 +8,12 @@ require (
 	github.com/juju/mutex/v2 v2.0.0
 	github.com/pkg/errors v0.9.1
 	github.com/spf13/cobra v1.9.1
+	gopkg.in/yaml.v3 v3.0.1
+	libvirt.org/go/libvirt v1.11004.0
 )
 
 require (
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/juju/errors v1.0.0 // indirect
-	github.com/mattn/go-colorable v0.1.14 // indirect
-	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/spf13/pflag v1.0.6 // indirect
-	golang.org/x/sys v0.33.0 // indirect
-	libvirt.org/go/libvirt v1.11004.0 // indirect
 )
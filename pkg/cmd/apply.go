@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/day0ops/netctl/pkg/network"
+)
+
+var applyCmdArgs struct {
+	File   string
+	Prune  bool
+	DryRun bool
+}
+
+// applyCmd returns the apply subcommand
+func applyCmd() *cobra.Command {
+	applyCmd := &cobra.Command{
+		Use:   "apply",
+		Short: "Create, update or delete networks to match a manifest",
+		Long: `Reconcile the libvirt networks with the ones declared in a YAML or JSON manifest, for e.g.
+
+  uri: qemu:///system
+  networks:
+    - name: lab-1
+      bridge: virbr-lab1
+      subnet: 10.89.0.1/24
+      forwardMode: nat
+    - name: lab-2
+      subnet: 10.90.0.1/24
+      subnetV6: fd00:90::/64
+
+Missing networks are created and drifted ones are updated in place like with update: DHCP ranges and autostart
+are changed live, the other changes take effect the next time the network is started. Networks whose subnets
+changed are recreated, keeping their DHCP hosts and DNS records. With --prune, networks created by netctl but
+missing from the manifest are deleted. Networks used by domains are never recreated or deleted.`,
+		Args: cobra.NoArgs,
+		RunE: applyManifest,
+	}
+
+	// add flags
+	addURIFlag(applyCmd)
+	applyCmd.Flags().StringVarP(&applyCmdArgs.File, "filename", "f", "", "Manifest to apply, - to read it from stdin")
+	applyCmd.Flags().BoolVar(&applyCmdArgs.Prune, "prune", false, "Delete the networks created by netctl that are not in the manifest")
+	applyCmd.Flags().BoolVar(&applyCmdArgs.DryRun, "dry-run", false, "Only show the changes that would be made")
+	applyCmd.Flags().StringVarP(&rootCmdArgs.Output, "output", "o", outputTable, "Output format (table, json or yaml)")
+	applyCmd.MarkFlagRequired("filename")
+
+	return applyCmd
+}
+
+func applyManifest(cmd *cobra.Command, args []string) error {
+	m, err := network.LoadManifest(applyCmdArgs.File)
+	if err != nil {
+		return err
+	}
+	for i := range m.Networks {
+		applyConfig(&m.Networks[i])
+	}
+	if err := m.Validate(); err != nil {
+		return err
+	}
+	if m.URI != "" && !cmd.Flags().Changed("uri") {
+		rootCmdArgs.ConnectionURI = m.URI
+	}
+
+	var changes []network.Change
+	err = withClient(func(c *network.Client) (err error) {
+		if applyCmdArgs.DryRun {
+			changes, err = c.Plan(cmd.Context(), m, applyCmdArgs.Prune)
+		} else {
+			changes, err = c.Apply(cmd.Context(), m, applyCmdArgs.Prune)
+		}
+		return err
+	})
+	if printErr := printChanges(cmd.OutOrStdout(), changes); printErr != nil && err == nil {
+		err = printErr
+	}
+	return err
+}
+
+// printChanges writes the changes planned or made by apply in the requested format
+func printChanges(w io.Writer, changes []network.Change) error {
+	if changes == nil {
+		changes = []network.Change{}
+	}
+	return printOutput(w, rootCmdArgs.Output, changes, func(w io.Writer) error {
+		fmt.Fprintln(w, "NETWORK\tACTION\tREASON")
+		for _, c := range changes {
+			reasons := c.Reasons
+			for _, pending := range c.Pending {
+				reasons = append(reasons, pending+" (on restart)")
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", c.Network, c.Action, orNone(strings.Join(reasons, "; ")))
+		}
+		return nil
+	})
+}
@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/day0ops/netctl/pkg/log"
+	"github.com/day0ops/netctl/pkg/network"
+)
+
+var backupCmdArgs struct {
+	File        string
+	Networks    []string
+	Rename      map[string]string
+	Subnets     []string
+	RemapSubnet bool
+	List        bool
+}
+
+// backupCmd returns the backup subcommand
+func backupCmd() *cobra.Command {
+	backupCmd := &cobra.Command{
+		Use:   "backup",
+		Short: "Save every network created by netctl, with its DHCP hosts, DNS records and IPAM allocation",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			file := backupCmdArgs.File
+			if file == "" {
+				file = fmt.Sprintf("netctl-backup-%s.tar.gz", time.Now().Format("20060102-150405"))
+			}
+			backup := func(w io.Writer) error {
+				return withClient(func(c *network.Client) error {
+					_, err := c.Backup(cmd.Context(), w)
+					return err
+				})
+			}
+			if file == "-" {
+				// keep the tarball written to stdout apart from the logs
+				log.SetOutWriter(cmd.ErrOrStderr())
+				return backup(cmd.OutOrStdout())
+			}
+			return writeBackupFile(file, backup)
+		},
+	}
+
+	// add flags
+	addURIFlag(backupCmd)
+	backupCmd.Flags().StringVarP(&backupCmdArgs.File, "output", "o", "", "File to write the backup to, - for stdout (default netctl-backup-<time>.tar.gz)")
+
+	return backupCmd
+}
+
+// writeBackupFile writes the backup to a temporary file next to file, renamed to it once complete, so a failed
+// backup leaves nothing behind. An existing file is not overwritten
+func writeBackupFile(file string, backup func(w io.Writer) error) error {
+	if _, err := os.Lstat(file); err == nil {
+		return fmt.Errorf("failed creating backup: %s already exists", file)
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed creating backup: %w", err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+".*")
+	if err != nil {
+		return fmt.Errorf("failed creating backup: %w", err)
+	}
+	err = backup(f)
+	if closeErr := f.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed writing backup: %w", closeErr)
+	}
+	if err == nil {
+		if err = os.Rename(f.Name(), file); err != nil {
+			err = fmt.Errorf("failed writing backup: %w", err)
+		}
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// restoreCmd returns the restore subcommand
+func restoreCmd() *cobra.Command {
+	restoreCmd := &cobra.Command{
+		Use:   "restore",
+		Short: "Recreate the networks of a backup",
+		Long: "Recreate the networks of a backup, or the ones selected with --network, and start them to verify they work. " +
+			"Networks already defined and subnets in use are refused, unless moved with --rename, --subnet or --remap-subnet.",
+		Args: cobra.NoArgs,
+		RunE: restoreNets,
+	}
+
+	// add flags
+	addURIFlag(restoreCmd)
+	restoreCmd.Flags().StringVarP(&backupCmdArgs.File, "filename", "f", "", "Backup to restore, - to read it from stdin")
+	restoreCmd.Flags().StringSliceVar(&backupCmdArgs.Networks, "network", nil, "Network of the backup to restore, can be repeated (default every network)")
+	restoreCmd.Flags().StringToStringVar(&backupCmdArgs.Rename, "rename", nil, "Restore a network under another name, can be repeated (for e.g. lab=lab-2)")
+	restoreCmd.Flags().StringArrayVar(&backupCmdArgs.Subnets, "subnet", nil, "Move a network to another subnet of the same size, can be repeated (for e.g. lab=10.90.0.1/24)")
+	restoreCmd.Flags().BoolVar(&backupCmdArgs.RemapSubnet, "remap-subnet", false, "Move networks to the next free subnets if theirs are in use")
+	restoreCmd.Flags().BoolVar(&backupCmdArgs.List, "list", false, "Only list the networks of the backup")
+	restoreCmd.Flags().StringVarP(&rootCmdArgs.Output, "output", "o", "", "Print the restored networks as a table, json or yaml")
+	restoreCmd.MarkFlagRequired("filename")
+
+	return restoreCmd
+}
+
+func restoreNets(cmd *cobra.Command, args []string) error {
+	if err := checkResultOutput(cmd); err != nil {
+		return err
+	}
+	subnets := map[string][]string{}
+	for _, s := range backupCmdArgs.Subnets {
+		name, cidr, ok := strings.Cut(s, "=")
+		if !ok || name == "" || isNotValidCIDR(cidr) {
+			return fmt.Errorf("invalid subnet %q (for e.g. it should be of the form lab=10.90.0.1/24)", s)
+		}
+		subnets[name] = append(subnets[name], cidr)
+	}
+
+	r := cmd.InOrStdin()
+	if backupCmdArgs.File != "-" {
+		f, err := os.Open(backupCmdArgs.File)
+		if err != nil {
+			return fmt.Errorf("failed reading backup: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	backup, err := network.ReadBackup(r)
+	if err != nil {
+		return err
+	}
+	if backupCmdArgs.List {
+		return printBackupIndex(cmd.OutOrStdout(), &backup.Index)
+	}
+
+	var results []*network.Result
+	err = withClient(func(c *network.Client) (err error) {
+		results, err = c.Restore(cmd.Context(), backup, network.RestoreOptions{
+			Networks:    backupCmdArgs.Networks,
+			Rename:      backupCmdArgs.Rename,
+			Subnets:     subnets,
+			RemapSubnet: backupCmdArgs.RemapSubnet,
+		})
+		return err
+	})
+	if rootCmdArgs.Output != "" && len(results) > 0 {
+		if printErr := printOutput(cmd.OutOrStdout(), rootCmdArgs.Output, results, func(w io.Writer) error {
+			fmt.Fprintln(w, resultHeader)
+			for _, r := range results {
+				writeResultRows(w, r)
+			}
+			return nil
+		}); printErr != nil && err == nil {
+			err = printErr
+		}
+	}
+	return err
+}
+
+// printBackupIndex writes the content of a backup in the requested format
+func printBackupIndex(w io.Writer, index *network.BackupIndex) error {
+	return printOutput(w, rootCmdArgs.Output, index, func(w io.Writer) error {
+		fmt.Fprintf(w, "version %d, created %s by netctl %s from %s\n\n", index.Version, index.Created.Format(time.RFC3339), index.NetctlVersion, index.URI)
+		fmt.Fprintln(w, "NAME\tAUTOSTART")
+		for _, n := range index.Networks {
+			fmt.Fprintf(w, "%s\t%t\n", n.Name, n.Autostart)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteBackupFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "backup.tar.gz")
+
+	failed := errors.New("backup failed")
+	err := writeBackupFile(file, func(w io.Writer) error {
+		io.WriteString(w, "partial")
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Errorf("writeBackupFile() error = %v, want %v", err, failed)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("failed backup left %d files behind", len(entries))
+	}
+
+	// a failed backup can be retried with the same file
+	if err := writeBackupFile(file, func(w io.Writer) error {
+		_, err := io.WriteString(w, "backup")
+		return err
+	}); err != nil {
+		t.Fatalf("writeBackupFile() error = %v", err)
+	}
+	if data, _ := os.ReadFile(file); string(data) != "backup" {
+		t.Errorf("backup file = %q, want %q", data, "backup")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("backup left %d files, want only the backup", len(entries))
+	}
+
+	if err := writeBackupFile(file, func(io.Writer) error { return nil }); err == nil {
+		t.Error("writeBackupFile() overwrote an existing backup")
+	}
+}
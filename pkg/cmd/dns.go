@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/day0ops/netctl/pkg/network"
+)
+
+var dnsCmdArgs network.DNSRecord
+
+// dnsCmd returns the dns subcommand
+func dnsCmd() *cobra.Command {
+	dnsCmd := &cobra.Command{
+		Use:   "dns",
+		Short: "Manage the DNS records of a network",
+		Long:  "Manage the host (A/AAAA), SRV and TXT records served by the DNS server of a network. Changes apply without restarting the network.",
+	}
+
+	addCmd := &cobra.Command{
+		Use:   "add <network>",
+		Short: "Add a DNS record",
+		Example: `  netctl dns add lab --ip 10.89.0.10 --hostname node-1 --hostname node-1.lab
+  netctl dns add lab --type srv --service ldap --protocol tcp --target ldap.lab --port 389
+  netctl dns add lab --type txt --name lab --value "owner=qa"`,
+		Args: cobra.ExactArgs(1),
+		RunE: addDNSRecord,
+	}
+	addDNSRecordFlags(addCmd)
+
+	removeCmd := &cobra.Command{
+		Use:   "remove <network>",
+		Short: "Remove a DNS record",
+		Long:  "Remove the host record with the given address, the TXT record with the given name, or the SRV record with the given service, protocol and target.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  removeDNSRecord,
+	}
+	addDNSRecordFlags(removeCmd)
+
+	listCmd := &cobra.Command{
+		Use:   "list <network>",
+		Short: "List DNS records",
+		Args:  cobra.ExactArgs(1),
+		RunE:  listDNSRecords,
+	}
+	addURIFlag(listCmd)
+	listCmd.Flags().StringVarP(&rootCmdArgs.Output, "output", "o", outputTable, "Output format (table, json or yaml)")
+
+	dnsCmd.AddCommand(addCmd, removeCmd, listCmd)
+	return dnsCmd
+}
+
+func addDNSRecordFlags(cmd *cobra.Command) {
+	addURIFlag(cmd)
+	cmd.Flags().StringVarP(&dnsCmdArgs.Type, "type", "t", network.DNSRecordHost, "Type of the record (host, srv or txt)")
+	cmd.Flags().StringVar(&dnsCmdArgs.IP, "ip", "", "Address of the host record")
+	cmd.Flags().StringSliceVar(&dnsCmdArgs.Hostnames, "hostname", nil, "Hostname of the host record, can be repeated")
+	cmd.Flags().StringVar(&dnsCmdArgs.Service, "service", "", "Service of the SRV record")
+	cmd.Flags().StringVar(&dnsCmdArgs.Protocol, "protocol", "tcp", "Protocol of the SRV record (tcp or udp)")
+	cmd.Flags().StringVar(&dnsCmdArgs.Domain, "domain", "", "Domain of the SRV record")
+	cmd.Flags().StringVar(&dnsCmdArgs.Target, "target", "", "Target host of the SRV record")
+	cmd.Flags().IntVar(&dnsCmdArgs.Port, "port", 0, "Target port of the SRV record")
+	cmd.Flags().IntVar(&dnsCmdArgs.Priority, "priority", 0, "Priority of the SRV record")
+	cmd.Flags().IntVar(&dnsCmdArgs.Weight, "weight", 0, "Weight of the SRV record")
+	cmd.Flags().StringVar(&dnsCmdArgs.Name, "name", "", "Name of the TXT record")
+	cmd.Flags().StringVar(&dnsCmdArgs.Value, "value", "", "Value of the TXT record")
+}
+
+func addDNSRecord(cmd *cobra.Command, args []string) error {
+	return withClient(func(c *network.Client) error {
+		return c.AddDNSRecord(cmd.Context(), args[0], dnsCmdArgs)
+	})
+}
+
+func removeDNSRecord(cmd *cobra.Command, args []string) error {
+	return withClient(func(c *network.Client) error {
+		return c.RemoveDNSRecord(cmd.Context(), args[0], dnsCmdArgs)
+	})
+}
+
+func listDNSRecords(cmd *cobra.Command, args []string) error {
+	var records []network.DNSRecord
+	err := withClient(func(c *network.Client) (err error) {
+		records, err = c.ListDNSRecords(cmd.Context(), args[0])
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return printOutput(cmd.OutOrStdout(), rootCmdArgs.Output, records, func(w io.Writer) error {
+		fmt.Fprintln(w, "TYPE\tNAME\tVALUE")
+		for _, r := range records {
+			switch r.Type {
+			case network.DNSRecordHost:
+				fmt.Fprintf(w, "%s\t%s\t%s\n", r.Type, strings.Join(r.Hostnames, ","), r.IP)
+			case network.DNSRecordSRV:
+				name := "_" + r.Service + "._" + r.Protocol
+				if r.Domain != "" {
+					name += "." + r.Domain
+				}
+				value := "-"
+				if r.Target != "" {
+					value = fmt.Sprintf("%d %d %d %s", r.Priority, r.Weight, r.Port, r.Target)
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\n", r.Type, name, value)
+			default:
+				fmt.Fprintf(w, "%s\t%s\t%s\n", r.Type, r.Name, strconv.Quote(r.Value))
+			}
+		}
+		return nil
+	})
+}
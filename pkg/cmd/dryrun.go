@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/day0ops/netctl/pkg/network"
+)
+
+// printCreatePlan writes what create would do, as the decisions followed by the XML unless json or yaml is requested
+func printCreatePlan(w io.Writer, p *network.CreatePlan) error {
+	if rootCmdArgs.Output == outputJSON || rootCmdArgs.Output == outputYAML {
+		return printOutput(w, rootCmdArgs.Output, p, nil)
+	}
+
+	for _, s := range p.Skipped {
+		fmt.Fprintf(w, "would skip subnet %s that %s\n", s.CIDR, s.Reason)
+	}
+	switch {
+	case p.Outcome == network.OutcomeCreated:
+		fmt.Fprintf(w, "would create network %s\n", p.Name)
+	case p.Start:
+		fmt.Fprintf(w, "network %s exists but is inactive, would start it (or recreate it if that fails)\n", p.Name)
+	default:
+		fmt.Fprintf(w, "network %s exists and is active, would keep it\n", p.Name)
+	}
+	printPlannedSubnets(w, &p.Result)
+	fmt.Fprintln(w)
+	_, err := fmt.Fprintln(w, p.XML)
+	return err
+}
+
+// printDeletePlan writes what delete would do
+func printDeletePlan(w io.Writer, p *network.DeletePlan) error {
+	if rootCmdArgs.Output == outputJSON || rootCmdArgs.Output == outputYAML {
+		return printOutput(w, rootCmdArgs.Output, p, nil)
+	}
+
+	if p.Outcome == network.OutcomeAbsent {
+		_, err := fmt.Fprintf(w, "network %s does not exist, nothing to delete\n", p.Name)
+		return err
+	}
+	for _, i := range p.Domains {
+		action := "shut down domain " + i.Domain
+		if p.Cascade == network.CascadeDetach {
+			action = fmt.Sprintf("detach interface %s of domain %s", i.MAC, i.Domain)
+		}
+		fmt.Fprintf(w, "would %s (%s)\n", action, i.State)
+	}
+	if p.Active {
+		fmt.Fprintf(w, "would stop network %s\n", p.Name)
+	}
+	fmt.Fprintf(w, "would undefine network %s and release its IPAM allocation\n", p.Name)
+	printPlannedSubnets(w, &p.Result)
+	return nil
+}
+
+func printPlannedSubnets(w io.Writer, r *network.Result) {
+	fmt.Fprintf(w, "  bridge: %s\n", orNone(r.Bridge))
+	for _, s := range []*network.SubnetResult{r.Subnet, r.SubnetV6} {
+		if s == nil {
+			continue
+		}
+		fmt.Fprintf(w, "  subnet: %s, gateway %s", s.CIDR, s.Gateway)
+		if s.DHCPStart != "" {
+			fmt.Fprintf(w, ", DHCP range %s-%s", s.DHCPStart, s.DHCPEnd)
+		}
+		if s.VIP != "" {
+			fmt.Fprintf(w, ", VIP %s", s.VIP)
+		}
+		fmt.Fprintln(w)
+	}
+}
@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/day0ops/netctl/pkg/network"
+)
+
+var exportCmdArgs struct {
+	File   string
+	Format string
+	Remap  bool
+}
+
+// exportCmd returns the export subcommand
+func exportCmd() *cobra.Command {
+	exportCmd := &cobra.Command{
+		Use:   "export <name>",
+		Short: "Export the definition of a network, to import it on another hypervisor",
+		Long: "Export the definition of a network as its libvirt XML, which holds the netctl metadata, or as a " +
+			"manifest declaring it. The manifest leaves out the DHCP hosts and DNS records of the network.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var data []byte
+			err := withClient(func(c *network.Client) (err error) {
+				data, err = c.Export(cmd.Context(), args[0], exportCmdArgs.Format)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			if exportCmdArgs.File == "" || exportCmdArgs.File == "-" {
+				_, err = cmd.OutOrStdout().Write(data)
+				return err
+			}
+			if err := os.WriteFile(exportCmdArgs.File, data, 0o644); err != nil {
+				return fmt.Errorf("failed writing export: %w", err)
+			}
+			return nil
+		},
+	}
+
+	// add flags
+	addURIFlag(exportCmd)
+	exportCmd.Flags().StringVarP(&exportCmdArgs.File, "output", "o", "", "File to write the export to, stdout by default")
+	exportCmd.Flags().StringVar(&exportCmdArgs.Format, "format", network.ExportFormatXML, "Export format (xml or yaml)")
+
+	return exportCmd
+}
+
+// importCmd returns the import subcommand
+func importCmd() *cobra.Command {
+	importCmd := &cobra.Command{
+		Use:   "import",
+		Short: "Define and start a network exported with export",
+		Long: "Define and start a network exported with export, refusing it if a network has the same name or if its " +
+			"subnets are used by the local interfaces or the other networks. With --remap-subnet, the network moves " +
+			"to the next free subnets instead, keeping the offsets of its addresses.",
+		Args: cobra.NoArgs,
+		RunE: importNet,
+	}
+
+	// add flags
+	addURIFlag(importCmd)
+	addResultFlag(importCmd)
+	importCmd.Flags().StringVarP(&exportCmdArgs.File, "filename", "f", "", "Export to import, - to read it from stdin")
+	importCmd.Flags().BoolVar(&exportCmdArgs.Remap, "remap-subnet", false, "Move the network to the next free subnets if its own are in use")
+	importCmd.MarkFlagRequired("filename")
+
+	return importCmd
+}
+
+func importNet(cmd *cobra.Command, args []string) error {
+	if err := checkResultOutput(cmd); err != nil {
+		return err
+	}
+	var data []byte
+	var err error
+	if exportCmdArgs.File == "-" {
+		data, err = io.ReadAll(cmd.InOrStdin())
+	} else {
+		data, err = os.ReadFile(exportCmdArgs.File)
+	}
+	if err != nil {
+		return fmt.Errorf("failed reading export: %w", err)
+	}
+
+	var r *network.Result
+	err = withClient(func(c *network.Client) (err error) {
+		r, err = c.Import(cmd.Context(), data, network.ImportOptions{RemapSubnet: exportCmdArgs.Remap})
+		return err
+	})
+	if errors.Is(err, network.ErrSubnetCollision) {
+		return fmt.Errorf("%w (use --remap-subnet to move it to a free subnet)", err)
+	}
+	if err != nil {
+		return err
+	}
+	return printResult(cmd.OutOrStdout(), r)
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+
+	"github.com/day0ops/netctl/pkg/network"
+)
+
+var hostCmdArgs struct {
+	network.Host
+	File string
+}
+
+// hostCmd returns the host subcommand
+func hostCmd() *cobra.Command {
+	hostCmd := &cobra.Command{
+		Use:   "host",
+		Short: "Manage DHCP static host reservations of a network",
+	}
+
+	addCmd := &cobra.Command{
+		Use:   "add <network>",
+		Short: "Reserve addresses for hosts",
+		Long: `Reserve an address for a host given by its MAC, or for every host of a file with -f.
+Files are CSV if they end with .csv (mac,name,ip columns, with an optional header), YAML or JSON lists otherwise.`,
+		Args: cobra.ExactArgs(1),
+		RunE: addHosts,
+	}
+	addHostFlags(addCmd)
+
+	removeCmd := &cobra.Command{
+		Use:   "remove <network>",
+		Short: "Remove host reservations",
+		Long:  "Remove the reservation matching all of the given MAC, name and address, or every reservation of a file with -f.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  removeHosts,
+	}
+	addHostFlags(removeCmd)
+
+	listCmd := &cobra.Command{
+		Use:   "list <network>",
+		Short: "List host reservations",
+		Args:  cobra.ExactArgs(1),
+		RunE:  listHosts,
+	}
+	addURIFlag(listCmd)
+	listCmd.Flags().StringVarP(&rootCmdArgs.Output, "output", "o", outputTable, "Output format (table, json or yaml)")
+
+	hostCmd.AddCommand(addCmd, removeCmd, listCmd)
+	return hostCmd
+}
+
+func addHostFlags(cmd *cobra.Command) {
+	addURIFlag(cmd)
+	cmd.Flags().StringVar(&hostCmdArgs.MAC, "mac", "", "MAC address of the host")
+	cmd.Flags().StringVar(&hostCmdArgs.Name, "hostname", "", "Hostname of the host")
+	cmd.Flags().StringVar(&hostCmdArgs.IP, "ip", "", "IPv4 address of the host")
+	cmd.Flags().StringVarP(&hostCmdArgs.File, "filename", "f", "", "CSV, YAML or JSON file of hosts, - to read YAML from stdin")
+	cmd.MarkFlagsMutuallyExclusive("filename", "mac")
+	cmd.MarkFlagsMutuallyExclusive("filename", "hostname")
+	cmd.MarkFlagsMutuallyExclusive("filename", "ip")
+}
+
+// hostsArg returns the hosts given on the command line
+func hostsArg() ([]network.Host, error) {
+	if hostCmdArgs.File != "" {
+		return network.LoadHosts(hostCmdArgs.File)
+	}
+	if hostCmdArgs.Host == (network.Host{}) {
+		return nil, fmt.Errorf("a host (--mac, --hostname, --ip) or a file of hosts (-f) is required")
+	}
+	return []network.Host{hostCmdArgs.Host}, nil
+}
+
+func addHosts(cmd *cobra.Command, args []string) error {
+	hosts, err := hostsArg()
+	if err != nil {
+		return err
+	}
+	return withClient(func(c *network.Client) error {
+		return c.AddHosts(cmd.Context(), args[0], hosts)
+	})
+}
+
+func removeHosts(cmd *cobra.Command, args []string) error {
+	hosts, err := hostsArg()
+	if err != nil {
+		return err
+	}
+	return withClient(func(c *network.Client) error {
+		return c.RemoveHosts(cmd.Context(), args[0], hosts)
+	})
+}
+
+func listHosts(cmd *cobra.Command, args []string) error {
+	var hosts []network.Host
+	err := withClient(func(c *network.Client) (err error) {
+		hosts, err = c.ListHosts(cmd.Context(), args[0])
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return printOutput(cmd.OutOrStdout(), rootCmdArgs.Output, hosts, func(w io.Writer) error {
+		fmt.Fprintln(w, "MAC\tNAME\tIP")
+		for _, h := range hosts {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", orNone(h.MAC), orNone(h.Name), h.IP)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/day0ops/netctl/pkg/network"
+)
+
+// inspectCmd returns the inspect subcommand
+func inspectCmd() *cobra.Command {
+	inspectCmd := &cobra.Command{
+		Use:   "inspect <name>",
+		Short: "Display detailed information about a network",
+		Args:  cobra.ExactArgs(1),
+		RunE:  inspectNet,
+	}
+
+	// add flags
+	addURIFlag(inspectCmd)
+	inspectCmd.Flags().StringVarP(&rootCmdArgs.Output, "output", "o", outputJSON, "Output format (json or yaml)")
+
+	return inspectCmd
+}
+
+func inspectNet(cmd *cobra.Command, args []string) error {
+	var details *network.Details
+	err := withClient(func(c *network.Client) (err error) {
+		details, err = c.Inspect(cmd.Context(), args[0])
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return printOutput(cmd.OutOrStdout(), rootCmdArgs.Output, details, nil)
+}
@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/day0ops/netctl/pkg/ipam"
+	"github.com/day0ops/netctl/pkg/log"
+	"github.com/day0ops/netctl/pkg/network"
+)
+
+var ipamCmdArgs struct {
+	DryRun bool
+}
+
+// ipamCmd returns the ipam subcommand
+func ipamCmd() *cobra.Command {
+	ipamCmd := &cobra.Command{
+		Use:   "ipam",
+		Short: "Manage the subnet allocations database",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := initLog(); err != nil {
+				return err
+			}
+			if rootCmdArgs.IPAMPath == "" {
+				return fmt.Errorf("no IPAM database configured (see --ipam-db)")
+			}
+			return nil
+		},
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List subnet allocations",
+		Args:  cobra.NoArgs,
+		RunE:  listAllocations,
+	}
+	listCmd.Flags().StringVarP(&rootCmdArgs.Output, "output", "o", outputTable, "Output format (table, json or yaml)")
+
+	releaseCmd := &cobra.Command{
+		Use:   "release <network>",
+		Short: "Release the subnet allocation of a network",
+		Args:  cobra.ExactArgs(1),
+		RunE:  releaseAllocation,
+	}
+	addURIFlag(releaseCmd)
+
+	reconcileCmd := &cobra.Command{
+		Use:   "reconcile",
+		Short: "Repair subnet allocations drifted from the libvirt networks",
+		Long: "Repair subnet allocations drifted from the libvirt networks: the allocations of networks that no longer exist " +
+			"are released, the ones whose subnets changed are updated, and the subnets of networks created by netctl " +
+			"without an allocation are reserved.",
+		Args: cobra.NoArgs,
+		RunE: reconcileAllocations,
+	}
+	addURIFlag(reconcileCmd)
+	reconcileCmd.Flags().BoolVar(&ipamCmdArgs.DryRun, "dry-run", false, "Only show the changes that would be made")
+	reconcileCmd.Flags().StringVarP(&rootCmdArgs.Output, "output", "o", outputTable, "Output format (table, json or yaml)")
+
+	ipamCmd.AddCommand(listCmd, releaseCmd, reconcileCmd)
+	return ipamCmd
+}
+
+func listAllocations(cmd *cobra.Command, args []string) error {
+	allocs, err := ipam.NewStore(rootCmdArgs.IPAMPath).List()
+	if err != nil {
+		return err
+	}
+	if allocs == nil {
+		allocs = []ipam.Allocation{}
+	}
+
+	return printOutput(cmd.OutOrStdout(), rootCmdArgs.Output, allocs, func(w io.Writer) error {
+		fmt.Fprintln(w, "NETWORK\tSUBNETS\tURI\tOWNER\tCREATED")
+		for _, a := range allocs {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", a.Network, orNone(strings.Join(a.CIDRs, ",")), a.URI, a.Owner, a.Created.Local().Format(time.RFC3339))
+		}
+		return nil
+	})
+}
+
+func releaseAllocation(cmd *cobra.Command, args []string) error {
+	released, err := ipam.NewStore(rootCmdArgs.IPAMPath).Release(rootCmdArgs.ConnectionURI, args[0])
+	if err != nil {
+		return err
+	}
+	if !released {
+		log.Warnf("network %s has no allocation on %s", args[0], rootCmdArgs.ConnectionURI)
+		return nil
+	}
+	log.Infof("released allocation of network %s", args[0])
+	return nil
+}
+
+func reconcileAllocations(cmd *cobra.Command, args []string) error {
+	var changes []network.IPAMChange
+	err := withClient(func(c *network.Client) (err error) {
+		changes, err = c.ReconcileIPAM(cmd.Context(), ipamCmdArgs.DryRun)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return printOutput(cmd.OutOrStdout(), rootCmdArgs.Output, changes, func(w io.Writer) error {
+		if len(changes) == 0 {
+			fmt.Fprintln(w, "allocations are in sync")
+			return nil
+		}
+		fmt.Fprintln(w, "NETWORK\tACTION\tSUBNETS")
+		for _, c := range changes {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", c.Network, c.Action, orNone(strings.Join(c.CIDRs, ",")))
+		}
+		return nil
+	})
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/day0ops/netctl/pkg/network"
+)
+
+var leasesCmdArgs struct {
+	Watch    bool
+	Interval time.Duration
+}
+
+// leasesCmd returns the leases subcommand
+func leasesCmd() *cobra.Command {
+	leasesCmd := &cobra.Command{
+		Use:   "leases <network>",
+		Short: "Show the DHCP leases of a network and the domains holding them",
+		Args:  cobra.ExactArgs(1),
+		RunE:  showLeases,
+	}
+
+	// add flags
+	addURIFlag(leasesCmd)
+	leasesCmd.Flags().StringVarP(&rootCmdArgs.Output, "output", "o", outputTable, "Output format (table, json or yaml)")
+	leasesCmd.Flags().BoolVarP(&leasesCmdArgs.Watch, "watch", "w", false, "Keep running and show the leases again whenever they change")
+	leasesCmd.Flags().DurationVar(&leasesCmdArgs.Interval, "interval", 2*time.Second, "How often leases are checked for changes with --watch")
+
+	return leasesCmd
+}
+
+func showLeases(cmd *cobra.Command, args []string) error {
+	show := func(leases []network.Lease) error {
+		return printOutput(cmd.OutOrStdout(), rootCmdArgs.Output, leases, func(w io.Writer) error {
+			if leasesCmdArgs.Watch {
+				fmt.Fprintf(w, "# %s\n", time.Now().Format(time.RFC3339))
+			}
+			fmt.Fprintln(w, "IP\tMAC\tHOSTNAME\tEXPIRY\tDOMAIN")
+			for _, l := range leases {
+				fmt.Fprintf(w, "%s/%d\t%s\t%s\t%s\t%s\n", l.IP, l.Prefix, l.MAC, orNone(l.Hostname), l.Expiry.Local().Format(time.DateTime), orNone(l.Domain))
+			}
+			return nil
+		})
+	}
+
+	return withClient(func(c *network.Client) error {
+		if leasesCmdArgs.Watch {
+			if leasesCmdArgs.Interval <= 0 {
+				return fmt.Errorf("interval must be positive, got %s", leasesCmdArgs.Interval)
+			}
+			return c.WatchLeases(cmd.Context(), args[0], leasesCmdArgs.Interval, show)
+		}
+		leases, err := c.Leases(cmd.Context(), args[0])
+		if err != nil {
+			return err
+		}
+		return show(leases)
+	})
+}
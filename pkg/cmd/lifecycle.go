@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/day0ops/netctl/pkg/network"
+)
+
+var lifecycleCmdArgs struct {
+	Force   bool
+	Enable  bool
+	Disable bool
+}
+
+// startCmd returns the start subcommand
+func startCmd() *cobra.Command {
+	startCmd := &cobra.Command{
+		Use:   "start <name>",
+		Short: "Start an inactive network",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withClient(func(c *network.Client) error {
+				return c.StartNetwork(cmd.Context(), args[0])
+			})
+		},
+	}
+
+	// add flags
+	addURIFlag(startCmd)
+
+	return startCmd
+}
+
+// stopCmd returns the stop subcommand
+func stopCmd() *cobra.Command {
+	stopCmd := &cobra.Command{
+		Use:   "stop <name>",
+		Short: "Stop a network without undefining it, removing its bridge until it is started again",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			err := withClient(func(c *network.Client) error {
+				return c.StopNetwork(cmd.Context(), args[0], lifecycleCmdArgs.Force)
+			})
+			var inUse *network.DomainsInUseError
+			if errors.As(err, &inUse) {
+				return fmt.Errorf("%w (use --force to stop it anyway)", err)
+			}
+			return err
+		},
+	}
+
+	// add flags
+	addURIFlag(stopCmd)
+	stopCmd.Flags().BoolVar(&lifecycleCmdArgs.Force, "force", false, "Stop the network even if domains that are not shut off use it")
+
+	return stopCmd
+}
+
+// autostartCmd returns the autostart subcommand
+func autostartCmd() *cobra.Command {
+	autostartCmd := &cobra.Command{
+		Use:   "autostart <name>",
+		Short: "Configure whether a network is started on host boot",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withClient(func(c *network.Client) error {
+				return c.SetAutostart(cmd.Context(), args[0], lifecycleCmdArgs.Enable)
+			})
+		},
+	}
+
+	// add flags
+	addURIFlag(autostartCmd)
+	autostartCmd.Flags().BoolVar(&lifecycleCmdArgs.Enable, "enable", false, "Start the network on host boot")
+	autostartCmd.Flags().BoolVar(&lifecycleCmdArgs.Disable, "disable", false, "Don't start the network on host boot")
+	autostartCmd.MarkFlagsMutuallyExclusive("enable", "disable")
+	autostartCmd.MarkFlagsOneRequired("enable", "disable")
+
+	return autostartCmd
+}
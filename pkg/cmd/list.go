@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/day0ops/netctl/pkg/network"
+)
+
+var listCmdArgs network.ListFilter
+
+// listCmd returns the list subcommand
+func listCmd() *cobra.Command {
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List networks",
+		RunE:  listNets,
+	}
+
+	// add flags
+	addURIFlag(listCmd)
+	listCmd.Flags().StringVarP(&rootCmdArgs.Output, "output", "o", outputTable, "Output format (table, json or yaml)")
+	listCmd.Flags().BoolVar(&listCmdArgs.Managed, "managed", false, "Only list the networks created by netctl")
+	listCmd.Flags().StringToStringVarP(&listCmdArgs.Labels, "selector", "l", nil, "Only list the networks created by netctl with these labels (for e.g. team=qa)")
+
+	return listCmd
+}
+
+func listNets(cmd *cobra.Command, args []string) error {
+	var infos []network.NetworkInfo
+	err := withClient(func(c *network.Client) (err error) {
+		infos, err = c.ListNetworks(cmd.Context(), listCmdArgs)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return printOutput(cmd.OutOrStdout(), rootCmdArgs.Output, infos, func(w io.Writer) error {
+		fmt.Fprintln(w, "NAME\tSUBNET\tBRIDGE\tFORWARD\tSTATE\tAUTOSTART\tMANAGED\tDOMAINS")
+		for _, info := range infos {
+			state := "inactive"
+			if info.Active {
+				state = "active"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%t\t%t\t%s\n",
+				info.Name, orNone(strings.Trim(info.Subnet+","+info.SubnetV6, ",")), orNone(info.Bridge), info.ForwardMode, state, info.Autostart, info.Metadata != nil, orNone(strings.Join(info.Domains, ",")))
+		}
+		return nil
+	})
+}
+
+// orNone returns "-" for empty table cells
+func orNone(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
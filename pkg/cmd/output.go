@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+// printOutput writes v to w in the requested format. table is used to render the table format, a nil table
+// means the value has no table representation
+func printOutput(w io.Writer, format string, v interface{}, table func(w io.Writer) error) error {
+	switch format {
+	case outputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case outputYAML:
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		return enc.Close()
+	case outputTable, "":
+		if table == nil {
+			return fmt.Errorf("output format %q is not supported here (must be one of %s or %s)", format, outputJSON, outputYAML)
+		}
+		tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+		if err := table(tw); err != nil {
+			return err
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unsupported output format %q (must be one of %s, %s or %s)", format, outputTable, outputJSON, outputYAML)
+	}
+}
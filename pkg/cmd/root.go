@@ -1,12 +1,22 @@
 package cmd
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 
 	"github.com/day0ops/netctl/pkg/config"
+	"github.com/day0ops/netctl/pkg/ipam"
 	"github.com/day0ops/netctl/pkg/log"
 	"github.com/day0ops/netctl/pkg/network"
 )
@@ -15,9 +25,22 @@ var versionInfo = config.AppVersion()
 
 var rootCmdArgs struct {
 	network.Network
-	Verbose bool
+	Verbose     bool
+	LogFormat   string
+	LogLevel    string
+	LogFile     string
+	Output      string
+	SubnetCIDRs []string
+	IPAMPath    string
+	Yes         bool
+	DryRun      bool
+	ConfigFile  string
+	network.DeleteOptions
 }
 
+// cfg is the configuration file loaded before running a command
+var cfg = &config.File{}
+
 var rootCmd = &cobra.Command{
 	Use:     "netctl",
 	Short:   "For managing libvirt domains and networks",
@@ -25,15 +48,21 @@ var rootCmd = &cobra.Command{
 	Version: versionInfo.Version,
 	Args:    cobra.MaximumNArgs(1),
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
-		err := initLog()
-		return err
+		if err := initLog(); err != nil {
+			return err
+		}
+		return initConfig(cmd)
 	},
 }
 
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
 		log.Error(err)
 	}
+	closeLog()
 }
 
 // createCmd returns the create subcommand
@@ -43,26 +72,48 @@ func createCmd() *cobra.Command {
 		Short: "Create network",
 		RunE:  createNet,
 		PreRunE: func(cmd *cobra.Command, args []string) error {
-			if isNotValidCIDR(rootCmdArgs.Subnet) {
-				return fmt.Errorf("invalid CIDR value provided (for e.g. it should be of the form 10.89.0.1/24): %v", rootCmdArgs.Subnet)
+			if err := splitSubnets(rootCmdArgs.SubnetCIDRs); err != nil {
+				return err
 			}
-			return nil
+			applyConfig(&rootCmdArgs.Network)
+			return rootCmdArgs.Network.Validate()
 		},
 	}
 
 	// add flags
 	addCommonFlags(createCmd)
-	createCmd.Flags().StringVarP(&rootCmdArgs.Bridge, "bridge", "b", config.DefaultBridge, "Name of the network bridge")
-	createCmd.Flags().StringVarP(&rootCmdArgs.Subnet, "subnet-cidr", "s", "", "Subnet of the network (for e.g. 10.89.0.1/24")
-	createCmd.Flags().StringVarP(&rootCmdArgs.ConnectionURI, "uri", "u", config.DefaultQemuSystem, "libvirt connection URI")
+	addNetworkFlags(createCmd)
+	addURIFlag(createCmd)
+	addResultFlag(createCmd)
+	createCmd.Flags().BoolVar(&rootCmdArgs.DryRun, "dry-run", false, "Show the subnets, decisions and XML of the network without creating it")
 	createCmd.MarkFlagRequired("subnet-cidr")
 
 	return createCmd
 }
 
 func createNet(cmd *cobra.Command, args []string) error {
+	if err := checkResultOutput(cmd); err != nil {
+		return err
+	}
 	n := rootCmdArgs.Network
-	return n.EnsureNetwork()
+	if rootCmdArgs.DryRun {
+		return withClient(func(c *network.Client) error {
+			p, err := c.PlanCreate(cmd.Context(), &n)
+			if err != nil {
+				return err
+			}
+			return printCreatePlan(cmd.OutOrStdout(), p)
+		})
+	}
+	var r *network.Result
+	err := withClient(func(c *network.Client) (err error) {
+		r, err = c.EnsureNetwork(cmd.Context(), &n)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return printResult(cmd.OutOrStdout(), r)
 }
 
 // deleteCmd returns the delete subcommand
@@ -75,13 +126,151 @@ func deleteCmd() *cobra.Command {
 
 	// add flags
 	addCommonFlags(deleteCmd)
+	addURIFlag(deleteCmd)
+	addResultFlag(deleteCmd)
+	deleteCmd.Flags().BoolVar(&rootCmdArgs.DryRun, "dry-run", false, "Show what would be stopped, released and undefined without deleting anything")
+	deleteCmd.Flags().BoolVar(&rootCmdArgs.Force, "force", false, "Delete the network even if it was not created by netctl")
+	deleteCmd.Flags().StringVar(&rootCmdArgs.Cascade, "cascade", "", "Release the domains using the network first: shutdown stops them (the default when no value is given), detach removes their interfaces on it")
+	deleteCmd.Flags().Lookup("cascade").NoOptDefVal = network.CascadeShutdown
+	deleteCmd.Flags().DurationVar(&rootCmdArgs.ShutdownTimeout, "shutdown-timeout", 2*time.Minute, "How long to wait for a domain to shut down gracefully before destroying it")
+	deleteCmd.Flags().BoolVarP(&rootCmdArgs.Yes, "yes", "y", false, "Don't ask for confirmation before releasing domains")
 
 	return deleteCmd
 }
 
 func deleteNet(cmd *cobra.Command, args []string) error {
-	n := rootCmdArgs.Network
-	return n.DeleteNetwork()
+	if err := checkResultOutput(cmd); err != nil {
+		return err
+	}
+	opts := rootCmdArgs.DeleteOptions
+	var r *network.Result
+	err := withClient(func(c *network.Client) (err error) {
+		if rootCmdArgs.DryRun {
+			p, err := c.PlanDelete(cmd.Context(), rootCmdArgs.Name, opts)
+			if err != nil {
+				return err
+			}
+			return printDeletePlan(cmd.OutOrStdout(), p)
+		}
+		if opts.Cascade != "" && !rootCmdArgs.Yes {
+			ok, err := confirmCascade(cmd, c, opts.Cascade)
+			if err != nil || !ok {
+				return err
+			}
+		}
+		r, err = c.DeleteNetwork(cmd.Context(), rootCmdArgs.Name, opts)
+		return err
+	})
+	if errors.Is(err, network.ErrNotManaged) {
+		return fmt.Errorf("%w (use --force to delete it anyway)", err)
+	}
+	if err != nil || r == nil {
+		return err
+	}
+	return printResult(cmd.OutOrStdout(), r)
+}
+
+// addNetworkFlags adds the flags describing the desired network, shared by create and update
+func addNetworkFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&rootCmdArgs.Bridge, "bridge", "b", config.DefaultBridge, "Name of the network bridge")
+	cmd.Flags().StringSliceVarP(&rootCmdArgs.SubnetCIDRs, "subnet-cidr", "s", nil, "Subnet of the network, can be given once per IP family for dual-stack (for e.g. 10.89.0.1/24,fd00:89::/64)")
+	cmd.Flags().IntVar(&rootCmdArgs.Step, "step", 0, fmt.Sprintf("Number of subnet-sized blocks to skip when a subnet is taken (default %d for IPv4, %d for IPv6)", config.DefaultSubnetStep, config.DefaultSubnetStepV6))
+	cmd.Flags().IntVar(&rootCmdArgs.Tries, "tries", config.DefaultSubnetTries, "Number of subnets to try before giving up")
+	cmd.Flags().StringSliceVar(&rootCmdArgs.ContainerRuntimes, "check-container-networks", nil, "Container runtimes whose networks must not overlap with the subnet (docker, podman)")
+	cmd.Flags().StringVar(&rootCmdArgs.DHCPRange, "dhcp-range", "", "DHCP range of the IPv4 subnet (for e.g. 10.89.0.100-10.89.0.200), the whole subnet but the gateway and the VIP by default")
+	cmd.Flags().StringVar(&rootCmdArgs.DHCPRangeV6, "dhcp-range-v6", "", "DHCPv6 range of the IPv6 subnet (for e.g. fd00:89::100-fd00:89::1ff)")
+	cmd.Flags().StringVar(&rootCmdArgs.IPv6Mode, "ipv6-mode", "", "How IPv6 clients get their address: dhcp (the default) or ra for router advertisements only")
+	cmd.Flags().StringVar(&rootCmdArgs.ForwardMode, "forward-mode", config.DefaultForwardMode, "Forward mode of the network (none, nat, route or open)")
+	cmd.Flags().StringVar(&rootCmdArgs.ForwardDev, "forward-dev", "", "Host device to forward traffic to (nat and route modes only)")
+	cmd.Flags().IntVar(&rootCmdArgs.NATPortStart, "nat-port-start", 0, "First source port used when masquerading (nat mode only)")
+	cmd.Flags().IntVar(&rootCmdArgs.NATPortEnd, "nat-port-end", 0, "Last source port used when masquerading (nat mode only)")
+	cmd.Flags().BoolVar(&rootCmdArgs.DNS, "dns", false, "Enable the DNS server of the network")
+	cmd.Flags().StringVar(&rootCmdArgs.Domain, "domain", "", "Domain of the network, DHCP clients are resolvable in")
+	cmd.Flags().BoolVar(&rootCmdArgs.DomainLocalOnly, "domain-local-only", false, "Never forward queries for names in the domain upstream")
+	cmd.Flags().StringSliceVar(&rootCmdArgs.DNSForwarders, "dns-forwarder", nil, "Upstream DNS server, can be repeated (for e.g. 1.1.1.1 or corp.example.com=10.0.0.53 for a single domain)")
+	cmd.Flags().StringToStringVarP(&rootCmdArgs.Labels, "label", "l", nil, "Label recorded in the network metadata, can be repeated (for e.g. team=qa)")
+	cmd.Flags().BoolVar(&rootCmdArgs.NoAutostart, "no-autostart", false, "Don't start the network on host boot")
+	cmd.Flags().StringVar(&rootCmdArgs.Template, "template", "", "Go template of the network XML replacing the built-in one, rendered with the same data (default the template of the config file)")
+	cmd.Flags().StringToStringVar(&rootCmdArgs.TemplateVars, "set", nil, "Variable given to the template as .Vars.<key>, can be repeated (for e.g. mtu=9000)")
+}
+
+// addResultFlag adds the flag selecting how the result of create or delete is printed
+func addResultFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&rootCmdArgs.Output, "output", "o", "", "Print the resulting network addressing as a table, json or yaml")
+}
+
+// checkResultOutput validates the requested result format. Informational logs are moved to stderr once the result
+// is printed, so stdout stays parseable
+func checkResultOutput(cmd *cobra.Command) error {
+	switch rootCmdArgs.Output {
+	case "":
+		return nil
+	case outputTable, outputJSON, outputYAML:
+		log.SetOutWriter(cmd.ErrOrStderr())
+		return nil
+	}
+	return fmt.Errorf("unsupported output format %q (must be one of %s, %s or %s)", rootCmdArgs.Output, outputTable, outputJSON, outputYAML)
+}
+
+// printResult writes the result of create or delete in the requested format, nothing if none was requested
+func printResult(w io.Writer, r *network.Result) error {
+	if rootCmdArgs.Output == "" {
+		return nil
+	}
+	return printOutput(w, rootCmdArgs.Output, r, func(w io.Writer) error {
+		fmt.Fprintln(w, resultHeader)
+		writeResultRows(w, r)
+		return nil
+	})
+}
+
+const resultHeader = "NAME\tOUTCOME\tBRIDGE\tSUBNET\tGATEWAY\tDHCP RANGE\tVIP"
+
+// writeResultRows writes the table rows of a result, one per subnet
+func writeResultRows(w io.Writer, r *network.Result) {
+	for _, s := range []*network.SubnetResult{r.Subnet, r.SubnetV6} {
+		if s == nil {
+			continue
+		}
+		dhcpRange := ""
+		if s.DHCPStart != "" {
+			dhcpRange = s.DHCPStart + "-" + s.DHCPEnd
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.Name, r.Outcome, orNone(r.Bridge), s.CIDR, s.Gateway, orNone(dhcpRange), orNone(s.VIP))
+	}
+	if r.Subnet == nil && r.SubnetV6 == nil {
+		fmt.Fprintf(w, "%s\t%s\t%s\t-\t-\t-\t-\n", r.Name, r.Outcome, orNone(r.Bridge))
+	}
+}
+
+// confirmCascade lists the domains the cascade would release and asks whether to go on
+func confirmCascade(cmd *cobra.Command, c *network.Client, cascade string) (bool, error) {
+	ifaces, err := c.DomainsUsing(cmd.Context(), rootCmdArgs.Name)
+	if err != nil || len(ifaces) == 0 {
+		return err == nil, err
+	}
+
+	w := cmd.ErrOrStderr()
+	fmt.Fprintf(w, "network %s is used by:\n", rootCmdArgs.Name)
+	for _, i := range ifaces {
+		fmt.Fprintf(w, "  domain %s (%s), interface %s\n", i.Domain, i.State, i.MAC)
+	}
+	action := "shut down (or destroyed if they don't stop in time)"
+	if cascade == network.CascadeDetach {
+		action = "detached from the network"
+	}
+	fmt.Fprintf(w, "They will be %s. Continue? [y/N] ", action)
+
+	answer, err := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	}
+	log.Info("aborted, network not deleted")
+	return false, nil
 }
 
 func addCommonFlags(cmd *cobra.Command) {
@@ -89,21 +278,174 @@ func addCommonFlags(cmd *cobra.Command) {
 	cmd.MarkFlagRequired("name")
 }
 
+func addURIFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&rootCmdArgs.ConnectionURI, "uri", "u", config.DefaultQemuSystem, "libvirt connection URI")
+}
+
+// newClient opens the network client used by the commands, replaced by tests
+var newClient = network.NewClient
+
+// withClient runs f with a network client connected to the libvirt connection URI given on the command line
+func withClient(f func(c *network.Client) error) error {
+	c, err := newClient(rootCmdArgs.ConnectionURI)
+	if err != nil {
+		return err
+	}
+	if rootCmdArgs.IPAMPath != "" {
+		c.WithIPAM(ipam.NewStore(rootCmdArgs.IPAMPath))
+	}
+	defer func() {
+		if err := c.Close(); err != nil {
+			log.Error(err)
+		}
+	}()
+	return f(c)
+}
+
 func init() {
 	rootCmd.PersistentFlags().BoolVarP(&rootCmdArgs.Verbose, "verbose", "v", rootCmdArgs.Verbose, "enable verbose log")
+	rootCmd.PersistentFlags().StringVar(&rootCmdArgs.LogFormat, "log-format", logFormatPlain, "Log format: plain messages, or text (key=value) and json with level, time and fields")
+	rootCmd.PersistentFlags().StringVar(&rootCmdArgs.LogLevel, "log-level", "info", "Minimum level of the logged messages (trace, debug, info, warn or error)")
+	rootCmd.PersistentFlags().StringVar(&rootCmdArgs.LogFile, "log-file", "", "Append logs to this file instead of writing them to the terminal")
+	rootCmd.PersistentFlags().StringVar(&rootCmdArgs.IPAMPath, "ipam-db", ipam.DefaultPath(), "Path of the IPAM database recording subnet allocations (empty to disable)")
+	rootCmd.PersistentFlags().StringVar(&rootCmdArgs.ConfigFile, "config", config.DefaultFilePath(), "Path of the configuration file")
 
 	rootCmd.AddCommand(createCmd())
 	rootCmd.AddCommand(deleteCmd())
+	rootCmd.AddCommand(listCmd())
+	rootCmd.AddCommand(inspectCmd())
+	rootCmd.AddCommand(ipamCmd())
+	rootCmd.AddCommand(applyCmd())
+	rootCmd.AddCommand(hostCmd())
+	rootCmd.AddCommand(dnsCmd())
+	rootCmd.AddCommand(leasesCmd())
+	rootCmd.AddCommand(watchCmd())
+	rootCmd.AddCommand(startCmd())
+	rootCmd.AddCommand(stopCmd())
+	rootCmd.AddCommand(autostartCmd())
+	rootCmd.AddCommand(updateCmd())
+	rootCmd.AddCommand(exportCmd())
+	rootCmd.AddCommand(importCmd())
+	rootCmd.AddCommand(backupCmd())
+	rootCmd.AddCommand(restoreCmd())
 }
 
+// logFormatPlain logs messages alone, informational ones to stdout and the others to stderr
+const logFormatPlain = "plain"
+
+// logFile is the file given with --log-file, once opened
+var logFile *os.File
+
 func initLog() error {
-	if rootCmdArgs.Verbose {
-		log.SetDebug(true)
+	switch rootCmdArgs.LogFormat {
+	case logFormatPlain, log.FormatText, log.FormatJSON:
+	default:
+		return fmt.Errorf("unsupported log format %q (must be one of %s, %s or %s)", rootCmdArgs.LogFormat, logFormatPlain, log.FormatText, log.FormatJSON)
+	}
+	level, err := log.ParseLevel(rootCmdArgs.LogLevel)
+	if err != nil {
+		return err
+	}
+	if rootCmdArgs.Verbose && level > log.LevelDebug {
+		level = log.LevelDebug
+	}
+
+	var w io.Writer
+	if rootCmdArgs.LogFile != "" {
+		f, err := os.OpenFile(rootCmdArgs.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("failed opening log file: %w", err)
+		}
+		logFile, w = f, f
+	}
+
+	if rootCmdArgs.LogFormat == logFormatPlain {
+		l := log.NewFmtMachineLogger()
+		if w != nil {
+			l.SetOutWriter(w)
+			l.SetErrWriter(w)
+		}
+		l.SetLevel(level)
+		log.SetLogger(l)
+		return nil
 	}
+	if w == nil {
+		w = os.Stderr
+	}
+	l, err := log.NewSlogLogger(w, rootCmdArgs.LogFormat, level)
+	if err != nil {
+		return err
+	}
+	log.SetLogger(l)
+	return nil
+}
+
+// closeLog flushes and closes the log file, if any. The messages logged afterwards are lost
+func closeLog() {
+	if logFile == nil {
+		return
+	}
+	if err := logFile.Sync(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed syncing log file: %v\n", err)
+	}
+	if err := logFile.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed closing log file: %v\n", err)
+	}
+	logFile = nil
+}
+
+// initConfig loads the configuration file. Only a file given explicitly has to exist
+func initConfig(cmd *cobra.Command) error {
+	if cmd.Flags().Changed("config") {
+		if _, err := os.Stat(rootCmdArgs.ConfigFile); err != nil {
+			return fmt.Errorf("failed reading config file: %w", err)
+		}
+	}
+	f, err := config.LoadFile(rootCmdArgs.ConfigFile)
+	if err != nil {
+		return err
+	}
+	cfg = f
 	return nil
 }
 
+// applyConfig gives the network the template and template variables of the configuration file it doesn't have
+func applyConfig(n *network.Network) {
+	if n.Template == "" {
+		n.Template = cfg.Template
+	}
+	for key, value := range cfg.TemplateVars {
+		if _, ok := n.TemplateVars[key]; ok {
+			continue
+		}
+		if n.TemplateVars == nil {
+			n.TemplateVars = map[string]string{}
+		}
+		n.TemplateVars[key] = value
+	}
+}
+
 func isNotValidCIDR(cidr string) bool {
 	_, _, err := net.ParseCIDR(cidr)
 	return err != nil
 }
+
+// splitSubnets assigns the given CIDRs to the IPv4 and IPv6 subnets of the network, accepting at most one of each
+func splitSubnets(cidrs []string) error {
+	rootCmdArgs.Subnet, rootCmdArgs.SubnetV6 = "", ""
+	for _, cidr := range cidrs {
+		if isNotValidCIDR(cidr) {
+			return fmt.Errorf("invalid CIDR value provided (for e.g. it should be of the form 10.89.0.1/24 or fd00:89::/64): %v", cidr)
+		}
+		ip, _, _ := net.ParseCIDR(cidr)
+		subnet := &rootCmdArgs.Subnet
+		if ip.To4() == nil {
+			subnet = &rootCmdArgs.SubnetV6
+		}
+		if *subnet != "" {
+			return fmt.Errorf("only one IPv4 and one IPv6 subnet can be provided, got %s and %s", *subnet, cidr)
+		}
+		*subnet = cidr
+	}
+	return nil
+}
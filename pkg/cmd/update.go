@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+
+	"github.com/day0ops/netctl/pkg/network"
+)
+
+var updateCmdArgs struct {
+	network.UpdateOptions
+}
+
+// updateCmd returns the update subcommand
+func updateCmd() *cobra.Command {
+	updateCmd := &cobra.Command{
+		Use:   "update",
+		Short: "Update an existing network in place to match the given options",
+		Long: "Update an existing network in place to match the given options, the options left out keep their current value. " +
+			"DHCP ranges and autostart are changed live, the other changes are applied the next time the network is started, " +
+			"or right away with --recreate. Subnet changes need --recreate, which is refused while domains use the network.",
+		RunE: updateNet,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return splitSubnets(rootCmdArgs.SubnetCIDRs)
+		},
+	}
+
+	// add flags
+	addCommonFlags(updateCmd)
+	addNetworkFlags(updateCmd)
+	addURIFlag(updateCmd)
+	updateCmd.Flags().StringVarP(&rootCmdArgs.Output, "output", "o", "", "Print the applied changes as a table, json or yaml")
+	updateCmd.Flags().BoolVar(&updateCmdArgs.Recreate, "recreate", false, "Recreate the network to apply the changes that can't be made live right away (refused while domains use it)")
+	updateCmd.Flags().BoolVar(&updateCmdArgs.Force, "force", false, "Update the network even if it was not created by netctl")
+
+	return updateCmd
+}
+
+func updateNet(cmd *cobra.Command, args []string) error {
+	if err := checkResultOutput(cmd); err != nil {
+		return err
+	}
+	var r *network.UpdateResult
+	err := withClient(func(c *network.Client) error {
+		n, err := c.Declaration(cmd.Context(), rootCmdArgs.Name)
+		if err != nil {
+			return err
+		}
+		// the network keeps the template it was rendered with, the one of the config file is for new networks
+		applyChangedFlags(cmd, n)
+		r, err = c.UpdateNetwork(cmd.Context(), n, updateCmdArgs.UpdateOptions)
+		return err
+	})
+	var inUse *network.DomainsInUseError
+	switch {
+	case errors.Is(err, network.ErrNotManaged):
+		return fmt.Errorf("%w (use --force to update it anyway)", err)
+	case errors.Is(err, network.ErrRecreateRequired):
+		return fmt.Errorf("%w (use --recreate)", err)
+	case errors.As(err, &inUse):
+		return fmt.Errorf("%w (release them before recreating the network, or leave out --recreate)", err)
+	case err != nil:
+		return err
+	}
+	return printUpdateResult(cmd.OutOrStdout(), r)
+}
+
+// networkFlags copy the value of each network flag to the network, see addNetworkFlags
+var networkFlags = map[string]func(n *network.Network){
+	"bridge":                   func(n *network.Network) { n.Bridge = rootCmdArgs.Bridge },
+	"step":                     func(n *network.Network) { n.Step = rootCmdArgs.Step },
+	"tries":                    func(n *network.Network) { n.Tries = rootCmdArgs.Tries },
+	"check-container-networks": func(n *network.Network) { n.ContainerRuntimes = rootCmdArgs.ContainerRuntimes },
+	"dhcp-range":               func(n *network.Network) { n.DHCPRange = rootCmdArgs.DHCPRange },
+	"dhcp-range-v6":            func(n *network.Network) { n.DHCPRangeV6 = rootCmdArgs.DHCPRangeV6 },
+	"ipv6-mode":                func(n *network.Network) { n.IPv6Mode = rootCmdArgs.IPv6Mode },
+	"forward-mode":             func(n *network.Network) { n.ForwardMode = rootCmdArgs.ForwardMode },
+	"forward-dev":              func(n *network.Network) { n.ForwardDev = rootCmdArgs.ForwardDev },
+	"nat-port-start":           func(n *network.Network) { n.NATPortStart = rootCmdArgs.NATPortStart },
+	"nat-port-end":             func(n *network.Network) { n.NATPortEnd = rootCmdArgs.NATPortEnd },
+	"dns":                      func(n *network.Network) { n.DNS = rootCmdArgs.DNS },
+	"domain":                   func(n *network.Network) { n.Domain = rootCmdArgs.Domain },
+	"domain-local-only":        func(n *network.Network) { n.DomainLocalOnly = rootCmdArgs.DomainLocalOnly },
+	"dns-forwarder":            func(n *network.Network) { n.DNSForwarders = rootCmdArgs.DNSForwarders },
+	"label":                    func(n *network.Network) { n.Labels = rootCmdArgs.Labels },
+	"no-autostart":             func(n *network.Network) { n.NoAutostart = rootCmdArgs.NoAutostart },
+	"template":                 func(n *network.Network) { n.Template = rootCmdArgs.Template },
+	"set":                      func(n *network.Network) { n.TemplateVars = rootCmdArgs.TemplateVars },
+}
+
+// applyChangedFlags sets the options of the network given on the command line. The subnets given replace the ones
+// of the same family, dropping their custom DHCP range unless a new one is given too
+func applyChangedFlags(cmd *cobra.Command, n *network.Network) {
+	if cmd.Flags().Changed("subnet-cidr") {
+		if rootCmdArgs.Subnet != "" && rootCmdArgs.Subnet != n.Subnet {
+			n.Subnet, n.DHCPRange = rootCmdArgs.Subnet, ""
+		}
+		if rootCmdArgs.SubnetV6 != "" && rootCmdArgs.SubnetV6 != n.SubnetV6 {
+			n.SubnetV6, n.DHCPRangeV6 = rootCmdArgs.SubnetV6, ""
+		}
+	}
+	for name, set := range networkFlags {
+		if cmd.Flags().Changed(name) {
+			set(n)
+		}
+	}
+}
+
+// printUpdateResult writes the changes applied by update in the requested format, nothing if none was requested
+func printUpdateResult(w io.Writer, r *network.UpdateResult) error {
+	if rootCmdArgs.Output == "" {
+		return nil
+	}
+	return printOutput(w, rootCmdArgs.Output, r, func(w io.Writer) error {
+		fmt.Fprintln(w, "NAME\tAPPLIED\tCHANGE")
+		redefined := "on restart"
+		if !r.Pending {
+			redefined = "redefined"
+		}
+		for _, c := range []struct {
+			applied string
+			changes []string
+		}{{"live", r.Live}, {redefined, r.Redefined}, {"recreated", r.Recreated}} {
+			for _, change := range c.changes {
+				fmt.Fprintf(w, "%s\t%s\t%s\n", r.Name, c.applied, change)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/day0ops/netctl/pkg/config"
+	"github.com/day0ops/netctl/pkg/network"
+)
+
+const labNetworkXML = `
+<network>
+  <name>lab</name>
+  <metadata>
+    <netctl:network xmlns:netctl='https://github.com/day0ops/netctl'>
+      <netctl:creator>qa</netctl:creator>
+      <netctl:version>development</netctl:version>
+      <netctl:created>2024-01-02T03:04:05Z</netctl:created>
+      <netctl:label key='team'>qa</netctl:label>
+    </netctl:network>
+  </metadata>
+  <forward mode='nat'/>
+  <dns/>
+  <bridge name='virbr-lab1' stp='on' delay='0'/>
+  <ip address='192.168.123.1' netmask='255.255.255.0'>
+    <dhcp>
+      <range start='192.168.123.2' end='192.168.123.253'/>
+    </dhcp>
+  </ip>
+</network>`
+
+// execute runs netctl with the given arguments against the backend, without a configuration file or IPAM database
+func execute(t *testing.T, b *network.FakeBackend, args ...string) error {
+	t.Helper()
+	origClient := newClient
+	newClient = func(string) (*network.Client, error) { return network.NewClientWithBackend(b), nil }
+	t.Cleanup(func() { newClient = origClient })
+
+	config := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(config, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rootCmd.SetArgs(append(args, "--config", config, "--ipam-db", ""))
+	return rootCmd.ExecuteContext(context.Background())
+}
+
+func TestUpdateKeepsOptionsNotGiven(t *testing.T) {
+	b := network.NewFakeBackend()
+	if err := b.DefineNetwork(labNetworkXML); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.CreateNetwork("lab"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SetNetworkAutostart("lab", true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := execute(t, b, "update", "-n", "lab", "--dhcp-range", "192.168.123.100-192.168.123.200"); err != nil {
+		t.Fatalf("update error = %v", err)
+	}
+	if b.Calls["DefineNetwork"] != 1 || b.Calls["UndefineNetwork"] != 0 {
+		t.Errorf("update redefined the network, only its DHCP range was given")
+	}
+	n, err := network.NewClientWithBackend(b).Declaration(context.Background(), "lab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := network.Network{
+		Name:        "lab",
+		Bridge:      "virbr-lab1",
+		Subnet:      "192.168.123.1/24",
+		DHCPRange:   "192.168.123.100-192.168.123.200",
+		ForwardMode: network.ForwardModeNAT,
+		DNS:         true,
+		Labels:      map[string]string{"team": "qa"},
+	}
+	if !reflect.DeepEqual(*n, want) {
+		t.Errorf("network after update = %+v, want %+v", *n, want)
+	}
+}
+
+func TestUpdateTemplateVariables(t *testing.T) {
+	mtuTemplate := filepath.Join(t.TempDir(), "mtu.tmpl")
+	tmpl := strings.Replace(config.NetworkTmpl, "<bridge", "<mtu size='{{.Vars.mtu}}'/>\n  <bridge", 1)
+	if err := os.WriteFile(mtuTemplate, []byte(tmpl), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	b := network.NewFakeBackend()
+	n := &network.Network{Name: "lab", Bridge: "virbr-lab1", Subnet: "192.168.123.1/24", DNS: true, NoAutostart: true,
+		Template: mtuTemplate, TemplateVars: map[string]string{"mtu": "9000"}}
+	if _, err := network.NewClientWithBackend(b).EnsureNetwork(context.Background(), n); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := execute(t, b, "update", "-n", "lab", "--set", "mtu=1500"); err != nil {
+		t.Fatalf("update error = %v", err)
+	}
+	if got, _ := b.PersistentNetworkXML("lab"); !strings.Contains(got, "<mtu size='1500'/>") {
+		t.Errorf("update --set did not redefine the network:\n%s", got)
+	}
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/day0ops/netctl/pkg/network"
+)
+
+const outputText = "text"
+
+// watchCmd returns the watch subcommand
+func watchCmd() *cobra.Command {
+	watchCmd := &cobra.Command{
+		Use:   "watch [network...]",
+		Short: "Stream the lifecycle events of the networks created by netctl and the domain interfaces attached to them",
+		RunE:  watchNets,
+	}
+
+	// add flags
+	addURIFlag(watchCmd)
+	watchCmd.Flags().StringVarP(&rootCmdArgs.Output, "output", "o", outputText, "Output format (text, or json for one JSON object per line)")
+
+	return watchCmd
+}
+
+func watchNets(cmd *cobra.Command, args []string) error {
+	w := cmd.OutOrStdout()
+	var show func(e network.Event) error
+	switch rootCmdArgs.Output {
+	case outputText:
+		show = func(e network.Event) error {
+			_, err := fmt.Fprintf(w, "%s %s\n", e.Time.Local().Format(time.RFC3339), e)
+			return err
+		}
+	case outputJSON:
+		enc := json.NewEncoder(w)
+		show = func(e network.Event) error {
+			return enc.Encode(e)
+		}
+	default:
+		return fmt.Errorf("unsupported output format %q (must be %s or %s)", rootCmdArgs.Output, outputText, outputJSON)
+	}
+
+	return withClient(func(c *network.Client) error {
+		return c.Watch(cmd.Context(), args, show)
+	})
+}
@@ -3,18 +3,76 @@ package config
 const (
 	DefaultQemuSystem                 = "qemu:///system"
 	DefaultBridge                     = "virbr0"
+	DefaultForwardMode                = "none"
+	DefaultSubnetStep                 = 11
+	DefaultSubnetStepV6               = 1
+	DefaultSubnetTries                = 20
 	DefaultPrivateMinikubeNetworkName = "minikube-net"
 
+	// MetadataNamespace is the XML namespace of the metadata netctl records in the networks it creates
+	MetadataNamespace = "https://github.com/day0ops/netctl"
+
+	// NetworkTmpl is the template of the network XML. Values are escaped with the xml function, but for the metadata
+	// ones which are escaped already
 	NetworkTmpl = `
 <network>
-  <name>{{.Name}}</name>
+  <name>{{xml .Name}}</name>
+  {{- if .UUID}}
+  <uuid>{{xml .UUID}}</uuid>
+  {{- end}}
+  {{- with .Metadata}}
+  <metadata>
+    <netctl:network xmlns:netctl='` + MetadataNamespace + `'>
+      <netctl:creator>{{.Creator}}</netctl:creator>
+      <netctl:version>{{.Version}}</netctl:version>
+      <netctl:created>{{.Created}}</netctl:created>
+      {{- range $key, $value := .Labels}}
+      <netctl:label key='{{$key}}'>{{$value}}</netctl:label>
+      {{- end}}
+    </netctl:network>
+  </metadata>
+  {{- end}}
+  {{- if .ForwardMode}}
+  <forward mode='{{xml .ForwardMode}}'{{if .ForwardDev}} dev='{{xml .ForwardDev}}'{{end}}>
+    {{- if .NATPortStart}}
+    <nat>
+      <port start='{{.NATPortStart}}' end='{{.NATPortEnd}}'/>
+    </nat>
+    {{- end}}
+  </forward>
+  {{- end}}
+  {{- if .DNS}}
+  <dns>
+    {{- range .DNSForwarders}}
+    <forwarder{{if .Domain}} domain='{{xml .Domain}}'{{end}} addr='{{xml .Addr}}'/>
+    {{- end}}
+  </dns>
+  {{- else}}
   <dns enable='no'/>
-  <bridge name='{{.Bridge}}' stp='on' delay='0'/>
+  {{- end}}
+  {{- if .Domain}}
+  <domain name='{{xml .Domain}}'{{if .DomainLocalOnly}} localOnly='yes'{{end}}/>
+  {{- end}}
+  <bridge{{if .Bridge}} name='{{xml .Bridge}}'{{end}} stp='on' delay='0'/>
+  {{- if .MAC}}
+  <mac address='{{xml .MAC}}'/>
+  {{- end}}
+  {{- if .Gateway}}
   {{- with .Parameters}}
-  <ip address='{{.Gateway}}' netmask='{{.Netmask}}'>
+  <ip address='{{xml .Gateway}}' netmask='{{xml .Netmask}}'>
+    <dhcp>
+      <range start='{{xml .ClientMin}}' end='{{xml .ClientMax}}'/>
+    </dhcp>
+  </ip>
+  {{- end}}
+  {{- end}}
+  {{- with .ParametersV6}}
+  <ip family='ipv6' address='{{xml .Gateway}}' prefix='{{.Prefix}}'>
+    {{- if eq $.IPv6Mode "dhcp"}}
     <dhcp>
-      <range start='{{.ClientMin}}' end='{{.ClientMax}}'/>
+      <range start='{{xml .ClientMin}}' end='{{xml .ClientMax}}'/>
     </dhcp>
+    {{- end}}
   </ip>
   {{- end}}
 </network>
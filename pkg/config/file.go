@@ -0,0 +1,52 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// File is the netctl configuration file
+type File struct {
+	// Template is the path of a Go template of the network XML replacing NetworkTmpl, relative to the file
+	Template string `json:"template,omitempty" yaml:"template,omitempty"`
+
+	// TemplateVars are the user variables given to the template as .Vars
+	TemplateVars map[string]string `json:"templateVars,omitempty" yaml:"templateVars,omitempty"`
+}
+
+// DefaultFilePath returns the default location of the configuration file: $XDG_CONFIG_HOME/netctl/config.yaml,
+// falling back to ~/.config/netctl/config.yaml
+func DefaultFilePath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, AppName, "config.yaml")
+}
+
+// LoadFile reads the configuration file at path. A missing file is an empty configuration
+func LoadFile(path string) (*File, error) {
+	f := &File{}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed reading config file: %w", err)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(f); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed decoding config file %s: %w", path, err)
+	}
+	if f.Template != "" && !filepath.IsAbs(f.Template) {
+		f.Template = filepath.Join(filepath.Dir(path), f.Template)
+	}
+	return f, nil
+}
@@ -0,0 +1,217 @@
+package ipam
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/juju/mutex/v2"
+	"github.com/pkg/errors"
+
+	"github.com/day0ops/netctl/pkg/config"
+	"github.com/day0ops/netctl/pkg/lock"
+	"github.com/day0ops/netctl/pkg/util"
+)
+
+// ErrConflict is returned when a reservation overlaps with the subnets allocated to another network
+var ErrConflict = errors.New("subnet already allocated")
+
+// Allocation records the subnets allocated to a network
+type Allocation struct {
+	Network string    `json:"network" yaml:"network"`
+	URI     string    `json:"uri" yaml:"uri"` // libvirt connection URI the network is defined on
+	CIDRs   []string  `json:"cidrs" yaml:"cidrs"`
+	Owner   string    `json:"owner" yaml:"owner"` // user that allocated the subnets
+	Created time.Time `json:"created" yaml:"created"`
+}
+
+// Store is a file-backed database of subnet allocations, shared by every netctl process on the host
+type Store struct {
+	path string
+}
+
+type database struct {
+	Allocations []Allocation `json:"allocations"`
+}
+
+// DefaultPath returns the default location of the database: $XDG_STATE_HOME/netctl/ipam.json,
+// falling back to ~/.local/state/netctl/ipam.json
+func DefaultPath() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			home = os.TempDir()
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, config.AppName, "ipam.json")
+}
+
+// NewStore returns the store kept in the file at path. The file is created on the first reservation
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+// Path returns the location of the database
+func (s *Store) Path() string {
+	return s.path
+}
+
+// List returns every allocation, sorted by URI and network name
+func (s *Store) List() ([]Allocation, error) {
+	var allocs []Allocation
+	err := s.update(func(db *database) (bool, error) {
+		allocs = db.Allocations
+		return false, nil
+	})
+	return allocs, err
+}
+
+// Get returns the allocation of the network defined on uri, or nil if there is none
+func (s *Store) Get(uri, network string) (*Allocation, error) {
+	allocs, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	for i := range allocs {
+		if allocs[i].URI == uri && allocs[i].Network == network {
+			return &allocs[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// Reserve records the allocation, replacing any previous one of the same network. It fails with ErrConflict
+// if any of its subnets overlaps with the subnets allocated to another network
+func (s *Store) Reserve(a Allocation) error {
+	if a.Owner == "" {
+		a.Owner = util.CurrentUser()
+	}
+	if a.Created.IsZero() {
+		a.Created = time.Now().UTC()
+	}
+
+	return s.update(func(db *database) (bool, error) {
+		kept := db.Allocations[:0]
+		for _, other := range db.Allocations {
+			if other.URI == a.URI && other.Network == a.Network {
+				continue
+			}
+			if cidr, otherCIDR, ok := overlap(a.CIDRs, other.CIDRs); ok {
+				return false, fmt.Errorf("%w: %s overlaps with %s of network %s (%s)", ErrConflict, cidr, otherCIDR, other.Network, other.URI)
+			}
+			kept = append(kept, other)
+		}
+		db.Allocations = append(kept, a)
+		return true, nil
+	})
+}
+
+// Release removes the allocation of the network defined on uri. It returns whether there was one
+func (s *Store) Release(uri, network string) (bool, error) {
+	released := false
+	err := s.update(func(db *database) (bool, error) {
+		kept := db.Allocations[:0]
+		for _, a := range db.Allocations {
+			if a.URI == uri && a.Network == network {
+				released = true
+				continue
+			}
+			kept = append(kept, a)
+		}
+		db.Allocations = kept
+		return released, nil
+	})
+	return released, err
+}
+
+// update runs f on the database while holding the host-wide lock of the store, saving the database if f
+// reports it changed
+func (s *Store) update(f func(db *database) (bool, error)) error {
+	spec := lock.PathMutexSpec(s.path)
+	releaser, err := mutex.Acquire(spec)
+	if err != nil {
+		return errors.Wrapf(err, "failed acquiring lock of IPAM database %s", s.path)
+	}
+	defer releaser.Release()
+
+	db, err := s.load()
+	if err != nil {
+		return err
+	}
+	changed, err := f(db)
+	if err != nil || !changed {
+		return err
+	}
+	return s.save(db)
+}
+
+func (s *Store) load() (*database, error) {
+	db := &database{}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return db, nil
+		}
+		return nil, errors.Wrapf(err, "failed reading IPAM database %s", s.path)
+	}
+	if err := json.Unmarshal(data, db); err != nil {
+		return nil, errors.Wrapf(err, "failed parsing IPAM database %s", s.path)
+	}
+	sort.Slice(db.Allocations, func(i, j int) bool {
+		if db.Allocations[i].URI != db.Allocations[j].URI {
+			return db.Allocations[i].URI < db.Allocations[j].URI
+		}
+		return db.Allocations[i].Network < db.Allocations[j].Network
+	})
+	return db, nil
+}
+
+// save writes the database to a temporary file first, so a crash never leaves a truncated database behind
+func (s *Store) save(db *database) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return errors.Wrapf(err, "failed creating directory of IPAM database %s", s.path)
+	}
+	data, err := json.MarshalIndent(db, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return errors.Wrapf(err, "failed writing IPAM database %s", s.path)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.Wrapf(err, "failed writing IPAM database %s", s.path)
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrapf(err, "failed writing IPAM database %s", s.path)
+	}
+	return errors.Wrapf(os.Rename(tmp.Name(), s.path), "failed writing IPAM database %s", s.path)
+}
+
+// overlap returns the first pair of overlapping subnets between a and b
+func overlap(a, b []string) (string, string, bool) {
+	for _, cidrA := range a {
+		_, netA, err := net.ParseCIDR(cidrA)
+		if err != nil {
+			continue
+		}
+		for _, cidrB := range b {
+			_, netB, err := net.ParseCIDR(cidrB)
+			if err != nil {
+				continue
+			}
+			if netA.Contains(netB.IP) || netB.Contains(netA.IP) {
+				return cidrA, cidrB, true
+			}
+		}
+	}
+	return "", "", false
+}
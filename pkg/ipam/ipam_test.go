@@ -0,0 +1,49 @@
+package ipam
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestReserveRejectsOverlap(t *testing.T) {
+	s := NewStore(filepath.Join(t.TempDir(), "ipam.json"))
+
+	if err := s.Reserve(Allocation{Network: "a", URI: "qemu:///system", CIDRs: []string{"10.89.0.0/24"}}); err != nil {
+		t.Fatalf("Reserve() error = %v", err)
+	}
+	err := s.Reserve(Allocation{Network: "b", URI: "qemu:///system", CIDRs: []string{"10.89.0.0/16"}})
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("Reserve() error = %v, want ErrConflict", err)
+	}
+	// a network can replace its own allocation
+	if err := s.Reserve(Allocation{Network: "a", URI: "qemu:///system", CIDRs: []string{"10.89.0.0/16"}}); err != nil {
+		t.Fatalf("Reserve() of the same network error = %v", err)
+	}
+
+	a, err := s.Get("qemu:///system", "a")
+	if err != nil || a == nil {
+		t.Fatalf("Get() = %v, %v", a, err)
+	}
+	if len(a.CIDRs) != 1 || a.CIDRs[0] != "10.89.0.0/16" || a.Owner == "" || a.Created.IsZero() {
+		t.Errorf("Get() = %+v", a)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	s := NewStore(filepath.Join(t.TempDir(), "ipam.json"))
+	if err := s.Reserve(Allocation{Network: "a", URI: "qemu:///system", CIDRs: []string{"10.89.0.0/24"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if released, err := s.Release("qemu:///session", "a"); err != nil || released {
+		t.Errorf("Release() on another URI = %v, %v, want false", released, err)
+	}
+	if released, err := s.Release("qemu:///system", "a"); err != nil || !released {
+		t.Errorf("Release() = %v, %v, want true", released, err)
+	}
+	allocs, err := s.List()
+	if err != nil || len(allocs) != 0 {
+		t.Errorf("List() after release = %v, %v", allocs, err)
+	}
+}
@@ -6,23 +6,34 @@ import (
 	"os"
 )
 
+// FmtMachineLogger writes plain messages, informational ones to stdout and the others to stderr. Fields are
+// left out, messages already naming what they are about
 type FmtMachineLogger struct {
 	outWriter io.Writer
 	errWriter io.Writer
-	debug     bool
+	level     *Level // shared with the loggers returned by With
 }
 
 // NewFmtMachineLogger creates a MachineLogger implementation used by the drivers
 func NewFmtMachineLogger() *FmtMachineLogger {
+	level := LevelInfo
 	return &FmtMachineLogger{
 		outWriter: os.Stdout,
 		errWriter: os.Stderr,
-		debug:     false,
+		level:     &level,
 	}
 }
 
 func (ml *FmtMachineLogger) SetDebug(debug bool) {
-	ml.debug = debug
+	if debug {
+		ml.SetLevel(LevelDebug)
+	} else {
+		ml.SetLevel(LevelInfo)
+	}
+}
+
+func (ml *FmtMachineLogger) SetLevel(level Level) {
+	*ml.level = level
 }
 
 func (ml *FmtMachineLogger) SetOutWriter(out io.Writer) {
@@ -33,38 +44,59 @@ func (ml *FmtMachineLogger) SetErrWriter(err io.Writer) {
 	ml.errWriter = err
 }
 
-func (ml *FmtMachineLogger) Debug(args ...interface{}) {
-	if ml.debug {
-		fmt.Fprintln(ml.errWriter, args...)
+func (ml *FmtMachineLogger) With(fields Fields) Logger {
+	return ml
+}
+
+func (ml *FmtMachineLogger) println(level Level, w io.Writer, prefix string, args ...interface{}) {
+	if level >= *ml.level {
+		fmt.Fprint(w, prefix)
+		fmt.Fprintln(w, args...)
 	}
 }
 
-func (ml *FmtMachineLogger) Debugf(fmtString string, args ...interface{}) {
-	if ml.debug {
-		fmt.Fprintf(ml.errWriter, fmtString+"\n", args...)
+func (ml *FmtMachineLogger) printf(level Level, w io.Writer, prefix string, fmtString string, args ...interface{}) {
+	if level >= *ml.level {
+		fmt.Fprintf(w, prefix+fmtString+"\n", args...)
 	}
 }
 
+func (ml *FmtMachineLogger) Trace(args ...interface{}) {
+	ml.println(LevelTrace, ml.errWriter, "", args...)
+}
+
+func (ml *FmtMachineLogger) Tracef(fmtString string, args ...interface{}) {
+	ml.printf(LevelTrace, ml.errWriter, "", fmtString, args...)
+}
+
+func (ml *FmtMachineLogger) Debug(args ...interface{}) {
+	ml.println(LevelDebug, ml.errWriter, "", args...)
+}
+
+func (ml *FmtMachineLogger) Debugf(fmtString string, args ...interface{}) {
+	ml.printf(LevelDebug, ml.errWriter, "", fmtString, args...)
+}
+
 func (ml *FmtMachineLogger) Error(args ...interface{}) {
-	fmt.Fprintln(ml.errWriter, args...)
+	ml.println(LevelError, ml.errWriter, "", args...)
 }
 
 func (ml *FmtMachineLogger) Errorf(fmtString string, args ...interface{}) {
-	fmt.Fprintf(ml.errWriter, fmtString+"\n", args...)
+	ml.printf(LevelError, ml.errWriter, "", fmtString, args...)
 }
 
 func (ml *FmtMachineLogger) Info(args ...interface{}) {
-	fmt.Fprintln(ml.outWriter, args...)
+	ml.println(LevelInfo, ml.outWriter, "", args...)
 }
 
 func (ml *FmtMachineLogger) Infof(fmtString string, args ...interface{}) {
-	fmt.Fprintf(ml.outWriter, fmtString+"\n", args...)
+	ml.printf(LevelInfo, ml.outWriter, "", fmtString, args...)
 }
 
 func (ml *FmtMachineLogger) Warn(args ...interface{}) {
-	fmt.Fprintln(ml.outWriter, args...)
+	ml.println(LevelWarn, ml.errWriter, "WARNING: ", args...)
 }
 
 func (ml *FmtMachineLogger) Warnf(fmtString string, args ...interface{}) {
-	fmt.Fprintf(ml.outWriter, fmtString+"\n", args...)
+	ml.printf(LevelWarn, ml.errWriter, "WARNING: ", fmtString, args...)
 }
@@ -3,9 +3,27 @@ package log
 import "io"
 
 var (
-	logger = NewFmtMachineLogger()
+	logger Logger = NewFmtMachineLogger()
 )
 
+// SetLogger replaces the logger used by the package-level helpers
+func SetLogger(l Logger) {
+	logger = l
+}
+
+// With returns the logger of the package-level helpers, adding fields to every message
+func With(fields Fields) Logger {
+	return logger.With(fields)
+}
+
+func Trace(args ...interface{}) {
+	logger.Trace(args...)
+}
+
+func Tracef(fmtString string, args ...interface{}) {
+	logger.Tracef(fmtString, args...)
+}
+
 func Debug(args ...interface{}) {
 	logger.Debug(args...)
 }
@@ -38,14 +56,28 @@ func Warnf(fmtString string, args ...interface{}) {
 	logger.Warnf(fmtString, args...)
 }
 
+func SetLevel(level Level) {
+	logger.SetLevel(level)
+}
+
 func SetDebug(debug bool) {
-	logger.SetDebug(debug)
+	if debug {
+		logger.SetLevel(LevelDebug)
+	} else {
+		logger.SetLevel(LevelInfo)
+	}
 }
 
+// SetOutWriter sets where informational messages go, if the logger is a FmtMachineLogger
 func SetOutWriter(out io.Writer) {
-	logger.SetOutWriter(out)
+	if ml, ok := logger.(*FmtMachineLogger); ok {
+		ml.SetOutWriter(out)
+	}
 }
 
+// SetErrWriter sets where the other messages go, if the logger is a FmtMachineLogger
 func SetErrWriter(err io.Writer) {
-	logger.SetErrWriter(err)
+	if ml, ok := logger.(*FmtMachineLogger); ok {
+		ml.SetErrWriter(err)
+	}
 }
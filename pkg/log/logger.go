@@ -0,0 +1,67 @@
+package log
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Level is the severity of a log message. Messages below the level of a logger are discarded
+type Level int
+
+// Log levels, from the most to the least verbose
+const (
+	LevelTrace Level = iota
+	LevelDebug
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"trace", "debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelTrace || l > LevelError {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level named s (trace, debug, info, warn or error)
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) || (strings.EqualFold(s, "warning") && Level(i) == LevelWarn) {
+			return Level(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unsupported log level %q (must be one of %s)", s, strings.Join(levelNames, ", "))
+}
+
+// Fields are key/value pairs attached to log messages, see the Field* keys
+type Fields map[string]interface{}
+
+// Keys of common fields
+const (
+	FieldNetwork = "network"
+	FieldSubnet  = "subnet"
+	FieldURI     = "uri"
+	FieldAttempt = "attempt"
+)
+
+// Logger writes leveled log messages
+type Logger interface {
+	Trace(args ...interface{})
+	Tracef(fmtString string, args ...interface{})
+	Debug(args ...interface{})
+	Debugf(fmtString string, args ...interface{})
+	Info(args ...interface{})
+	Infof(fmtString string, args ...interface{})
+	Warn(args ...interface{})
+	Warnf(fmtString string, args ...interface{})
+	Error(args ...interface{})
+	Errorf(fmtString string, args ...interface{})
+
+	// SetLevel discards the messages below level from now on
+	SetLevel(level Level)
+	// With returns a logger adding fields to every message, sharing the level and output of this one
+	With(fields Fields) Logger
+}
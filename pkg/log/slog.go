@@ -0,0 +1,135 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+)
+
+// Formats of SlogLogger
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+const slogLevelTrace = slog.LevelDebug - 4
+
+var slogLevels = map[Level]slog.Level{
+	LevelTrace: slogLevelTrace,
+	LevelDebug: slog.LevelDebug,
+	LevelInfo:  slog.LevelInfo,
+	LevelWarn:  slog.LevelWarn,
+	LevelError: slog.LevelError,
+}
+
+// SlogLogger writes structured messages with log/slog, as JSON objects or key=value text lines
+type SlogLogger struct {
+	logger *slog.Logger
+	level  *slog.LevelVar // shared with the loggers returned by With
+}
+
+// NewSlogLogger returns a logger writing messages of at least level to w in the given format (text or json)
+func NewSlogLogger(w io.Writer, format string, level Level) (*SlogLogger, error) {
+	l := &SlogLogger{level: &slog.LevelVar{}}
+	l.SetLevel(level)
+	opts := &slog.HandlerOptions{Level: l.level, ReplaceAttr: replaceLevel}
+	switch format {
+	case FormatText:
+		l.logger = slog.New(slog.NewTextHandler(w, opts))
+	case FormatJSON:
+		l.logger = slog.New(slog.NewJSONHandler(w, opts))
+	default:
+		return nil, fmt.Errorf("unsupported log format %q (must be %s or %s)", format, FormatText, FormatJSON)
+	}
+	return l, nil
+}
+
+// replaceLevel names the trace level, slog only knowing DEBUG-4 otherwise
+func replaceLevel(groups []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.LevelKey && len(groups) == 0 {
+		if level, ok := a.Value.Any().(slog.Level); ok && level == slogLevelTrace {
+			a.Value = slog.StringValue("TRACE")
+		}
+	}
+	return a
+}
+
+func (l *SlogLogger) SetLevel(level Level) {
+	l.level.Set(slogLevels[level])
+}
+
+func (l *SlogLogger) With(fields Fields) Logger {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	args := make([]interface{}, 0, 2*len(keys))
+	for _, key := range keys {
+		args = append(args, key, fields[key])
+	}
+	return &SlogLogger{logger: l.logger.With(args...), level: l.level}
+}
+
+func (l *SlogLogger) log(level Level, msg func() string) {
+	ctx := context.Background()
+	if !l.logger.Enabled(ctx, slogLevels[level]) {
+		return
+	}
+	l.logger.Log(ctx, slogLevels[level], msg())
+}
+
+func (l *SlogLogger) println(level Level, args ...interface{}) {
+	l.log(level, func() string {
+		s := fmt.Sprintln(args...)
+		return s[:len(s)-1]
+	})
+}
+
+func (l *SlogLogger) printf(level Level, fmtString string, args ...interface{}) {
+	l.log(level, func() string {
+		return fmt.Sprintf(fmtString, args...)
+	})
+}
+
+func (l *SlogLogger) Trace(args ...interface{}) {
+	l.println(LevelTrace, args...)
+}
+
+func (l *SlogLogger) Tracef(fmtString string, args ...interface{}) {
+	l.printf(LevelTrace, fmtString, args...)
+}
+
+func (l *SlogLogger) Debug(args ...interface{}) {
+	l.println(LevelDebug, args...)
+}
+
+func (l *SlogLogger) Debugf(fmtString string, args ...interface{}) {
+	l.printf(LevelDebug, fmtString, args...)
+}
+
+func (l *SlogLogger) Info(args ...interface{}) {
+	l.println(LevelInfo, args...)
+}
+
+func (l *SlogLogger) Infof(fmtString string, args ...interface{}) {
+	l.printf(LevelInfo, fmtString, args...)
+}
+
+func (l *SlogLogger) Warn(args ...interface{}) {
+	l.println(LevelWarn, args...)
+}
+
+func (l *SlogLogger) Warnf(fmtString string, args ...interface{}) {
+	l.printf(LevelWarn, fmtString, args...)
+}
+
+func (l *SlogLogger) Error(args ...interface{}) {
+	l.println(LevelError, args...)
+}
+
+func (l *SlogLogger) Errorf(fmtString string, args ...interface{}) {
+	l.printf(LevelError, fmtString, args...)
+}
@@ -0,0 +1,66 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSlogLoggerJSON(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := NewSlogLogger(&buf, FormatJSON, LevelDebug)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Tracef("dropped %d", 1)
+	l.With(Fields{FieldNetwork: "net", FieldAttempt: 2}).Warnf("subnet %s is taken", "10.0.0.0/24")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("logged %d lines, want 1: %s", len(lines), buf.String())
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["level"] != "WARN" || got["msg"] != "subnet 10.0.0.0/24 is taken" || got["network"] != "net" || got["attempt"] != 2.0 {
+		t.Errorf("logged %v", got)
+	}
+
+	buf.Reset()
+	l.SetLevel(LevelTrace)
+	l.Trace("now", "logged")
+	if !strings.Contains(buf.String(), `"level":"TRACE"`) || !strings.Contains(buf.String(), `"msg":"now logged"`) {
+		t.Errorf("logged %s, want a trace message", buf.String())
+	}
+}
+
+func TestFmtMachineLoggerLevels(t *testing.T) {
+	var out, errOut bytes.Buffer
+	l := NewFmtMachineLogger()
+	l.SetOutWriter(&out)
+	l.SetErrWriter(&errOut)
+	l.SetLevel(LevelInfo)
+
+	l.Debug("dropped")
+	l.Infof("created %s", "net")
+	l.With(Fields{FieldNetwork: "net"}).Warn("careful")
+	if out.String() != "created net\n" {
+		t.Errorf("stdout = %q", out.String())
+	}
+	if errOut.String() != "WARNING: careful\n" {
+		t.Errorf("stderr = %q", errOut.String())
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for s, want := range map[string]Level{"trace": LevelTrace, "DEBUG": LevelDebug, "warning": LevelWarn, "error": LevelError} {
+		if got, err := ParseLevel(s); err != nil || got != want {
+			t.Errorf("ParseLevel(%q) = %v, %v, want %v", s, got, err, want)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("ParseLevel(verbose) succeeded")
+	}
+}
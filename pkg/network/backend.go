@@ -0,0 +1,139 @@
+package network
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNetworkNotFound is returned by a Backend when the requested network does not exist
+var ErrNetworkNotFound = errors.New("network not found")
+
+// ErrDomainNotFound is returned by a Backend when the requested domain does not exist
+var ErrDomainNotFound = errors.New("domain not found")
+
+// NetworkState is the runtime state of a network
+type NetworkState struct {
+	Active    bool
+	Autostart bool
+}
+
+// Domain states reported in DomainDesc
+const (
+	DomainRunning     = "running"
+	DomainBlocked     = "blocked"
+	DomainPaused      = "paused"
+	DomainShutdown    = "shutting down"
+	DomainShutoff     = "shut off"
+	DomainCrashed     = "crashed"
+	DomainPMSuspended = "suspended"
+	DomainNoState     = "no state"
+)
+
+// DomainDesc describes a domain known to the hypervisor
+type DomainDesc struct {
+	Name  string
+	State string // one of the Domain* states
+	XML   string // inactive (persistent) XML description of the domain
+}
+
+// DHCPLease is an address handed out by the DHCP server of a network
+type DHCPLease struct {
+	IP       string
+	Prefix   int
+	MAC      string
+	Hostname string
+	ClientID string
+	Expiry   time.Time
+}
+
+// UpdateCommand is how a NetworkUpdate changes its section
+type UpdateCommand int
+
+// Commands of a NetworkUpdate
+const (
+	UpdateAdd UpdateCommand = iota
+	UpdateDelete
+	UpdateModify
+)
+
+// UpdateSection is the part of the network XML a NetworkUpdate changes
+type UpdateSection int
+
+// Sections of a NetworkUpdate
+const (
+	SectionDHCPHost  UpdateSection = iota // a host element of an ip/dhcp element
+	SectionDNSHost                        // a host element of the dns element
+	SectionDNSSRV                         // a srv element of the dns element
+	SectionDNSTXT                         // a txt element of the dns element
+	SectionDHCPRange                      // a range element of an ip/dhcp element
+)
+
+// NetworkUpdate is an in-place change of a single element of a network, see virNetworkUpdate
+type NetworkUpdate struct {
+	Command UpdateCommand
+	Section UpdateSection
+	// ParentIndex is the index of the ip element the change applies to, -1 for the first matching one
+	ParentIndex int
+	// XML is the element added, deleted or modified
+	XML string
+}
+
+// EventDomainChanged is the type of the BackendEvent sent when the devices or the definition of a domain changed
+const EventDomainChanged = "domain-changed"
+
+// BackendEvent is a change reported by the hypervisor: the lifecycle of a network, with Type one of the
+// EventNetwork* types, or a change of a domain with EventDomainChanged
+type BackendEvent struct {
+	Type    string
+	Network string
+	Domain  string
+}
+
+// Backend is the hypervisor API networks are managed through. Networks are addressed by name,
+// and methods return an error wrapping ErrNetworkNotFound if the named network does not exist.
+type Backend interface {
+	// ListNetworks returns the names of every (active and inactive) network
+	ListNetworks() ([]string, error)
+	// LookupNetwork returns the runtime state of the network
+	LookupNetwork(name string) (NetworkState, error)
+	// NetworkXML returns the XML description of the network
+	NetworkXML(name string) (string, error)
+	// PersistentNetworkXML returns the XML description of the network as it is defined, which differs from the one
+	// of the running network while changes are pending a restart
+	PersistentNetworkXML(name string) (string, error)
+	// DefineNetwork defines (or redefines) a persistent network from its XML description
+	DefineNetwork(xml string) error
+	// CreateNetwork starts a defined network
+	CreateNetwork(name string) error
+	// DestroyNetwork stops an active network
+	DestroyNetwork(name string) error
+	// UndefineNetwork removes the persistent definition of the network
+	UndefineNetwork(name string) error
+	// UpdateNetwork applies the change to the persistent definition of the network and, if it is active,
+	// to the running network too
+	UpdateNetwork(name string, u NetworkUpdate) error
+	// SetNetworkAutostart configures whether the network is started on host boot
+	SetNetworkAutostart(name string, autostart bool) error
+	// DHCPLeases returns the current leases of the DHCP server of the network
+	DHCPLeases(name string) ([]DHCPLease, error)
+	// ListDomains returns every (also turned off) domain
+	ListDomains() ([]DomainDesc, error)
+	// DomainState returns the current state of the domain, one of the Domain* states
+	DomainState(name string) (string, error)
+	// ShutdownDomain asks the guest of the domain to shut down, without waiting for it
+	ShutdownDomain(name string) error
+	// DestroyDomain forcefully stops the domain
+	DestroyDomain(name string) error
+	// DetachInterface removes the interface described by ifaceXML from the persistent definition of the domain and,
+	// if it is running, hot-unplugs it
+	DetachInterface(name, ifaceXML string) error
+	// WatchEvents subscribes to network lifecycle and domain changes. The returned channel is closed once ctx is
+	// done or the hypervisor connection is lost
+	WatchEvents(ctx context.Context) (<-chan BackendEvent, error)
+	// URI returns the URI of the hypervisor connection
+	URI() (string, error)
+	// Close releases the resources held by the backend
+	Close() error
+}
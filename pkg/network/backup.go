@@ -0,0 +1,290 @@
+package network
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/day0ops/netctl/pkg/config"
+	"github.com/day0ops/netctl/pkg/ipam"
+	"github.com/day0ops/netctl/pkg/log"
+)
+
+// BackupVersion is the version of the backups written by Backup. Restore refuses backups of later versions
+const BackupVersion = 1
+
+// Entries of a backup tarball
+const (
+	backupIndexFile   = "index.json"
+	backupIPAMFile    = "ipam.json"
+	backupNetworksDir = "networks"
+)
+
+// BackupIndex describes the content of a backup
+type BackupIndex struct {
+	Version       int             `json:"version" yaml:"version"`
+	NetctlVersion string          `json:"netctlVersion" yaml:"netctlVersion"`
+	Created       time.Time       `json:"created" yaml:"created"`
+	URI           string          `json:"uri" yaml:"uri"` // libvirt connection URI the networks were backed up from
+	Networks      []BackupNetwork `json:"networks" yaml:"networks"`
+}
+
+// BackupNetwork is a network saved in a backup
+type BackupNetwork struct {
+	Name      string `json:"name" yaml:"name"`
+	Autostart bool   `json:"autostart" yaml:"autostart"`
+}
+
+// Backup is a backup read by ReadBackup
+type Backup struct {
+	Index       BackupIndex
+	xml         map[string]string // persistent XML of each network
+	allocations []ipam.Allocation
+}
+
+// Backup writes every network created by netctl to w as a gzipped tarball: its persistent XML, DHCP hosts and DNS
+// records included, whether it is autostarted and its IPAM allocation. It returns the index of the backup
+func (c *Client) Backup(ctx context.Context, w io.Writer) (*BackupIndex, error) {
+	uri, err := c.backend.URI()
+	if err != nil {
+		return nil, err
+	}
+	owned, err := c.ownedNetworks()
+	if err != nil {
+		return nil, err
+	}
+
+	index := &BackupIndex{
+		Version:       BackupVersion,
+		NetctlVersion: config.AppVersion().Version,
+		Created:       time.Now().UTC().Truncate(time.Second),
+		URI:           uri,
+		Networks:      []BackupNetwork{},
+	}
+	xmls := map[string]string{}
+	allocations := []ipam.Allocation{}
+	for _, name := range owned {
+		if err := checkContext(ctx); err != nil {
+			return nil, err
+		}
+		state, err := c.backend.LookupNetwork(name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed looking up network %s", name)
+		}
+		if xmls[name], err = c.backend.PersistentNetworkXML(name); err != nil {
+			return nil, err
+		}
+		index.Networks = append(index.Networks, BackupNetwork{Name: name, Autostart: state.Autostart})
+
+		if c.ipam != nil {
+			a, err := c.ipam.Get(uri, name)
+			if err != nil {
+				return nil, err
+			}
+			if a != nil {
+				allocations = append(allocations, *a)
+			}
+		}
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	add := func(name string, data []byte) error {
+		hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), ModTime: index.Created}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	indexJSON, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := add(backupIndexFile, indexJSON); err != nil {
+		return nil, fmt.Errorf("failed writing backup: %w", err)
+	}
+	for _, n := range index.Networks {
+		if err := add(path.Join(backupNetworksDir, n.Name+".xml"), []byte(xmls[n.Name])); err != nil {
+			return nil, fmt.Errorf("failed writing backup: %w", err)
+		}
+	}
+	ipamJSON, err := json.MarshalIndent(struct {
+		Allocations []ipam.Allocation `json:"allocations"`
+	}{allocations}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := add(backupIPAMFile, ipamJSON); err != nil {
+		return nil, fmt.Errorf("failed writing backup: %w", err)
+	}
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("failed writing backup: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("failed writing backup: %w", err)
+	}
+	log.Infof("backed up %d networks", len(index.Networks))
+	return index, nil
+}
+
+// ReadBackup reads a backup written by Backup, checking every network of its index is in it
+func ReadBackup(r io.Reader) (*Backup, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading backup: %w", err)
+	}
+	defer gz.Close()
+
+	b := &Backup{xml: map[string]string{}}
+	hasIndex := false
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed reading backup: %w", err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading backup: %w", err)
+		}
+
+		switch dir, file := path.Split(hdr.Name); {
+		case hdr.Name == backupIndexFile:
+			if err := json.Unmarshal(data, &b.Index); err != nil {
+				return nil, fmt.Errorf("invalid backup index: %w", err)
+			}
+			hasIndex = true
+		case hdr.Name == backupIPAMFile:
+			var db struct {
+				Allocations []ipam.Allocation `json:"allocations"`
+			}
+			if err := json.Unmarshal(data, &db); err != nil {
+				return nil, fmt.Errorf("invalid backup IPAM state: %w", err)
+			}
+			b.allocations = db.Allocations
+		case dir == backupNetworksDir+"/" && strings.HasSuffix(file, ".xml"):
+			b.xml[strings.TrimSuffix(file, ".xml")] = string(data)
+		}
+	}
+
+	if !hasIndex {
+		return nil, fmt.Errorf("invalid backup: %s is missing", backupIndexFile)
+	}
+	if b.Index.Version > BackupVersion {
+		return nil, fmt.Errorf("backup version %d is not supported, netctl %s reads backups up to version %d", b.Index.Version, config.AppVersion().Version, BackupVersion)
+	}
+	for _, n := range b.Index.Networks {
+		if _, ok := b.xml[n.Name]; !ok {
+			return nil, fmt.Errorf("invalid backup: network %s is missing", n.Name)
+		}
+	}
+	return b, nil
+}
+
+// RestoreOptions selects the networks Restore recreates, and how
+type RestoreOptions struct {
+	// Networks are the names of the backed up networks to restore, every network of the backup if empty
+	Networks []string
+
+	// Rename gives new names to networks, by backed up name
+	Rename map[string]string
+
+	// Subnets move networks to other subnets, by backed up name. See ImportOptions.Subnets
+	Subnets map[string][]string
+
+	// RemapSubnet moves networks to the next free subnets when theirs are in use, see ImportOptions.RemapSubnet
+	RemapSubnet bool
+}
+
+// validate returns an error if the options name networks the backup doesn't have
+func (opts RestoreOptions) validate(b *Backup) error {
+	names := opts.Networks
+	for name := range opts.Rename {
+		names = append(names, name)
+	}
+	for name := range opts.Subnets {
+		names = append(names, name)
+	}
+	for _, name := range names {
+		if _, ok := b.xml[name]; !ok {
+			return fmt.Errorf("network %s is not in the backup", name)
+		}
+	}
+	return nil
+}
+
+// Restore recreates the networks of the backup, or the selected ones, like Import does: refusing the networks
+// already defined and the subnets in use unless remapped, and starting every network to verify it. The IPAM
+// allocations of the networks restored as they were are kept. It returns the restored networks, up to the first
+// failure
+func (c *Client) Restore(ctx context.Context, b *Backup, opts RestoreOptions) ([]*Result, error) {
+	if err := opts.validate(b); err != nil {
+		return nil, err
+	}
+	uri, err := c.backend.URI()
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*Result
+	for _, bn := range b.Index.Networks {
+		if len(opts.Networks) > 0 && !slices.Contains(opts.Networks, bn.Name) {
+			continue
+		}
+		if err := checkContext(ctx); err != nil {
+			return results, err
+		}
+		imp, err := parseImportXML([]byte(b.xml[bn.Name]))
+		if err != nil {
+			return results, errors.Wrapf(err, "network '%s'", bn.Name)
+		}
+		imp.network.NoAutostart = !bn.Autostart
+		r, err := c.importNetwork(ctx, imp, ImportOptions{
+			RemapSubnet: opts.RemapSubnet,
+			Name:        opts.Rename[bn.Name],
+			Subnets:     opts.Subnets[bn.Name],
+		})
+		if err != nil {
+			return results, errors.Wrapf(err, "failed to restore network %s", bn.Name)
+		}
+		results = append(results, r)
+		if err := c.restoreAllocation(b, uri, bn.Name, r); err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// restoreAllocation puts back the backed up IPAM allocation of the network restored as r, if it kept its name and
+// subnets
+func (c *Client) restoreAllocation(b *Backup, uri, name string, r *Result) error {
+	if c.ipam == nil || r.Name != name {
+		return nil
+	}
+	var cidrs []string
+	for _, s := range []*SubnetResult{r.Subnet, r.SubnetV6} {
+		if s != nil {
+			cidrs = append(cidrs, s.CIDR)
+		}
+	}
+	for _, a := range b.allocations {
+		if a.Network == name && slices.Equal(a.CIDRs, cidrs) {
+			a.URI = uri
+			return c.ipam.Reserve(a)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/day0ops/netctl/pkg/ipam"
+)
+
+func TestBackupAndRestore(t *testing.T) {
+	stubSubnets(t)
+	ctx := context.Background()
+	store := ipam.NewStore(filepath.Join(t.TempDir(), "ipam.json"))
+	b := NewFakeBackend()
+	c := NewClientWithBackend(b).WithIPAM(store)
+	lab := &Network{Name: "lab", Bridge: "virbr-lab", Subnet: "10.89.0.1/24", NoAutostart: true}
+	for _, n := range []*Network{testNetwork(), lab} {
+		if _, err := c.EnsureNetwork(ctx, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.AddHosts(ctx, "test-net", []Host{{MAC: "52:54:00:aa:00:01", IP: "192.168.123.10"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.DefineNetwork(`<network><name>unmanaged</name><ip address='10.100.0.1' netmask='255.255.255.0'/></network>`); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	index, err := c.Backup(ctx, &buf)
+	if err != nil {
+		t.Fatalf("Backup() error = %v", err)
+	}
+	if index.Version != BackupVersion || len(index.Networks) != 2 {
+		t.Errorf("Backup() = %+v, want the two networks created by netctl", index)
+	}
+
+	backup, err := ReadBackup(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("ReadBackup() error = %v", err)
+	}
+	if len(backup.Index.Networks) != 2 || backup.Index.Networks[0].Name != "lab" || backup.Index.Networks[0].Autostart {
+		t.Errorf("backup index = %+v", backup.Index)
+	}
+
+	// a rebuilt hypervisor, with a fresh IPAM database
+	stubSubnets(t)
+	restoredStore := ipam.NewStore(filepath.Join(t.TempDir(), "ipam.json"))
+	restored := NewFakeBackend()
+	rc := NewClientWithBackend(restored).WithIPAM(restoredStore)
+	if _, err := rc.Restore(ctx, backup, RestoreOptions{Networks: []string{"missing"}}); err == nil {
+		t.Error("Restore() of a network missing from the backup succeeded")
+	}
+	results, err := rc.Restore(ctx, backup, RestoreOptions{Rename: map[string]string{"lab": "lab-2"}, Subnets: map[string][]string{"lab": {"10.90.0.1/24"}}})
+	if err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if len(results) != 2 || results[0].Name != "lab-2" || results[0].Subnet.CIDR != "10.90.0.0/24" {
+		t.Errorf("Restore() = %+v", results)
+	}
+	if state, err := restored.LookupNetwork("lab-2"); err != nil || !state.Active || state.Autostart {
+		t.Errorf("restored lab-2 state = %+v, %v, want active without autostart", state, err)
+	}
+	hosts, err := rc.ListHosts(ctx, "test-net")
+	if err != nil || len(hosts) != 1 {
+		t.Errorf("hosts of the restored network = %+v, %v", hosts, err)
+	}
+
+	before, _ := store.Get(FakeURI, "test-net")
+	after, err := restoredStore.Get(FakeURI, "test-net")
+	if err != nil || after == nil || !after.Created.Equal(before.Created) {
+		t.Errorf("restored allocation = %+v, %v, want %+v", after, err, before)
+	}
+	if a, _ := restoredStore.Get(FakeURI, "lab-2"); a == nil || a.CIDRs[0] != "10.90.0.0/24" || time.Since(a.Created) > time.Minute {
+		t.Errorf("allocation of the remapped network = %+v", a)
+	}
+}
+
+func TestRestoreRenamedNextToOriginal(t *testing.T) {
+	stubSubnets(t)
+	ctx := context.Background()
+	b := NewFakeBackend()
+	c := NewClientWithBackend(b)
+	if _, err := c.EnsureNetwork(ctx, &Network{Name: "lab", Bridge: "virbr-lab", Subnet: "10.89.0.1/24", NoAutostart: true}); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := c.Backup(ctx, &buf); err != nil {
+		t.Fatal(err)
+	}
+	backup, err := ReadBackup(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := c.Restore(ctx, backup, RestoreOptions{Rename: map[string]string{"lab": "lab-2"}, RemapSubnet: true})
+	if err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if len(results) != 1 || results[0].Bridge == "virbr-lab" {
+		t.Errorf("Restore() = %+v, want lab-2 on a bridge of its own", results)
+	}
+	if state, err := b.LookupNetwork("lab-2"); err != nil || !state.Active {
+		t.Errorf("restored lab-2 state = %+v, %v, want active", state, err)
+	}
+}
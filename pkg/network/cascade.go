@@ -0,0 +1,111 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/day0ops/netctl/pkg/log"
+	"github.com/day0ops/netctl/pkg/util"
+)
+
+// Cascade modes of DeleteNetwork
+const (
+	CascadeShutdown = "shutdown"
+	CascadeDetach   = "detach"
+)
+
+const defaultShutdownTimeout = 2 * time.Minute
+
+func (opts *DeleteOptions) validate() error {
+	switch opts.Cascade {
+	case "", CascadeShutdown, CascadeDetach:
+	default:
+		return fmt.Errorf("unsupported cascade mode %q (must be %s or %s)", opts.Cascade, CascadeShutdown, CascadeDetach)
+	}
+	if opts.ShutdownTimeout < 0 {
+		return fmt.Errorf("shutdown timeout can't be negative")
+	}
+	return nil
+}
+
+// releaseDomains shuts down the domains using the named network, or detaches their interfaces on it
+func (c *Client) releaseDomains(ctx context.Context, name string, opts DeleteOptions) error {
+	ifaces, err := c.DomainsUsing(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	if opts.Cascade == CascadeDetach {
+		for _, i := range ifaces {
+			if err := checkContext(ctx); err != nil {
+				return err
+			}
+			log.Infof("detaching interface %s of domain %s from network %s", i.MAC, i.Domain, name)
+			ifaceXML := fmt.Sprintf("<interface type='%s'><mac address='%s'/></interface>", i.Type, i.MAC)
+			if err := c.backend.DetachInterface(i.Domain, ifaceXML); err != nil {
+				return fmt.Errorf("failed detaching interface %s of domain %s: %w", i.MAC, i.Domain, err)
+			}
+		}
+		return nil
+	}
+
+	timeout := opts.ShutdownTimeout
+	if timeout == 0 {
+		timeout = defaultShutdownTimeout
+	}
+	for _, dom := range domainNames(ifaces) {
+		if err := c.stopDomain(ctx, dom, timeout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stopDomain shuts the domain down gracefully, destroying it if it is still running after timeout. A domain already
+// shutting down is given the same time to finish. Domains that can't handle a shutdown request (for e.g. paused or
+// crashed ones) are destroyed right away
+func (c *Client) stopDomain(ctx context.Context, name string, timeout time.Duration) error {
+	state, err := c.backend.DomainState(name)
+	if err != nil {
+		return err
+	}
+	switch state {
+	case DomainShutoff:
+		log.Infof("domain %s is already shut off", name)
+		return nil
+	case DomainRunning, DomainBlocked, DomainShutdown:
+		if state == DomainShutdown {
+			log.Infof("domain %s is already shutting down (waiting up to %s)", name, timeout)
+		} else {
+			log.Infof("shutting down domain %s (waiting up to %s)", name, timeout)
+			if err := c.backend.ShutdownDomain(name); err != nil {
+				return fmt.Errorf("failed shutting down domain %s: %w", name, err)
+			}
+		}
+		err := util.LocalRetryWithContext(ctx, func() error {
+			state, err := c.backend.DomainState(name)
+			if err != nil {
+				return err
+			}
+			if state != DomainShutoff {
+				return fmt.Errorf("domain %s is still %s", name, state)
+			}
+			return nil
+		}, timeout)
+		if err == nil {
+			log.Infof("domain %s is shut off", name)
+			return nil
+		}
+		if err := checkContext(ctx); err != nil {
+			return err
+		}
+		log.Warnf("domain %s did not shut down within %s: %v", name, timeout, err)
+	}
+
+	log.Infof("destroying domain %s", name)
+	if err := c.backend.DestroyDomain(name); err != nil {
+		return fmt.Errorf("failed destroying domain %s: %w", name, err)
+	}
+	return nil
+}
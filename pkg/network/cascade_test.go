@@ -0,0 +1,105 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeleteNetworkCascadeShutdown(t *testing.T) {
+	for _, ignoreShutdown := range []bool{false, true} {
+		b, c := createTestNetwork(t)
+		b.IgnoreShutdown = ignoreShutdown
+		b.AddDomain("vm", `<domain><name>vm</name><devices>
+  <interface type='network'><mac address='52:54:00:00:00:01'/><source network='test-net'/></interface>
+</devices></domain>`)
+
+		opts := DeleteOptions{Cascade: CascadeShutdown, ShutdownTimeout: 300 * time.Millisecond}
+		if _, err := c.DeleteNetwork(context.Background(), "test-net", opts); err != nil {
+			t.Fatalf("DeleteNetwork(ignoreShutdown=%v) error = %v", ignoreShutdown, err)
+		}
+		if _, err := b.LookupNetwork("test-net"); !errors.Is(err, ErrNetworkNotFound) {
+			t.Errorf("network still defined after cascade, lookup error = %v", err)
+		}
+		if state, _ := b.DomainState("vm"); state != DomainShutoff {
+			t.Errorf("domain state = %s, want %s", state, DomainShutoff)
+		}
+		destroys := 0
+		if ignoreShutdown {
+			destroys = 1
+		}
+		if got := b.Calls["DestroyDomain"]; got != destroys {
+			t.Errorf("DestroyDomain(ignoreShutdown=%v) called %d times, want %d", ignoreShutdown, got, destroys)
+		}
+	}
+}
+
+func TestDeleteNetworkCascadeDomainStates(t *testing.T) {
+	tests := []struct {
+		state     string
+		shutdowns int
+		destroys  int
+		wait      bool // whether the domain is given the shutdown timeout before being destroyed
+	}{
+		{state: DomainRunning, shutdowns: 1},
+		{state: DomainBlocked, shutdowns: 1},
+		{state: DomainShutdown, destroys: 1, wait: true},
+		{state: DomainPaused, destroys: 1},
+		{state: DomainCrashed, destroys: 1},
+		{state: DomainShutoff},
+	}
+	for _, tt := range tests {
+		t.Run(tt.state, func(t *testing.T) {
+			b, c := createTestNetwork(t)
+			b.AddDomain("vm", domainOn("test-net"))
+			if err := b.SetDomainState("vm", tt.state); err != nil {
+				t.Fatal(err)
+			}
+
+			// long enough for the first retry of the shutdown wait, which is randomized
+			timeout := time.Second
+			start := time.Now()
+			if err := c.stopDomain(context.Background(), "vm", timeout); err != nil {
+				t.Fatalf("stopDomain() error = %v", err)
+			}
+			if waited := time.Since(start) >= 100*time.Millisecond; waited != tt.wait {
+				t.Errorf("stopDomain() waited for the shutdown = %v, want %v", waited, tt.wait)
+			}
+			if state, _ := b.DomainState("vm"); state != DomainShutoff {
+				t.Errorf("domain state = %s, want %s", state, DomainShutoff)
+			}
+			if b.Calls["ShutdownDomain"] != tt.shutdowns || b.Calls["DestroyDomain"] != tt.destroys {
+				t.Errorf("ShutdownDomain called %d times and DestroyDomain %d times, want %d and %d",
+					b.Calls["ShutdownDomain"], b.Calls["DestroyDomain"], tt.shutdowns, tt.destroys)
+			}
+		})
+	}
+}
+
+func TestDeleteNetworkCascadeDetach(t *testing.T) {
+	b, c := createTestNetwork(t)
+	b.AddDomain("vm", `<domain><name>vm</name><devices>
+  <interface type='network'><mac address='52:54:00:00:00:01'/><source network='test-net'/></interface>
+  <interface type='network'><mac address='52:54:00:00:00:02'/><source network='default'/></interface>
+</devices></domain>`)
+
+	if _, err := c.DeleteNetwork(context.Background(), "test-net", DeleteOptions{Cascade: CascadeDetach}); err != nil {
+		t.Fatalf("DeleteNetwork() error = %v", err)
+	}
+	if state, _ := b.DomainState("vm"); state != DomainRunning {
+		t.Errorf("domain state = %s, want %s", state, DomainRunning)
+	}
+	doms, _ := b.ListDomains()
+	if strings.Contains(doms[0].XML, "52:54:00:00:00:01") || !strings.Contains(doms[0].XML, "52:54:00:00:00:02") {
+		t.Errorf("domain XML = %s, want only the interface on test-net detached", doms[0].XML)
+	}
+}
+
+func TestDeleteNetworkInvalidCascade(t *testing.T) {
+	_, c := createTestNetwork(t)
+	if _, err := c.DeleteNetwork(context.Background(), "test-net", DeleteOptions{Cascade: "pause"}); err == nil {
+		t.Error("DeleteNetwork() with an unsupported cascade mode succeeded")
+	}
+}
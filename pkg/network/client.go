@@ -0,0 +1,66 @@
+package network
+
+import (
+	"context"
+	"fmt"
+
+	"libvirt.org/go/libvirt"
+
+	"github.com/day0ops/netctl/pkg/ipam"
+	"github.com/day0ops/netctl/pkg/log"
+)
+
+// Client manages networks through a single shared Backend (usually one libvirt connection)
+type Client struct {
+	backend Backend
+
+	// optional database the subnets of created networks are recorded in
+	ipam *ipam.Store
+}
+
+// NewClient opens a libvirt connection to connectionURI and returns a client using it. Close has to be called once done
+func NewClient(connectionURI string) (*Client, error) {
+	conn, err := getConnection(connectionURI)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening libvirt connection: %w", err)
+	}
+	log.With(log.Fields{log.FieldURI: connectionURI}).Debugf("connected to libvirt at %s", connectionURI)
+	return &Client{backend: &libvirtBackend{conn: conn, ownsConn: true}}, nil
+}
+
+// NewClientFromConnect returns a client using an existing libvirt connection. The connection stays owned by the caller
+func NewClientFromConnect(conn *libvirt.Connect) *Client {
+	return NewClientWithBackend(NewLibvirtBackend(conn))
+}
+
+// NewClientWithBackend returns a client managing networks through the given backend
+func NewClientWithBackend(backend Backend) *Client {
+	return &Client{backend: backend}
+}
+
+// Close releases the backend, closing the libvirt connection if it was opened by NewClient
+func (c *Client) Close() error {
+	return c.backend.Close()
+}
+
+// withClient runs f with a short-lived client connected to connectionURI
+func withClient(connectionURI string, f func(c *Client) error) error {
+	c, err := NewClient(connectionURI)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := c.Close(); err != nil {
+			log.Error(err)
+		}
+	}()
+	return f(c)
+}
+
+// checkContext returns the context error, if any, so long-running operations can bail out between libvirt calls
+func checkContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("aborted: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,292 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/day0ops/netctl/pkg/log"
+)
+
+// UsedSubnet is a subnet already in use on the host
+type UsedSubnet struct {
+	Subnet *net.IPNet
+	Owner  string // what is using the subnet, for e.g. "libvirt network default"
+}
+
+// ConflictSource reports subnets in use that a new network must not overlap with
+type ConflictSource interface {
+	// Name describes the source in logs
+	Name() string
+	// UsedSubnets returns the subnets currently in use according to the source
+	UsedSubnets() ([]UsedSubnet, error)
+}
+
+// Container runtimes whose networks can be checked for conflicts
+const (
+	ContainerRuntimeDocker = "docker"
+	ContainerRuntimePodman = "podman"
+)
+
+// conflictSources returns the sources every free subnet search checks, on top of the local network interfaces
+var conflictSources = func(b Backend) []ConflictSource {
+	return []ConflictSource{NewLibvirtNetworkSource(b), NewRouteSource()}
+}
+
+// overlaps returns the first used subnet overlapping with network, if any
+func overlaps(network *net.IPNet, used []UsedSubnet) (UsedSubnet, bool) {
+	for _, u := range used {
+		if u.Subnet.Contains(network.IP) || network.Contains(u.Subnet.IP) {
+			return u, true
+		}
+	}
+	return UsedSubnet{}, false
+}
+
+// collectUsedSubnets gathers the used subnets of all sources
+func collectUsedSubnets(sources []ConflictSource) ([]UsedSubnet, error) {
+	var used []UsedSubnet
+	for _, source := range sources {
+		subnets, err := source.UsedSubnets()
+		if err != nil {
+			return nil, errors.Wrapf(err, "listing subnets used by %s", source.Name())
+		}
+		log.Debugf("%s uses %d subnets", source.Name(), len(subnets))
+		used = append(used, subnets...)
+	}
+	return used, nil
+}
+
+type libvirtNetworkSource struct {
+	backend Backend
+}
+
+// NewLibvirtNetworkSource returns a source reporting the subnets of every (active and inactive) libvirt network
+func NewLibvirtNetworkSource(b Backend) ConflictSource {
+	return &libvirtNetworkSource{backend: b}
+}
+
+func (s *libvirtNetworkSource) Name() string {
+	return "libvirt networks"
+}
+
+func (s *libvirtNetworkSource) UsedSubnets() ([]UsedSubnet, error) {
+	names, err := s.backend.ListNetworks()
+	if err != nil {
+		return nil, err
+	}
+
+	var used []UsedSubnet
+	for _, name := range names {
+		xmlString, err := s.backend.NetworkXML(name)
+		if err != nil {
+			return nil, err
+		}
+		v, err := parseNetworkXML(xmlString)
+		if err != nil {
+			return nil, errors.Wrapf(err, "network '%s'", name)
+		}
+		for _, ip := range v.IPs {
+			_, subnet, err := net.ParseCIDR(ip.cidr())
+			if err != nil {
+				log.Debugf("failed parsing address of network %s: %v", name, err)
+				continue
+			}
+			used = append(used, UsedSubnet{Subnet: subnet, Owner: "libvirt network " + name})
+		}
+	}
+	return used, nil
+}
+
+type routeSource struct {
+	ipv4Path string
+	ipv6Path string
+}
+
+// NewRouteSource returns a source reporting the destinations of the kernel routing tables, for e.g. VPN ranges.
+// Default, loopback, link-local and multicast routes are ignored
+func NewRouteSource() ConflictSource {
+	return &routeSource{ipv4Path: "/proc/net/route", ipv6Path: "/proc/net/ipv6_route"}
+}
+
+func (s *routeSource) Name() string {
+	return "kernel routes"
+}
+
+func (s *routeSource) UsedSubnets() ([]UsedSubnet, error) {
+	used, err := readRoutes(s.ipv4Path, parseIPv4Route)
+	if err != nil {
+		return nil, err
+	}
+	used6, err := readRoutes(s.ipv6Path, parseIPv6Route)
+	if err != nil {
+		return nil, err
+	}
+	return append(used, used6...), nil
+}
+
+// readRoutes parses every line of a /proc/net routing table. A missing table (for e.g. IPv6 disabled) has no routes
+func readRoutes(path string, parse func(fields []string) (*UsedSubnet, error)) ([]UsedSubnet, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed reading routes: %w", err)
+	}
+	defer f.Close()
+
+	var used []UsedSubnet
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		route, err := parse(strings.Fields(scanner.Text()))
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing route %q from %s: %w", scanner.Text(), path, err)
+		}
+		if route == nil {
+			continue
+		}
+		ip := route.Subnet.IP
+		if ones, _ := route.Subnet.Mask.Size(); ones == 0 || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsMulticast() {
+			continue
+		}
+		used = append(used, *route)
+	}
+	return used, scanner.Err()
+}
+
+// parseIPv4Route parses a line of /proc/net/route, where addresses are hex encoded in host byte order
+func parseIPv4Route(fields []string) (*UsedSubnet, error) {
+	if len(fields) < 8 || fields[0] == "Iface" {
+		return nil, nil
+	}
+	dst, err := parseHostOrderIPv4(fields[1])
+	if err != nil {
+		return nil, err
+	}
+	mask, err := parseHostOrderIPv4(fields[7])
+	if err != nil {
+		return nil, err
+	}
+	return &UsedSubnet{
+		Subnet: &net.IPNet{IP: dst.Mask(net.IPMask(mask)), Mask: net.IPMask(mask)},
+		Owner:  "route via " + fields[0],
+	}, nil
+}
+
+func parseHostOrderIPv4(s string) (net.IP, error) {
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return nil, err
+	}
+	ip := make(net.IP, net.IPv4len)
+	binary.LittleEndian.PutUint32(ip, uint32(v))
+	return ip, nil
+}
+
+// parseIPv6Route parses a line of /proc/net/ipv6_route, where addresses are hex encoded in network byte order
+func parseIPv6Route(fields []string) (*UsedSubnet, error) {
+	if len(fields) < 10 {
+		return nil, nil
+	}
+	dst, err := hex.DecodeString(fields[0])
+	if err != nil || len(dst) != net.IPv6len {
+		return nil, fmt.Errorf("invalid destination %q", fields[0])
+	}
+	prefix, err := strconv.ParseUint(fields[1], 16, 8)
+	if err != nil || prefix > 128 {
+		return nil, fmt.Errorf("invalid prefix length %q", fields[1])
+	}
+	mask := net.CIDRMask(int(prefix), 8*net.IPv6len)
+	return &UsedSubnet{
+		Subnet: &net.IPNet{IP: net.IP(dst).Mask(mask), Mask: mask},
+		Owner:  "route via " + fields[9],
+	}, nil
+}
+
+type containerNetworkSource struct {
+	runtime string
+}
+
+// NewContainerNetworkSource returns a source reporting the subnets of the networks of a container runtime
+// (docker or podman). Nothing is reported if the runtime is not installed
+func NewContainerNetworkSource(runtime string) ConflictSource {
+	return &containerNetworkSource{runtime: runtime}
+}
+
+func (s *containerNetworkSource) Name() string {
+	return s.runtime + " networks"
+}
+
+func (s *containerNetworkSource) UsedSubnets() ([]UsedSubnet, error) {
+	if _, err := exec.LookPath(s.runtime); err != nil {
+		log.Debugf("%s is not installed, skipping its networks", s.runtime)
+		return nil, nil
+	}
+
+	ids, err := exec.Command(s.runtime, "network", "ls", "-q").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed listing %s networks: %w", s.runtime, err)
+	}
+	if len(bytes.TrimSpace(ids)) == 0 {
+		return nil, nil
+	}
+
+	out, err := exec.Command(s.runtime, append([]string{"network", "inspect"}, strings.Fields(string(ids))...)...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed inspecting %s networks: %w", s.runtime, err)
+	}
+	return ParseContainerNetworks(bytes.NewReader(out), s.runtime)
+}
+
+// containerNetworkJSON covers both the docker and the podman (netavark) network inspect formats
+type containerNetworkJSON struct {
+	Name string `json:"name"`
+	IPAM struct {
+		Config []struct {
+			Subnet string `json:"Subnet"`
+		} `json:"Config"`
+	} `json:"IPAM"`
+	Subnets []struct {
+		Subnet string `json:"subnet"`
+	} `json:"subnets"`
+}
+
+// ParseContainerNetworks reads the subnets out of the JSON output of docker or podman network inspect
+func ParseContainerNetworks(r io.Reader, runtime string) ([]UsedSubnet, error) {
+	var networks []containerNetworkJSON
+	if err := json.NewDecoder(r).Decode(&networks); err != nil {
+		return nil, fmt.Errorf("failed decoding %s networks: %w", runtime, err)
+	}
+
+	var used []UsedSubnet
+	for _, n := range networks {
+		cidrs := make([]string, 0, len(n.IPAM.Config)+len(n.Subnets))
+		for _, c := range n.IPAM.Config {
+			cidrs = append(cidrs, c.Subnet)
+		}
+		for _, s := range n.Subnets {
+			cidrs = append(cidrs, s.Subnet)
+		}
+		for _, cidr := range cidrs {
+			_, subnet, err := net.ParseCIDR(cidr)
+			if err != nil {
+				log.Debugf("failed parsing subnet %q of %s network %s: %v", cidr, runtime, n.Name, err)
+				continue
+			}
+			used = append(used, UsedSubnet{Subnet: subnet, Owner: fmt.Sprintf("%s network %s", runtime, n.Name)})
+		}
+	}
+	return used, nil
+}
@@ -0,0 +1,95 @@
+package network
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRouteSource(t *testing.T) {
+	dir := t.TempDir()
+	ipv4 := filepath.Join(dir, "route")
+	ipv6 := filepath.Join(dir, "ipv6_route")
+	writeFile(t, ipv4, `Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+eth0	00000000	0101A8C0	0003	0	0	100	00000000	0	0	0
+tun0	0000080A	00000000	0001	0	0	0	0000FFFF	0	0	0
+`)
+	writeFile(t, ipv6, `fd000000000000000000000000000000 08 00000000000000000000000000000000 00 00000000000000000000000000000000 00000400 00000001 00000000 00000001     tun0
+fe800000000000000000000000000000 40 00000000000000000000000000000000 00 00000000000000000000000000000000 00000100 00000001 00000000 00000001     eth0
+00000000000000000000000000000001 80 00000000000000000000000000000000 00 00000000000000000000000000000000 00000000 00000002 00000000 80200001       lo
+`)
+
+	used, err := (&routeSource{ipv4Path: ipv4, ipv6Path: ipv6}).UsedSubnets()
+	if err != nil {
+		t.Fatalf("UsedSubnets() error = %v", err)
+	}
+	var got []string
+	for _, u := range used {
+		got = append(got, u.Subnet.String()+" "+u.Owner)
+	}
+	want := []string{"10.8.0.0/16 route via tun0", "fd00::/8 route via tun0"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("UsedSubnets() = %v, want %v", got, want)
+	}
+}
+
+func TestParseContainerNetworks(t *testing.T) {
+	docker := `[{"Name":"bridge","IPAM":{"Config":[{"Subnet":"172.17.0.0/16","Gateway":"172.17.0.1"}]}},{"Name":"host","IPAM":{"Config":[]}}]`
+	podman := `[{"name":"podman","subnets":[{"subnet":"10.88.0.0/16","gateway":"10.88.0.1"},{"subnet":"fd00:88::/64"}]}]`
+
+	for runtime, input := range map[string]string{ContainerRuntimeDocker: docker, ContainerRuntimePodman: podman} {
+		used, err := ParseContainerNetworks(strings.NewReader(input), runtime)
+		if err != nil {
+			t.Fatalf("ParseContainerNetworks(%s) error = %v", runtime, err)
+		}
+		var got []string
+		for _, u := range used {
+			got = append(got, u.Subnet.String()+" "+u.Owner)
+		}
+		want := map[string]string{
+			ContainerRuntimeDocker: "172.17.0.0/16 docker network bridge",
+			ContainerRuntimePodman: "10.88.0.0/16 podman network podman,fd00:88::/64 podman network podman",
+		}[runtime]
+		if strings.Join(got, ",") != want {
+			t.Errorf("ParseContainerNetworks(%s) = %v, want %s", runtime, got, want)
+		}
+	}
+}
+
+func TestCreateNetworkSkipsSubnetsOfOtherLibvirtNetworks(t *testing.T) {
+	stubSubnets(t)
+	b := NewFakeBackend()
+	// an inactive network owning the requested subnet
+	if err := b.DefineNetwork(`<network><name>other</name><ip address='192.168.123.1' netmask='255.255.255.0'/></network>`); err != nil {
+		t.Fatal(err)
+	}
+	c := NewClientWithBackend(b)
+
+	n, skipped, err := FindFreeSubnet("192.168.123.1/24", 11, 2, NewLibvirtNetworkSource(b))
+	if err != nil {
+		t.Fatalf("FindFreeSubnet() error = %v", err)
+	}
+	if n.CIDR != "192.168.134.0/24" {
+		t.Errorf("FindFreeSubnet() = %s, want 192.168.134.0/24", n.CIDR)
+	}
+	if len(skipped) != 1 || skipped[0].Reason != "overlaps with 192.168.123.0/24 used by libvirt network other" {
+		t.Errorf("FindFreeSubnet() skipped = %+v", skipped)
+	}
+
+	if _, err := c.createNetwork(context.Background(), testNetwork()); err != nil {
+		t.Fatalf("createNetwork() error = %v", err)
+	}
+	xml, _ := b.NetworkXML("test-net")
+	if strings.Contains(xml, "192.168.123.1") {
+		t.Errorf("network overlaps with another libvirt network:\n%s", xml)
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
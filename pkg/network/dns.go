@@ -0,0 +1,310 @@
+package network
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/day0ops/netctl/pkg/log"
+)
+
+// Types of DNS records
+const (
+	DNSRecordHost = "host"
+	DNSRecordSRV  = "srv"
+	DNSRecordTXT  = "txt"
+)
+
+// DNSRecord is a record served by the DNS server of a network. Only the fields of its type are set:
+// an A/AAAA host record maps IP to Hostnames, a SRV record locates Service over Protocol at Target:Port,
+// and a TXT record maps Name to Value
+type DNSRecord struct {
+	Type string `json:"type" yaml:"type"`
+
+	IP        string   `json:"ip,omitempty" yaml:"ip,omitempty"`
+	Hostnames []string `json:"hostnames,omitempty" yaml:"hostnames,omitempty"`
+
+	Service  string `json:"service,omitempty" yaml:"service,omitempty"`
+	Protocol string `json:"protocol,omitempty" yaml:"protocol,omitempty"`
+	Domain   string `json:"domain,omitempty" yaml:"domain,omitempty"`
+	Target   string `json:"target,omitempty" yaml:"target,omitempty"`
+	Port     int    `json:"port,omitempty" yaml:"port,omitempty"`
+	Priority int    `json:"priority,omitempty" yaml:"priority,omitempty"`
+	Weight   int    `json:"weight,omitempty" yaml:"weight,omitempty"`
+
+	Name  string `json:"name,omitempty" yaml:"name,omitempty"`
+	Value string `json:"value,omitempty" yaml:"value,omitempty"`
+}
+
+// dnsXML is the dns element of the network XML
+type dnsXML struct {
+	Enable     string `xml:"enable,attr"`
+	Forwarders []struct {
+		Domain string `xml:"domain,attr"`
+		Addr   string `xml:"addr,attr"`
+	} `xml:"forwarder"`
+	Hosts []dnsHostXML `xml:"host"`
+	SRVs  []dnsSRVXML  `xml:"srv"`
+	TXTs  []dnsTXTXML  `xml:"txt"`
+}
+
+type dnsHostXML struct {
+	XMLName   xml.Name `xml:"host"`
+	IP        string   `xml:"ip,attr"`
+	Hostnames []string `xml:"hostname"`
+}
+
+type dnsSRVXML struct {
+	XMLName  xml.Name `xml:"srv"`
+	Service  string   `xml:"service,attr"`
+	Protocol string   `xml:"protocol,attr"`
+	Domain   string   `xml:"domain,attr,omitempty"`
+	Target   string   `xml:"target,attr,omitempty"`
+	Port     int      `xml:"port,attr,omitempty"`
+	Priority int      `xml:"priority,attr,omitempty"`
+	Weight   int      `xml:"weight,attr,omitempty"`
+}
+
+type dnsTXTXML struct {
+	XMLName xml.Name `xml:"txt"`
+	Name    string   `xml:"name,attr"`
+	Value   string   `xml:"value,attr"`
+}
+
+// dnsForwarder is an upstream server queries are forwarded to, only for names in Domain if it is set
+type dnsForwarder struct {
+	Domain string
+	Addr   string
+}
+
+var hostnameRegexp = regexp.MustCompile(`^([A-Za-z0-9]([-A-Za-z0-9]*[A-Za-z0-9])?\.)*[A-Za-z0-9]([-A-Za-z0-9]*[A-Za-z0-9])?\.?$`)
+
+func validHostname(name string) bool {
+	return len(name) <= 253 && hostnameRegexp.MatchString(name)
+}
+
+// parseDNSForwarder parses a forwarder given as addr or domain=addr
+func parseDNSForwarder(s string) (dnsForwarder, error) {
+	f := dnsForwarder{Addr: s}
+	if i := strings.Index(s, "="); i >= 0 {
+		f.Domain, f.Addr = s[:i], s[i+1:]
+		if !validHostname(f.Domain) {
+			return f, fmt.Errorf("invalid domain %q of DNS forwarder %s", f.Domain, s)
+		}
+	}
+	if net.ParseIP(f.Addr) == nil {
+		return f, fmt.Errorf("invalid address of DNS forwarder %q (for e.g. it should be of the form 1.1.1.1 or corp.example.com=10.0.0.53)", s)
+	}
+	return f, nil
+}
+
+// dnsForwarders returns the DNS forwarders of the network, which have been validated
+func (n *Network) dnsForwarders() []dnsForwarder {
+	forwarders := make([]dnsForwarder, 0, len(n.DNSForwarders))
+	for _, s := range n.DNSForwarders {
+		f, _ := parseDNSForwarder(s)
+		forwarders = append(forwarders, f)
+	}
+	return forwarders
+}
+
+// validateDNS returns an error if the DNS options of the network are invalid
+func (n *Network) validateDNS() error {
+	if n.Domain != "" && !validHostname(n.Domain) {
+		return fmt.Errorf("invalid domain name %q", n.Domain)
+	}
+	if n.DomainLocalOnly && n.Domain == "" {
+		return fmt.Errorf("resolving the domain locally only requires a domain")
+	}
+	if len(n.DNSForwarders) > 0 && !n.DNS {
+		return fmt.Errorf("DNS forwarders require DNS to be enabled")
+	}
+	for _, s := range n.DNSForwarders {
+		if _, err := parseDNSForwarder(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListDNSRecords returns the host, SRV and TXT records served by the DNS server of the network
+func (c *Client) ListDNSRecords(ctx context.Context, network string) ([]DNSRecord, error) {
+	dns, err := c.networkDNS(network)
+	if err != nil {
+		return nil, err
+	}
+
+	records := []DNSRecord{}
+	for _, h := range dns.Hosts {
+		records = append(records, DNSRecord{Type: DNSRecordHost, IP: h.IP, Hostnames: h.Hostnames})
+	}
+	for _, s := range dns.SRVs {
+		records = append(records, DNSRecord{Type: DNSRecordSRV, Service: s.Service, Protocol: s.Protocol, Domain: s.Domain,
+			Target: s.Target, Port: s.Port, Priority: s.Priority, Weight: s.Weight})
+	}
+	for _, t := range dns.TXTs {
+		records = append(records, DNSRecord{Type: DNSRecordTXT, Name: t.Name, Value: t.Value})
+	}
+	return records, nil
+}
+
+// AddDNSRecord adds the record to the DNS server of the network, both in its persistent definition and, if it is
+// running, live. A host record can't share its address, a TXT record its name, and a SRV record its service,
+// protocol and target with an existing record
+func (c *Client) AddDNSRecord(ctx context.Context, network string, r DNSRecord) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	records, err := c.ListDNSRecords(ctx, network)
+	if err != nil {
+		return err
+	}
+	for _, other := range records {
+		if r.sameKey(other) {
+			return fmt.Errorf("network %s already has the %s record %s", network, other.Type, other)
+		}
+	}
+
+	if err := c.updateDNS(network, UpdateAdd, r); err != nil {
+		return err
+	}
+	log.Infof("added %s record %s to network %s", r.Type, r, network)
+	return nil
+}
+
+// RemoveDNSRecord removes the record with the same key (address of a host record, name of a TXT record, service,
+// protocol and target of a SRV record) from the DNS server of the network
+func (c *Client) RemoveDNSRecord(ctx context.Context, network string, r DNSRecord) error {
+	records, err := c.ListDNSRecords(ctx, network)
+	if err != nil {
+		return err
+	}
+	for _, existing := range records {
+		if !r.sameKey(existing) {
+			continue
+		}
+		if err := c.updateDNS(network, UpdateDelete, existing); err != nil {
+			return err
+		}
+		log.Infof("removed %s record %s from network %s", existing.Type, existing, network)
+		return nil
+	}
+	return fmt.Errorf("network %s has no %s record %s", network, r.Type, r)
+}
+
+func (c *Client) updateDNS(network string, cmd UpdateCommand, r DNSRecord) error {
+	var v interface{}
+	var section UpdateSection
+	switch r.Type {
+	case DNSRecordHost:
+		v, section = dnsHostXML{IP: r.IP, Hostnames: r.Hostnames}, SectionDNSHost
+	case DNSRecordSRV:
+		v, section = dnsSRVXML{Service: r.Service, Protocol: r.Protocol, Domain: r.Domain, Target: r.Target,
+			Port: r.Port, Priority: r.Priority, Weight: r.Weight}, SectionDNSSRV
+	case DNSRecordTXT:
+		v, section = dnsTXTXML{Name: r.Name, Value: r.Value}, SectionDNSTXT
+	}
+	recordXML, err := xml.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.backend.UpdateNetwork(network, NetworkUpdate{Command: cmd, Section: section, ParentIndex: -1, XML: string(recordXML)})
+}
+
+// networkDNS returns the dns element of the network, failing if its DNS server is disabled
+func (c *Client) networkDNS(network string) (*dnsXML, error) {
+	xmlString, err := c.backend.NetworkXML(network)
+	if err != nil {
+		return nil, err
+	}
+	v, err := parseNetworkXML(xmlString)
+	if err != nil {
+		return nil, errors.Wrapf(err, "network '%s'", network)
+	}
+	if v.DNS.Enable == "no" {
+		return nil, fmt.Errorf("DNS is disabled in network %s", network)
+	}
+	return &v.DNS, nil
+}
+
+// Validate returns an error if a field required by the type of the record is missing or invalid
+func (r *DNSRecord) Validate() error {
+	switch r.Type {
+	case DNSRecordHost:
+		if net.ParseIP(r.IP) == nil {
+			return fmt.Errorf("invalid address %q of host record", r.IP)
+		}
+		if len(r.Hostnames) == 0 {
+			return fmt.Errorf("a host record needs at least one hostname")
+		}
+		for _, name := range r.Hostnames {
+			if !validHostname(name) {
+				return fmt.Errorf("invalid hostname %q", name)
+			}
+		}
+	case DNSRecordSRV:
+		if r.Service == "" || len(r.Service) > 15 {
+			return fmt.Errorf("a SRV record needs a service name of at most 15 characters")
+		}
+		if r.Protocol != "tcp" && r.Protocol != "udp" {
+			return fmt.Errorf("unsupported SRV record protocol %q (must be tcp or udp)", r.Protocol)
+		}
+		if r.Domain != "" && !validHostname(r.Domain) {
+			return fmt.Errorf("invalid domain %q", r.Domain)
+		}
+		if r.Target != "" && !validHostname(r.Target) {
+			return fmt.Errorf("invalid target %q", r.Target)
+		}
+		if r.Port < 0 || r.Port > 65535 || r.Priority < 0 || r.Priority > 65535 || r.Weight < 0 || r.Weight > 65535 {
+			return fmt.Errorf("SRV record port, priority and weight must be within 0-65535")
+		}
+	case DNSRecordTXT:
+		if !validHostname(r.Name) {
+			return fmt.Errorf("invalid TXT record name %q", r.Name)
+		}
+		if r.Value == "" {
+			return fmt.Errorf("a TXT record needs a value")
+		}
+	default:
+		return fmt.Errorf("unsupported DNS record type %q (must be one of %s, %s or %s)", r.Type, DNSRecordHost, DNSRecordSRV, DNSRecordTXT)
+	}
+	return nil
+}
+
+// sameKey reports whether r and other are of the same type and identify the same record
+func (r DNSRecord) sameKey(other DNSRecord) bool {
+	if r.Type != other.Type {
+		return false
+	}
+	switch r.Type {
+	case DNSRecordHost:
+		return net.ParseIP(r.IP).Equal(net.ParseIP(other.IP))
+	case DNSRecordSRV:
+		return r.Service == other.Service && r.Protocol == other.Protocol && r.Target == other.Target
+	default:
+		return r.Name == other.Name
+	}
+}
+
+func (r DNSRecord) String() string {
+	switch r.Type {
+	case DNSRecordHost:
+		return fmt.Sprintf("%s -> %s", strings.Join(r.Hostnames, ","), r.IP)
+	case DNSRecordSRV:
+		s := fmt.Sprintf("_%s._%s", r.Service, r.Protocol)
+		if r.Domain != "" {
+			s += "." + r.Domain
+		}
+		if r.Target != "" {
+			s += fmt.Sprintf(" -> %s:%d", r.Target, r.Port)
+		}
+		return s
+	default:
+		return fmt.Sprintf("%s=%q", r.Name, r.Value)
+	}
+}
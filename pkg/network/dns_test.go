@@ -0,0 +1,114 @@
+package network
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCreateNetworkRendersDNS(t *testing.T) {
+	stubSubnets(t)
+	b := NewFakeBackend()
+	c := NewClientWithBackend(b)
+	n := testNetwork()
+	n.DNS, n.Domain, n.DomainLocalOnly = true, "lab.local", true
+	n.DNSForwarders = []string{"1.1.1.1", "corp.example.com=10.0.0.53"}
+
+	if _, err := c.createNetwork(context.Background(), n); err != nil {
+		t.Fatalf("createNetwork() error = %v", err)
+	}
+	xml, _ := b.NetworkXML("test-net")
+	for _, want := range []string{
+		"<forwarder addr='1.1.1.1'/>",
+		"<forwarder domain='corp.example.com' addr='10.0.0.53'/>",
+		"<domain name='lab.local' localOnly='yes'/>",
+	} {
+		if !strings.Contains(xml, want) {
+			t.Errorf("network XML does not contain %s:\n%s", want, xml)
+		}
+	}
+	if strings.Contains(xml, "enable='no'") {
+		t.Errorf("DNS is disabled in network XML:\n%s", xml)
+	}
+
+	reasons, err := n.drift(xml)
+	if err != nil || len(reasons) > 0 {
+		t.Errorf("drift() = %v, %v, want none", reasons, err)
+	}
+}
+
+func TestDNSRecords(t *testing.T) {
+	stubSubnets(t)
+	b := NewFakeBackend()
+	c := NewClientWithBackend(b)
+	n := testNetwork()
+	n.DNS = true
+	if _, err := c.EnsureNetwork(context.Background(), n); err != nil {
+		t.Fatal(err)
+	}
+
+	records := []DNSRecord{
+		{Type: DNSRecordHost, IP: "192.168.123.10", Hostnames: []string{"node-1", "node-1.lab"}},
+		{Type: DNSRecordSRV, Service: "ldap", Protocol: "tcp", Target: "ldap.lab", Port: 389, Priority: 10},
+		{Type: DNSRecordTXT, Name: "lab", Value: "owner=qa"},
+	}
+	for _, r := range records {
+		if err := c.AddDNSRecord(context.Background(), "test-net", r); err != nil {
+			t.Fatalf("AddDNSRecord(%s) error = %v", r, err)
+		}
+	}
+	if err := c.AddDNSRecord(context.Background(), "test-net", DNSRecord{Type: DNSRecordHost, IP: "192.168.123.10", Hostnames: []string{"other"}}); err == nil {
+		t.Error("AddDNSRecord() of a host record with a taken address succeeded")
+	}
+
+	got, err := c.ListDNSRecords(context.Background(), "test-net")
+	if err != nil {
+		t.Fatalf("ListDNSRecords() error = %v", err)
+	}
+	if len(got) != 3 || strings.Join(got[0].Hostnames, ",") != "node-1,node-1.lab" || got[1].Port != 389 || got[2].Value != "owner=qa" {
+		t.Errorf("ListDNSRecords() = %+v", got)
+	}
+
+	if err := c.RemoveDNSRecord(context.Background(), "test-net", DNSRecord{Type: DNSRecordHost, IP: "192.168.123.10"}); err != nil {
+		t.Fatalf("RemoveDNSRecord() error = %v", err)
+	}
+	if got, _ := c.ListDNSRecords(context.Background(), "test-net"); len(got) != 2 || got[0].Type != DNSRecordSRV {
+		t.Errorf("ListDNSRecords() after remove = %+v", got)
+	}
+	if b.Calls["UndefineNetwork"] != 0 || b.Calls["DestroyNetwork"] != 0 {
+		t.Error("DNS records were not applied in place")
+	}
+}
+
+func TestDNSRecordsRequireDNS(t *testing.T) {
+	_, c := createTestNetwork(t)
+
+	err := c.AddDNSRecord(context.Background(), "test-net", DNSRecord{Type: DNSRecordTXT, Name: "lab", Value: "x"})
+	if err == nil || !strings.Contains(err.Error(), "DNS is disabled") {
+		t.Errorf("AddDNSRecord() error = %v, want DNS disabled error", err)
+	}
+}
+
+func TestDNSRecordValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		record  DNSRecord
+		wantErr bool
+	}{
+		{name: "AAAA host", record: DNSRecord{Type: DNSRecordHost, IP: "fd00::10", Hostnames: []string{"node-1"}}},
+		{name: "host without hostname", record: DNSRecord{Type: DNSRecordHost, IP: "10.0.0.1"}, wantErr: true},
+		{name: "invalid hostname", record: DNSRecord{Type: DNSRecordHost, IP: "10.0.0.1", Hostnames: []string{"-node"}}, wantErr: true},
+		{name: "SRV without target", record: DNSRecord{Type: DNSRecordSRV, Service: "ldap", Protocol: "udp"}},
+		{name: "SRV protocol", record: DNSRecord{Type: DNSRecordSRV, Service: "ldap", Protocol: "sctp"}, wantErr: true},
+		{name: "SRV port", record: DNSRecord{Type: DNSRecordSRV, Service: "ldap", Protocol: "tcp", Target: "ldap", Port: 70000}, wantErr: true},
+		{name: "TXT without value", record: DNSRecord{Type: DNSRecordTXT, Name: "lab"}, wantErr: true},
+		{name: "unknown type", record: DNSRecord{Type: "mx"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.record.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
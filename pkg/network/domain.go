@@ -1,80 +1,182 @@
 package network
 
 import (
+	"context"
 	"encoding/xml"
 	"fmt"
+	"strings"
 
 	"github.com/pkg/errors"
-	"libvirt.org/go/libvirt"
 
 	"github.com/day0ops/netctl/pkg/log"
 )
 
-func (n *Network) checkDomains(conn *libvirt.Connect) error {
-	type source struct {
-		// XMLName xml.Name `xml:"source"`
+// domainXML is the subset of the libvirt domain XML describing its network interfaces
+type domainXML struct {
+	// XMLName xml.Name `xml:"domain"`
+	Name       string               `xml:"name"`
+	UUID       string               `xml:"uuid"`
+	State      string               `xml:"-"` // reported by the backend, not part of the XML
+	Interfaces []domainInterfaceXML `xml:"devices>interface"`
+}
+
+type domainInterfaceXML struct {
+	// XMLName xml.Name `xml:"interface"`
+	Type   string `xml:"type,attr"`
+	Source struct {
 		Network string `xml:"network,attr"`
+		Bridge  string `xml:"bridge,attr"`
+	} `xml:"source"`
+	MAC struct {
+		Address string `xml:"address,attr"`
+	} `xml:"mac"`
+	Model struct {
+		Type string `xml:"type,attr"`
+	} `xml:"model"`
+}
+
+// DomainInterface is an interface of a domain attached to a network, either as a network interface
+// referencing it by name or as a bridge interface on its bridge
+type DomainInterface struct {
+	Domain string `json:"domain" yaml:"domain"`
+	UUID   string `json:"uuid" yaml:"uuid"`
+	State  string `json:"state" yaml:"state"`
+	MAC    string `json:"mac" yaml:"mac"`
+	Model  string `json:"model,omitempty" yaml:"model,omitempty"`
+	Type   string `json:"type" yaml:"type"` // network or bridge
+}
+
+// DomainsInUseError is returned when a network can't be changed because domains are attached to it
+type DomainsInUseError struct {
+	Network string
+	Domains []DomainInterface
+}
+
+func (e *DomainsInUseError) Error() string {
+	var names []string
+	for _, name := range domainNames(e.Domains) {
+		for _, d := range e.Domains {
+			if d.Domain == name {
+				names = append(names, fmt.Sprintf("'%s' (%s)", name, d.State))
+				break
+			}
+		}
 	}
-	type iface struct {
-		// XMLName xml.Name `xml:"interface"`
-		Source source `xml:"source"`
+	return fmt.Sprintf("network %s still in use by domains %s", e.Network, strings.Join(names, ", "))
+}
+
+// DomainsUsing returns every domain interface attached to the named network. See Client.DomainsUsing
+func DomainsUsing(connectionURI, name string) (ifaces []DomainInterface, err error) {
+	err = withClient(connectionURI, func(c *Client) error {
+		ifaces, err = c.DomainsUsing(context.Background(), name)
+		return err
+	})
+	return ifaces, err
+}
+
+// DomainsUsing returns every interface of the (also turned off) domains attached to the named network,
+// including bridge interfaces on the bridge of the network
+func (c *Client) DomainsUsing(ctx context.Context, name string) ([]DomainInterface, error) {
+	xmlString, err := c.backend.NetworkXML(name)
+	if err != nil {
+		return nil, err
 	}
-	type result struct {
-		// XMLName xml.Name `xml:"domain"`
-		Name       string  `xml:"name"`
-		Interfaces []iface `xml:"devices>interface"`
+	v, err := parseNetworkXML(xmlString)
+	if err != nil {
+		return nil, errors.Wrapf(err, "network '%s'", name)
+	}
+	if err := checkContext(ctx); err != nil {
+		return nil, err
 	}
 
-	// iterate over every (also turned off) domains, and check if it
-	// is using the private network. Do *not* delete the network if
-	// that is the case
-	log.Debug("trying to list all domains...")
-	doms, err := conn.ListAllDomains(0)
+	doms, err := listDomainXMLs(c.backend)
 	if err != nil {
-		return errors.Wrap(err, "list all domains")
+		return nil, err
 	}
-	log.Debugf("listed all domains: total of %n domains", len(doms))
+	return interfacesUsing(doms, name, v.Bridge.Name), nil
+}
 
-	// fail if there are 0 domains
-	if len(doms) == 0 {
-		log.Warn("list of domains is 0 length")
+// listDomainXMLs returns the parsed XML of every (also turned off) domain
+func listDomainXMLs(b Backend) ([]domainXML, error) {
+	log.Debug("trying to list all domains...")
+	doms, err := b.ListDomains()
+	if err != nil {
+		return nil, err
 	}
+	log.Debugf("listed all domains: total of %d domains", len(doms))
 
+	results := make([]domainXML, 0, len(doms))
 	for _, dom := range doms {
-		// get the name of the domain we iterate over
-		log.Debug("trying to get name of domain...")
-		name, err := dom.GetName()
-		if err != nil {
-			return errors.Wrap(err, "failed to get name of a domain")
-		}
-		log.Debugf("got domain name: %s", name)
-
 		// unfortunately, there is no better way to retrieve a list of all defined interfaces
 		// in domains than getting it from the defined XML of all domains
 		// NOTE: conn.ListAllInterfaces does not help in this case
-		log.Debugf("getting XML for domain %s...", name)
-		xmlString, err := dom.GetXMLDesc(libvirt.DOMAIN_XML_INACTIVE)
+		v := domainXML{}
+		err = xml.Unmarshal([]byte(dom.XML), &v)
 		if err != nil {
-			return errors.Wrapf(err, "failed to get XML of domain '%s'", name)
+			return nil, errors.Wrapf(err, "failed to unmarshal XML of domain '%s", dom.Name)
 		}
-		log.Debugf("got XML for domain %s", name)
+		v.State = dom.State
+		log.Tracef("unmarshaled XML for domain %s: %#v", dom.Name, v)
 
-		v := result{}
-		err = xml.Unmarshal([]byte(xmlString), &v)
-		if err != nil {
-			return errors.Wrapf(err, "failed to unmarshal XML of domain '%s", name)
-		}
-		log.Debugf("unmarshaled XML for domain %s: %#v", name, v)
+		results = append(results, v)
+	}
+
+	return results, nil
+}
 
-		// iterate over the found interfaces
-		for _, i := range v.Interfaces {
-			if i.Source.Network == n.Name {
-				log.Debugf("domain %s DOES use network %s, aborting...", name, n.Name)
-				return fmt.Errorf("network still in use at least by domain '%s'", name)
+// interfacesUsing returns the interfaces of doms attached to the network, by its name or by its bridge
+func interfacesUsing(doms []domainXML, network, bridge string) []DomainInterface {
+	ifaces := []DomainInterface{}
+	for _, dom := range doms {
+		for _, i := range dom.Interfaces {
+			viaNetwork := i.Source.Network != "" && i.Source.Network == network
+			viaBridge := i.Type == "bridge" && bridge != "" && i.Source.Bridge == bridge
+			if !viaNetwork && !viaBridge {
+				log.Debugf("domain %s interface %s does not use network %s", dom.Name, i.MAC.Address, network)
+				continue
+			}
+			ifaceType := "network"
+			if viaBridge {
+				ifaceType = "bridge"
 			}
-			log.Debugf("domain %s does not use network %s", name, n.Name)
+			ifaces = append(ifaces, DomainInterface{
+				Domain: dom.Name,
+				UUID:   dom.UUID,
+				State:  dom.State,
+				MAC:    i.MAC.Address,
+				Model:  i.Model.Type,
+				Type:   ifaceType,
+			})
 		}
 	}
+	return ifaces
+}
+
+// domainNames returns the names of the domains of ifaces, without duplicates
+func domainNames(ifaces []DomainInterface) []string {
+	names := []string{}
+	seen := map[string]bool{}
+	for _, i := range ifaces {
+		if !seen[i.Domain] {
+			seen[i.Domain] = true
+			names = append(names, i.Domain)
+		}
+	}
+	return names
+}
 
+// checkDomains returns a DomainsInUseError listing every domain using the named network, if any
+func (c *Client) checkDomains(ctx context.Context, name string) error {
+	// iterate over every (also turned off) domains, and check if it
+	// is using the private network. Do *not* delete the network if
+	// that is the case
+	ifaces, err := c.DomainsUsing(ctx, name)
+	if err != nil {
+		return err
+	}
+	if len(ifaces) > 0 {
+		log.Debugf("domains %v DO use network %s, aborting...", domainNames(ifaces), name)
+		return &DomainsInUseError{Network: name, Domains: ifaces}
+	}
 	return nil
 }
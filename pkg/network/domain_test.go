@@ -0,0 +1,42 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestDomainsUsing(t *testing.T) {
+	b, c := createTestNetwork(t)
+	b.AddDomain("vm-1", `<domain><name>vm-1</name><uuid>uuid-1</uuid><devices>
+  <interface type='network'><mac address='52:54:00:00:00:01'/><source network='test-net'/><model type='virtio'/></interface>
+  <interface type='network'><mac address='52:54:00:00:00:02'/><source network='default'/></interface>
+</devices></domain>`)
+	b.AddDomain("vm-2", `<domain><name>vm-2</name><uuid>uuid-2</uuid><devices>
+  <interface type='bridge'><mac address='52:54:00:00:00:03'/><source bridge='virbr-test'/><model type='e1000'/></interface>
+</devices></domain>`)
+	b.AddDomain("vm-3", `<domain><name>vm-3</name><devices>
+  <interface type='bridge'><mac address='52:54:00:00:00:04'/><source bridge='virbr0'/></interface>
+</devices></domain>`)
+
+	ifaces, err := c.DomainsUsing(context.Background(), "test-net")
+	if err != nil {
+		t.Fatalf("DomainsUsing() error = %v", err)
+	}
+	want := []DomainInterface{
+		{Domain: "vm-1", UUID: "uuid-1", State: DomainRunning, MAC: "52:54:00:00:00:01", Model: "virtio", Type: "network"},
+		{Domain: "vm-2", UUID: "uuid-2", State: DomainRunning, MAC: "52:54:00:00:00:03", Model: "e1000", Type: "bridge"},
+	}
+	if len(ifaces) != len(want) || ifaces[0] != want[0] || ifaces[1] != want[1] {
+		t.Errorf("DomainsUsing() = %+v, want %+v", ifaces, want)
+	}
+
+	_, err = c.DeleteNetwork(context.Background(), "test-net", DeleteOptions{})
+	var inUse *DomainsInUseError
+	if !errors.As(err, &inUse) || len(inUse.Domains) != 2 {
+		t.Fatalf("DeleteNetwork() error = %v, want both domains in use", err)
+	}
+	if got, want := err.Error(), "network test-net still in use by domains 'vm-1' (running), 'vm-2' (running)"; got != want {
+		t.Errorf("DeleteNetwork() error = %q, want %q", got, want)
+	}
+}
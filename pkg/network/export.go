@@ -0,0 +1,495 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/day0ops/netctl/pkg/ipam"
+	"github.com/day0ops/netctl/pkg/log"
+)
+
+// Formats a network is exported to
+const (
+	ExportFormatXML  = "xml"
+	ExportFormatYAML = "yaml"
+)
+
+// ErrSubnetCollision is returned when importing a network whose subnets are used on the hypervisor already
+var ErrSubnetCollision = errors.New("subnet of the imported network is in use")
+
+// Export returns the definition of the named network in the given format: its libvirt XML, which holds the netctl
+// metadata of the networks created by netctl, or a manifest declaring it (see Apply). The manifest leaves out
+// the DHCP hosts and DNS records of the network
+func (c *Client) Export(ctx context.Context, name, format string) ([]byte, error) {
+	xmlString, err := c.backend.PersistentNetworkXML(name)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case ExportFormatXML:
+		return []byte(strings.TrimSpace(xmlString) + "\n"), nil
+	case ExportFormatYAML:
+	default:
+		return nil, fmt.Errorf("unsupported export format %q (must be %s or %s)", format, ExportFormatXML, ExportFormatYAML)
+	}
+
+	n, v, err := c.declaration(name, xmlString)
+	if err != nil {
+		return nil, err
+	}
+
+	records := len(v.DNS.Hosts) + len(v.DNS.SRVs) + len(v.DNS.TXTs)
+	for _, ip := range v.IPs {
+		if ip.DHCP != nil {
+			records += len(ip.DHCP.Hosts)
+		}
+	}
+	if records > 0 {
+		log.Warnf("the %s form leaves out the %d DHCP hosts and DNS records of network %s, export it as %s to keep them", ExportFormatYAML, records, name, ExportFormatXML)
+	}
+	return yaml.Marshal(&Manifest{Networks: []Network{*n}})
+}
+
+// Declaration returns the declaration of the named network the way it would be created again, autostart included.
+// It leaves out the DHCP hosts and DNS records of the network
+func (c *Client) Declaration(ctx context.Context, name string) (*Network, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	xmlString, err := c.backend.PersistentNetworkXML(name)
+	if err != nil {
+		return nil, err
+	}
+	n, _, err := c.declaration(name, xmlString)
+	return n, err
+}
+
+// declaration returns the declaration of the named network described by xmlString, and its parsed XML
+func (c *Client) declaration(name, xmlString string) (*Network, *networkXML, error) {
+	state, err := c.backend.LookupNetwork(name)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed looking up network %s", name)
+	}
+	v, err := parseNetworkXML(xmlString)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "network '%s'", name)
+	}
+	n, err := networkFromXML(v)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "network '%s'", name)
+	}
+	n.NoAutostart = !state.Autostart
+	return n, v, nil
+}
+
+// networkFromXML returns the declaration of the network described by v, the way it would be created again
+func networkFromXML(v *networkXML) (*Network, error) {
+	n := &Network{
+		Name:            v.Name,
+		Bridge:          v.Bridge.Name,
+		ForwardMode:     v.Forward.Mode,
+		ForwardDev:      v.Forward.Dev,
+		NATPortStart:    v.Forward.NAT.Port.Start,
+		NATPortEnd:      v.Forward.NAT.Port.End,
+		DNS:             v.DNS.Enable != "no",
+		Domain:          v.Domain.Name,
+		DomainLocalOnly: v.Domain.LocalOnly == "yes",
+	}
+	for _, f := range v.DNS.Forwarders {
+		if f.Domain != "" {
+			f.Addr = f.Domain + "=" + f.Addr
+		}
+		n.DNSForwarders = append(n.DNSForwarders, f.Addr)
+	}
+	if m := v.metadata(); m != nil {
+		n.Labels = m.Labels
+		n.Template, n.TemplateVars = m.Template, m.TemplateVars
+	}
+
+	ip4, ip6 := v.ips()
+	var err error
+	if ip4 != nil {
+		n.Subnet = ip4.cidr()
+		if n.DHCPRange, err = customDHCPRange(ip4); err != nil {
+			return nil, err
+		}
+	}
+	if ip6 != nil {
+		n.SubnetV6 = ip6.cidr()
+		if ip6.DHCP == nil {
+			n.IPv6Mode = IPv6ModeRA
+		} else if n.DHCPRangeV6, err = customDHCPRange(ip6); err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+// customDHCPRange returns the DHCP range of the ip element, empty if there is none or it is the one a new network
+// gets by default
+func customDHCPRange(ip *networkIPXML) (string, error) {
+	if ip.DHCP == nil {
+		return "", nil
+	}
+	params, err := inspect(ip.cidr())
+	if err != nil {
+		return "", err
+	}
+	reserveVIP(params)
+	if params.ClientMin == ip.DHCP.Range.Start && params.ClientMax == ip.DHCP.Range.End {
+		return "", nil
+	}
+	return ip.DHCP.Range.Start + "-" + ip.DHCP.Range.End, nil
+}
+
+// ImportOptions tunes how Import defines a network
+type ImportOptions struct {
+	// RemapSubnet moves the network to the next free subnets when its own are in use, instead of refusing it
+	// with ErrSubnetCollision. The addresses within the subnets (gateway, DHCP ranges and hosts) keep their offsets
+	RemapSubnet bool
+
+	// Name renames the network, which keeps its exported name if empty
+	Name string
+
+	// Subnets move the network to the given subnets, at most one per IP family, instead of its own. Each needs the
+	// prefix length of the subnet it replaces
+	Subnets []string
+}
+
+// Import defines and starts the network exported to data, as XML or as a manifest declaring a single network
+// (see Export). Networks already defined with the same name are refused, and so are subnets used by the local
+// interfaces or the other networks, unless remapped with opts.RemapSubnet. It returns the addressing of the
+// imported network
+func (c *Client) Import(ctx context.Context, data []byte, opts ImportOptions) (*Result, error) {
+	var imp *importedNetwork
+	var err error
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("<")) {
+		imp, err = parseImportXML(data)
+	} else {
+		imp, err = parseImportManifest(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c.importNetwork(ctx, imp, opts)
+}
+
+// importNetwork defines and starts the imported network, see Import
+func (c *Client) importNetwork(ctx context.Context, imp *importedNetwork, opts ImportOptions) (*Result, error) {
+	if opts.Name != "" {
+		imp.renamed = opts.Name != imp.network.Name
+		imp.network.Name = opts.Name
+	}
+	if err := imp.network.Validate(); err != nil {
+		return nil, err
+	}
+	name := imp.network.Name
+	startSubnet, startSubnetV6, err := imp.startSubnets(opts.Subnets)
+	if err != nil {
+		return nil, errors.Wrapf(err, "network %s", name)
+	}
+	l := log.With(log.Fields{log.FieldNetwork: name})
+	if _, err := c.backend.LookupNetwork(name); err == nil {
+		return nil, fmt.Errorf("network %s already exists", name)
+	} else if !errors.Is(err, ErrNetworkNotFound) {
+		return nil, errors.Wrapf(err, "failed looking up network %s", name)
+	}
+	if bridge := imp.network.Bridge; bridge != "" {
+		inUse, err := c.bridgeInUse(bridge)
+		if err != nil {
+			return nil, err
+		}
+		// the bridge belongs to the original network, libvirt picks a free one for the copy
+		if imp.renamed || inUse {
+			l.Infof("bridge %s of network %s is in use or belongs to the original network, leaving the choice to libvirt", bridge, name)
+			imp.network.Bridge = ""
+		}
+	}
+
+	sources, err := c.subnetSources(imp.network)
+	if err != nil {
+		return nil, err
+	}
+	var skipped []SkippedSubnet
+	claim := func(cidr string, ipv6 bool) (*Parameters, error) {
+		if cidr == "" {
+			return nil, nil
+		}
+		if err := checkContext(ctx); err != nil {
+			return nil, err
+		}
+		tries := 1
+		if opts.RemapSubnet {
+			tries = imp.network.tries()
+		}
+		subnet, s, err := FindFreeSubnet(cidr, imp.network.step(ipv6), tries, sources...)
+		skipped = append(skipped, s...)
+		if err != nil && !opts.RemapSubnet && len(s) > 0 {
+			return nil, fmt.Errorf("%w: %s %s", ErrSubnetCollision, s[0].CIDR, s[0].Reason)
+		}
+		return subnet, err
+	}
+	subnet, err := claim(startSubnet, false)
+	if err != nil {
+		return nil, err
+	}
+	subnetV6, err := claim(startSubnetV6, true)
+	if err != nil {
+		return nil, err
+	}
+
+	networkXML, err := imp.render(subnet, subnetV6)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.reserveAllocation(name, subnet, subnetV6); err != nil {
+		if errors.Is(err, ipam.ErrConflict) {
+			return nil, fmt.Errorf("%w: %v", ErrSubnetCollision, err)
+		}
+		return nil, err
+	}
+
+	l.Tracef("importing network as XML:\n%s", networkXML)
+	if err := c.backend.DefineNetwork(networkXML); err != nil {
+		if err := c.releaseAllocation(name); err != nil {
+			l.Errorf("failed releasing IPAM allocation of network %s: %v", name, err)
+		}
+		return nil, fmt.Errorf("defining network %s from xml %s: %w", name, networkXML, err)
+	}
+	if err := setupNetwork(c.backend, name, !imp.network.NoAutostart); err != nil {
+		// leave nothing behind, the import can be tried again once the cause is fixed
+		if _, deleteErr := c.DeleteNetwork(ctx, name, DeleteOptions{Force: true}); deleteErr != nil {
+			l.Errorf("failed deleting network %s that could not be started: %v", name, deleteErr)
+		}
+		return nil, err
+	}
+	l.Infof("imported network %s (%s)", name, joinCIDRs(subnet, subnetV6))
+
+	r, err := networkResult(c.backend, name, OutcomeCreated)
+	if err != nil {
+		return nil, err
+	}
+	r.Skipped = skipped
+	return r, nil
+}
+
+// importedNetwork is a network read from an export
+type importedNetwork struct {
+	network *Network
+	xml     string // the exported XML, empty for a manifest
+	renamed bool   // whether the network is imported under another name
+}
+
+// parseImportXML reads a network exported as XML
+func parseImportXML(data []byte) (*importedNetwork, error) {
+	v, err := parseNetworkXML(string(data))
+	if err != nil {
+		return nil, err
+	}
+	if v.Name == "" {
+		return nil, fmt.Errorf("network XML has no name")
+	}
+	n, err := networkFromXML(v)
+	if err != nil {
+		return nil, errors.Wrapf(err, "network '%s'", v.Name)
+	}
+	// the XML is imported as is, the template it was rendered with may not exist here
+	n.Template, n.TemplateVars = "", nil
+	return &importedNetwork{network: n, xml: string(data)}, nil
+}
+
+// parseImportManifest reads a network exported as a manifest
+func parseImportManifest(data []byte) (*importedNetwork, error) {
+	m, err := ParseManifest(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if len(m.Networks) != 1 {
+		return nil, fmt.Errorf("expected a single network to import, got %d (use apply for manifests)", len(m.Networks))
+	}
+	return &importedNetwork{network: &m.Networks[0]}, nil
+}
+
+// startSubnets returns where the search for the subnets of the imported network starts: its own subnets, or the
+// given ones replacing them
+func (imp *importedNetwork) startSubnets(subnets []string) (string, string, error) {
+	start, startV6 := imp.network.Subnet, imp.network.SubnetV6
+	for _, cidr := range subnets {
+		ip, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid subnet %q (for e.g. it should be of the form 10.89.0.1/24 or fd00:89::/64)", cidr)
+		}
+		current := &start
+		if ip.To4() == nil {
+			current = &startV6
+		}
+		if *current == "" {
+			return "", "", fmt.Errorf("has no subnet of the family of %s to replace", cidr)
+		}
+		_, currentNet, _ := net.ParseCIDR(*current)
+		ones, _ := currentNet.Mask.Size()
+		if newOnes, _ := ipnet.Mask.Size(); newOnes != ones {
+			return "", "", fmt.Errorf("subnet %s has to be a /%d like %s", cidr, ones, *current)
+		}
+		*current = cidr
+	}
+	return start, startV6, nil
+}
+
+// render returns the XML of the imported network moved to the given subnets, either of which may be nil. The
+// exported XML gets a new UUID and bridge MAC from libvirt, as they may be used on the hypervisor already
+func (imp *importedNetwork) render(subnet, subnetV6 *Parameters) (string, error) {
+	n := imp.network
+	if imp.xml == "" {
+		n.DHCPRange = remapRange(n.DHCPRange, n.Subnet, subnet)
+		n.DHCPRangeV6 = remapRange(n.DHCPRangeV6, n.SubnetV6, subnetV6)
+		if subnet != nil {
+			reserveVIP(subnet)
+		}
+		if subnetV6 != nil {
+			reserveVIP(subnetV6)
+		}
+		return n.renderXML(subnet, subnetV6)
+	}
+
+	root := &xmlNode{}
+	if err := xml.Unmarshal([]byte(imp.xml), root); err != nil {
+		return "", fmt.Errorf("failed to unmarshal network XML: %w", err)
+	}
+	kept := root.Nodes[:0]
+	for _, node := range root.Nodes {
+		if node.XMLName.Local != "uuid" && node.XMLName.Local != "mac" {
+			kept = append(kept, node)
+		}
+	}
+	root.Nodes = kept
+	root.child("name").Content = n.Name
+	if n.Bridge == "" {
+		// libvirt names the bridge, see importNetwork
+		for _, bridge := range root.children("bridge") {
+			bridge.Attrs = slices.DeleteFunc(bridge.Attrs, func(a xml.Attr) bool { return a.Name.Local == "name" })
+		}
+	}
+
+	remapSubnet(root, n.Name, n.Subnet, subnet)
+	remapSubnet(root, n.Name, n.SubnetV6, subnetV6)
+
+	out, err := xml.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// bridgeInUse returns whether the bridge is an interface of the host or the bridge of a libvirt network
+func (c *Client) bridgeInUse(bridge string) (bool, error) {
+	if _, err := net.InterfaceByName(bridge); err == nil {
+		return true, nil
+	}
+	names, err := c.backend.ListNetworks()
+	if err != nil {
+		return false, errors.Wrap(err, "failed listing networks")
+	}
+	for _, name := range names {
+		xmlString, err := c.backend.NetworkXML(name)
+		if err != nil {
+			return false, errors.Wrapf(err, "failed reading network %s", name)
+		}
+		if v, err := parseNetworkXML(xmlString); err == nil && v.Bridge.Name == bridge {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// remapSubnet moves the addresses of the network XML root within the subnet cidr (gateway, DHCP ranges and hosts,
+// DNS hosts) to the same offsets in subnet, if set and of the same size
+func remapSubnet(root *xmlNode, name, cidr string, subnet *Parameters) {
+	if cidr == "" || subnet == nil {
+		return
+	}
+	_, from, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return
+	}
+	_, to, err := net.ParseCIDR(subnet.CIDR)
+	if err != nil || from.String() == to.String() {
+		return
+	}
+	fromOnes, _ := from.Mask.Size()
+	toOnes, _ := to.Mask.Size()
+	if fromOnes != toOnes {
+		return
+	}
+	remap := func(node *xmlNode, attrs ...string) {
+		for i, a := range node.Attrs {
+			for _, name := range attrs {
+				if a.Name.Local == name {
+					node.Attrs[i].Value = remapAddr(a.Value, from, to)
+				}
+			}
+		}
+	}
+	for _, ip := range root.children("ip") {
+		if addr := net.ParseIP(ip.attr("address")); addr == nil || !from.Contains(addr) {
+			continue
+		}
+		remap(ip, "address")
+		for _, dhcp := range ip.children("dhcp") {
+			for _, r := range dhcp.children("range") {
+				remap(r, "start", "end")
+			}
+			for _, h := range dhcp.children("host") {
+				remap(h, "ip")
+			}
+		}
+	}
+	for _, dns := range root.children("dns") {
+		for _, h := range dns.children("host") {
+			remap(h, "ip")
+		}
+	}
+	log.Infof("remapped network %s from subnet %s to %s", name, from, to)
+}
+
+// remapAddr moves addr from the subnet from to the same offset in the subnet to, of the same size. Addresses out
+// of from are returned as is
+func remapAddr(addr string, from, to *net.IPNet) string {
+	ip := net.ParseIP(addr)
+	if ip == nil || !from.Contains(ip) {
+		return addr
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	moved := make(net.IP, len(to.IP))
+	for i := range moved {
+		moved[i] = to.IP[i] | ip[i]&^from.Mask[i]
+	}
+	return moved.String()
+}
+
+// remapRange moves the start-end range dhcpRange from the subnet cidr to subnet, if set
+func remapRange(dhcpRange, cidr string, subnet *Parameters) string {
+	if dhcpRange == "" || subnet == nil {
+		return dhcpRange
+	}
+	_, from, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return dhcpRange
+	}
+	_, to, err := net.ParseCIDR(subnet.CIDR)
+	if err != nil {
+		return dhcpRange
+	}
+	start, end, _ := strings.Cut(dhcpRange, "-")
+	return remapAddr(start, from, to) + "-" + remapAddr(end, from, to)
+}
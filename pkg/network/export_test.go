@@ -0,0 +1,103 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// exportTestNetwork exports testNetwork, with a DHCP host, in the given format from a backend of its own
+func exportTestNetwork(t *testing.T, format string) []byte {
+	t.Helper()
+	_, c := createTestNetwork(t)
+	ctx := context.Background()
+	if err := c.AddHosts(ctx, "test-net", []Host{{MAC: "52:54:00:aa:00:01", IP: "192.168.123.10"}}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := c.Export(ctx, "test-net", format)
+	if err != nil {
+		t.Fatalf("Export(%s) error = %v", format, err)
+	}
+	// forget the subnets reserved by the exporting process, like another host would
+	stubSubnets(t)
+	return data
+}
+
+func TestExportImportXML(t *testing.T) {
+	data := exportTestNetwork(t, ExportFormatXML)
+	if !strings.Contains(string(data), "netctl") {
+		t.Errorf("exported XML has no netctl metadata:\n%s", data)
+	}
+
+	b := NewFakeBackend()
+	c := NewClientWithBackend(b)
+	r, err := c.Import(context.Background(), data, ImportOptions{})
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if r.Subnet == nil || r.Subnet.CIDR != "192.168.123.0/24" || r.Outcome != OutcomeCreated {
+		t.Errorf("Import() = %+v", r)
+	}
+	if state, _ := b.LookupNetwork("test-net"); !state.Active {
+		t.Error("imported network is not active")
+	}
+	if err := checkManaged(b, "test-net"); err != nil {
+		t.Errorf("imported network lost its metadata: %v", err)
+	}
+	if _, err := c.Import(context.Background(), data, ImportOptions{}); err == nil {
+		t.Error("Import() of an existing network succeeded")
+	}
+	if _, err := c.Import(context.Background(), data, ImportOptions{Name: "lab/2"}); err == nil || b.Calls["DefineNetwork"] != 1 {
+		t.Errorf("Import() under an invalid name error = %v, want refused before defining it", err)
+	}
+}
+
+func TestImportRemapsCollidingSubnet(t *testing.T) {
+	data := exportTestNetwork(t, ExportFormatXML)
+	b := NewFakeBackend()
+	c := NewClientWithBackend(b)
+	ctx := context.Background()
+	if _, err := c.EnsureNetwork(ctx, &Network{Name: "other", Bridge: "virbr-other", Subnet: "192.168.123.1/24"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Import(ctx, data, ImportOptions{}); !errors.Is(err, ErrSubnetCollision) {
+		t.Fatalf("Import() error = %v, want ErrSubnetCollision", err)
+	}
+	if _, err := b.LookupNetwork("test-net"); !errors.Is(err, ErrNetworkNotFound) {
+		t.Error("refused import left the network defined")
+	}
+
+	r, err := c.Import(ctx, data, ImportOptions{RemapSubnet: true})
+	if err != nil {
+		t.Fatalf("Import(remap) error = %v", err)
+	}
+	if r.Subnet == nil || r.Subnet.CIDR != "192.168.134.0/24" || r.Subnet.Gateway != "192.168.134.1" {
+		t.Errorf("Import(remap) = %+v, want moved to 192.168.134.0/24", r.Subnet)
+	}
+	hosts, err := c.ListHosts(ctx, "test-net")
+	if err != nil || len(hosts) != 1 || hosts[0].IP != "192.168.134.10" {
+		t.Errorf("hosts of the remapped network = %+v, %v", hosts, err)
+	}
+}
+
+func TestExportImportYAML(t *testing.T) {
+	data := exportTestNetwork(t, ExportFormatYAML)
+	m, err := ParseManifest(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatalf("exported manifest is invalid: %v\n%s", err, data)
+	}
+	if n := m.Networks[0]; n.Name != "test-net" || n.Bridge != "virbr-test" || n.Subnet != "192.168.123.1/24" || n.DHCPRange != "" {
+		t.Errorf("exported network = %+v", n)
+	}
+
+	c := NewClientWithBackend(NewFakeBackend())
+	r, err := c.Import(context.Background(), data, ImportOptions{})
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if r.Subnet == nil || r.Subnet.CIDR != "192.168.123.0/24" || r.Subnet.VIP != "192.168.123.254" {
+		t.Errorf("Import() = %+v", r.Subnet)
+	}
+}
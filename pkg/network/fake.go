@@ -0,0 +1,512 @@
+package network
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// FakeBackend is an in-memory Backend, useful to exercise network management without a hypervisor
+type FakeBackend struct {
+	mu       sync.Mutex
+	networks map[string]*fakeNetwork
+	domains  []DomainDesc
+
+	// OnCreate, if set, is called before a network is started. A returned error fails the start
+	OnCreate func(name string) error
+	// OnDefine, if set, is called before a network is defined. A returned error fails the definition
+	OnDefine func(xml string) error
+	// IgnoreShutdown makes the domains ignore shutdown requests, like guests without ACPI support
+	IgnoreShutdown bool
+	// Calls counts the calls made to each Backend method
+	Calls map[string]int
+
+	watchers []chan BackendEvent
+}
+
+type fakeNetwork struct {
+	xml       string // persistent XML
+	live      string // XML the network was started with, empty if inactive
+	active    bool
+	autostart bool
+	leases    []DHCPLease
+}
+
+// NewFakeBackend returns an empty in-memory backend
+func NewFakeBackend() *FakeBackend {
+	return &FakeBackend{
+		networks: map[string]*fakeNetwork{},
+		Calls:    map[string]int{},
+	}
+}
+
+// AddDomain registers a running domain described by its XML
+func (f *FakeBackend) AddDomain(name, xml string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.domains = append(f.domains, DomainDesc{Name: name, State: DomainRunning, XML: xml})
+	f.emit(BackendEvent{Type: EventDomainChanged, Domain: name})
+}
+
+// SetDomainState changes the state of the domain, for e.g. to pause it
+func (f *FakeBackend) SetDomainState(name, state string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	d, err := f.domain(name)
+	if err != nil {
+		return err
+	}
+	d.State = state
+	f.emit(BackendEvent{Type: EventDomainChanged, Domain: name})
+	return nil
+}
+
+// Watchers returns the number of subscriptions made with WatchEvents that are still running
+func (f *FakeBackend) Watchers() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.watchers)
+}
+
+// SetLeases replaces the DHCP leases of the network
+func (f *FakeBackend) SetLeases(name string, leases []DHCPLease) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, err := f.network(name)
+	if err != nil {
+		return err
+	}
+	n.leases = leases
+	return nil
+}
+
+func (f *FakeBackend) ListNetworks() ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Calls["ListNetworks"]++
+
+	names := make([]string, 0, len(f.networks))
+	for name := range f.networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (f *FakeBackend) LookupNetwork(name string) (NetworkState, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Calls["LookupNetwork"]++
+
+	n, err := f.network(name)
+	if err != nil {
+		return NetworkState{}, err
+	}
+	return NetworkState{Active: n.active, Autostart: n.autostart}, nil
+}
+
+func (f *FakeBackend) NetworkXML(name string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Calls["NetworkXML"]++
+
+	n, err := f.network(name)
+	if err != nil {
+		return "", err
+	}
+	if n.active {
+		return n.live, nil
+	}
+	return n.xml, nil
+}
+
+// PersistentNetworkXML returns the XML the network is defined with. Like libvirt, redefining an active network
+// leaves the XML it runs with unchanged until it is restarted
+func (f *FakeBackend) PersistentNetworkXML(name string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Calls["PersistentNetworkXML"]++
+
+	n, err := f.network(name)
+	if err != nil {
+		return "", err
+	}
+	return n.xml, nil
+}
+
+func (f *FakeBackend) DefineNetwork(xml string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Calls["DefineNetwork"]++
+
+	if f.OnDefine != nil {
+		if err := f.OnDefine(xml); err != nil {
+			return err
+		}
+	}
+	v, err := parseNetworkXML(xml)
+	if err != nil {
+		return err
+	}
+	if v.Name == "" {
+		return fmt.Errorf("network XML has no name")
+	}
+	for name, n := range f.networks {
+		if other, err := parseNetworkXML(n.xml); err == nil && name != v.Name && v.Bridge.Name != "" && other.Bridge.Name == v.Bridge.Name {
+			return fmt.Errorf("bridge name '%s' already in use by network %s", v.Bridge.Name, name)
+		}
+	}
+	if n, ok := f.networks[v.Name]; ok {
+		n.xml = xml
+	} else {
+		f.networks[v.Name] = &fakeNetwork{xml: xml}
+	}
+	f.emit(BackendEvent{Type: EventNetworkDefined, Network: v.Name})
+	return nil
+}
+
+func (f *FakeBackend) CreateNetwork(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Calls["CreateNetwork"]++
+
+	n, err := f.network(name)
+	if err != nil {
+		return err
+	}
+	if n.active {
+		return fmt.Errorf("network %s is already active", name)
+	}
+	if f.OnCreate != nil {
+		if err := f.OnCreate(name); err != nil {
+			return err
+		}
+	}
+	n.active, n.live = true, n.xml
+	f.emit(BackendEvent{Type: EventNetworkStarted, Network: name})
+	return nil
+}
+
+func (f *FakeBackend) DestroyNetwork(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Calls["DestroyNetwork"]++
+
+	n, err := f.network(name)
+	if err != nil {
+		return err
+	}
+	if !n.active {
+		return fmt.Errorf("network %s is not active", name)
+	}
+	n.active, n.live = false, ""
+	f.emit(BackendEvent{Type: EventNetworkStopped, Network: name})
+	return nil
+}
+
+func (f *FakeBackend) UndefineNetwork(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Calls["UndefineNetwork"]++
+
+	if _, err := f.network(name); err != nil {
+		return err
+	}
+	delete(f.networks, name)
+	f.emit(BackendEvent{Type: EventNetworkUndefined, Network: name})
+	return nil
+}
+
+// UpdateNetwork edits the network XML like libvirt does. Elements are matched by their identifying attributes
+// (mac or name for DHCP hosts, start and end for DHCP ranges, ip for DNS hosts, name for TXT records, service, protocol
+// and target for SRV records)
+func (f *FakeBackend) UpdateNetwork(name string, u NetworkUpdate) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Calls["UpdateNetwork"]++
+
+	n, err := f.network(name)
+	if err != nil {
+		return err
+	}
+	persistent, err := updateNetworkXML(name, n.xml, u)
+	if err != nil {
+		return err
+	}
+	if n.active {
+		if n.live, err = updateNetworkXML(name, n.live, u); err != nil {
+			return err
+		}
+	}
+	n.xml = persistent
+	return nil
+}
+
+// updateNetworkXML returns the network XML edited by u, see FakeBackend.UpdateNetwork
+func updateNetworkXML(name, xmlString string, u NetworkUpdate) (string, error) {
+	root := &xmlNode{}
+	if err := xml.Unmarshal([]byte(xmlString), root); err != nil {
+		return "", err
+	}
+	item := &xmlNode{}
+	if err := xml.Unmarshal([]byte(u.XML), item); err != nil {
+		return "", fmt.Errorf("invalid update XML: %w", err)
+	}
+
+	var parent *xmlNode
+	var keys []string
+	switch u.Section {
+	case SectionDHCPHost, SectionDHCPRange:
+		ips := root.children("ip")
+		index := u.ParentIndex
+		if index < 0 {
+			index = 0
+		}
+		if index >= len(ips) {
+			return "", fmt.Errorf("network %s has no ip element #%d", name, index)
+		}
+		parent, keys = ips[index].child("dhcp"), []string{"mac", "name"}
+		if u.Section == SectionDHCPRange {
+			keys = []string{"start", "end"}
+		} else if item.attr("mac") == "" {
+			keys = []string{"name"}
+		}
+	case SectionDNSHost:
+		parent, keys = root.child("dns"), []string{"ip"}
+	case SectionDNSTXT:
+		parent, keys = root.child("dns"), []string{"name"}
+	case SectionDNSSRV:
+		parent, keys = root.child("dns"), []string{"service", "protocol", "target"}
+	}
+
+	match := -1
+	for i, node := range parent.Nodes {
+		if node.XMLName.Local != item.XMLName.Local {
+			continue
+		}
+		equal := true
+		for _, key := range keys {
+			equal = equal && node.attr(key) == item.attr(key)
+		}
+		if equal {
+			match = i
+			break
+		}
+	}
+	switch {
+	case u.Command == UpdateAdd && match >= 0:
+		return "", fmt.Errorf("there is already a %s element matching %s in network %s", item.XMLName.Local, u.XML, name)
+	case u.Command == UpdateAdd:
+		parent.Nodes = append(parent.Nodes, *item)
+	case match < 0:
+		return "", fmt.Errorf("couldn't locate a matching %s element in network %s", item.XMLName.Local, name)
+	case u.Command == UpdateDelete:
+		parent.Nodes = append(parent.Nodes[:match], parent.Nodes[match+1:]...)
+	default:
+		parent.Nodes[match] = *item
+	}
+
+	out, err := xml.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+func (f *FakeBackend) SetNetworkAutostart(name string, autostart bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Calls["SetNetworkAutostart"]++
+
+	n, err := f.network(name)
+	if err != nil {
+		return err
+	}
+	n.autostart = autostart
+	return nil
+}
+
+func (f *FakeBackend) DHCPLeases(name string) ([]DHCPLease, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Calls["DHCPLeases"]++
+
+	n, err := f.network(name)
+	if err != nil {
+		return nil, err
+	}
+	if !n.active {
+		return nil, fmt.Errorf("network %s is not active", name)
+	}
+	return append([]DHCPLease(nil), n.leases...), nil
+}
+
+func (f *FakeBackend) ListDomains() ([]DomainDesc, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Calls["ListDomains"]++
+
+	return append([]DomainDesc(nil), f.domains...), nil
+}
+
+func (f *FakeBackend) DomainState(name string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Calls["DomainState"]++
+
+	d, err := f.domain(name)
+	if err != nil {
+		return "", err
+	}
+	return d.State, nil
+}
+
+func (f *FakeBackend) ShutdownDomain(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Calls["ShutdownDomain"]++
+
+	d, err := f.domain(name)
+	if err != nil {
+		return err
+	}
+	if d.State != DomainRunning && d.State != DomainBlocked {
+		return fmt.Errorf("domain %s is not running", name)
+	}
+	if !f.IgnoreShutdown {
+		d.State = DomainShutoff
+	}
+	return nil
+}
+
+func (f *FakeBackend) DestroyDomain(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Calls["DestroyDomain"]++
+
+	d, err := f.domain(name)
+	if err != nil {
+		return err
+	}
+	if d.State == DomainShutoff {
+		return fmt.Errorf("domain %s is not running", name)
+	}
+	d.State = DomainShutoff
+	return nil
+}
+
+// DetachInterface removes the interface with the MAC address of ifaceXML
+func (f *FakeBackend) DetachInterface(name, ifaceXML string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Calls["DetachInterface"]++
+
+	d, err := f.domain(name)
+	if err != nil {
+		return err
+	}
+	iface := &xmlNode{}
+	if err := xml.Unmarshal([]byte(ifaceXML), iface); err != nil {
+		return fmt.Errorf("invalid interface XML: %w", err)
+	}
+	root := &xmlNode{}
+	if err := xml.Unmarshal([]byte(d.XML), root); err != nil {
+		return err
+	}
+	mac := iface.child("mac").attr("address")
+	devices := root.child("devices")
+	for i, node := range devices.Nodes {
+		if node.XMLName.Local == "interface" && node.child("mac").attr("address") == mac {
+			devices.Nodes = append(devices.Nodes[:i], devices.Nodes[i+1:]...)
+			out, err := xml.Marshal(root)
+			if err != nil {
+				return err
+			}
+			d.XML = string(out)
+			f.emit(BackendEvent{Type: EventDomainChanged, Domain: name})
+			return nil
+		}
+	}
+	return fmt.Errorf("domain %s has no interface with MAC %s", name, mac)
+}
+
+// WatchEvents sends the changes made through the backend, up to 100 of them pending delivery. Changes beyond are dropped
+func (f *FakeBackend) WatchEvents(ctx context.Context) (<-chan BackendEvent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Calls["WatchEvents"]++
+
+	pending := make(chan BackendEvent, 100)
+	f.watchers = append(f.watchers, pending)
+	events := make(chan BackendEvent)
+	go func() {
+		defer close(events)
+		defer f.unwatch(pending)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e := <-pending:
+				select {
+				case events <- e:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return events, nil
+}
+
+func (f *FakeBackend) unwatch(pending chan BackendEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, w := range f.watchers {
+		if w == pending {
+			f.watchers = append(f.watchers[:i], f.watchers[i+1:]...)
+			return
+		}
+	}
+}
+
+// emit sends e to the watchers. The lock has to be held
+func (f *FakeBackend) emit(e BackendEvent) {
+	for _, w := range f.watchers {
+		select {
+		case w <- e:
+		default:
+		}
+	}
+}
+
+// FakeURI is the connection URI reported by FakeBackend
+const FakeURI = "test:///default"
+
+func (f *FakeBackend) URI() (string, error) {
+	return FakeURI, nil
+}
+
+func (f *FakeBackend) Close() error {
+	return nil
+}
+
+// domain returns the named domain. The lock has to be held
+func (f *FakeBackend) domain(name string) (*DomainDesc, error) {
+	for i := range f.domains {
+		if f.domains[i].Name == name {
+			return &f.domains[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrDomainNotFound, name)
+}
+
+// network returns the named network. The lock has to be held
+func (f *FakeBackend) network(name string) (*fakeNetwork, error) {
+	n, ok := f.networks[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNetworkNotFound, name)
+	}
+	return n, nil
+}
@@ -0,0 +1,278 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/day0ops/netctl/pkg/log"
+)
+
+// Host is a DHCP static host reservation, handing out a fixed address to the interface with the given MAC
+type Host struct {
+	MAC  string `json:"mac" yaml:"mac" xml:"mac,attr,omitempty"`
+	Name string `json:"name,omitempty" yaml:"name,omitempty" xml:"name,attr,omitempty"`
+	IP   string `json:"ip" yaml:"ip" xml:"ip,attr,omitempty"`
+}
+
+// dhcpSubnet is the IPv4 subnet of a network static hosts are reserved in
+type dhcpSubnet struct {
+	index  int // of the ip element in the network XML
+	params *Parameters
+	vip    string
+	hosts  []Host
+}
+
+// ListHosts returns the DHCP static hosts of the network
+func (c *Client) ListHosts(ctx context.Context, network string) ([]Host, error) {
+	s, err := c.dhcpSubnet(network)
+	if err != nil {
+		return nil, err
+	}
+	if s.hosts == nil {
+		return []Host{}, nil
+	}
+	return s.hosts, nil
+}
+
+// AddHosts reserves the addresses of the hosts in the DHCP server of the network, both in its persistent
+// definition and, if it is running, live. Every host is validated before the network is changed: its address
+// has to be a client address of the IPv4 subnet other than the gateway and the reserved VIP, and neither its MAC,
+// name nor address can be reserved already
+func (c *Client) AddHosts(ctx context.Context, network string, hosts []Host) error {
+	s, err := c.dhcpSubnet(network)
+	if err != nil {
+		return err
+	}
+
+	taken := append([]Host(nil), s.hosts...)
+	for i := range hosts {
+		h := &hosts[i]
+		if err := s.validate(h); err != nil {
+			return errors.Wrapf(err, "host %s", h)
+		}
+		for _, other := range taken {
+			if conflict := h.conflict(other); conflict != "" {
+				return fmt.Errorf("host %s: %s is already reserved by host %s", h, conflict, other)
+			}
+		}
+		taken = append(taken, *h)
+	}
+
+	for i, h := range hosts {
+		if err := checkContext(ctx); err != nil {
+			return err
+		}
+		if err := c.updateHost(network, UpdateAdd, s.index, h); err != nil {
+			return errors.Wrapf(err, "adding host %s (%d of %d hosts added)", h, i, len(hosts))
+		}
+		log.Infof("reserved %s for host %s in network %s", h.IP, h.MAC, network)
+	}
+	return nil
+}
+
+// RemoveHosts removes DHCP static hosts from the network. Each given host selects the single reservation
+// matching all of its non-empty fields
+func (c *Client) RemoveHosts(ctx context.Context, network string, hosts []Host) error {
+	s, err := c.dhcpSubnet(network)
+	if err != nil {
+		return err
+	}
+
+	existing := make([]Host, 0, len(hosts))
+	for _, selector := range hosts {
+		selector.MAC = strings.ToLower(selector.MAC)
+		if selector == (Host{}) {
+			return fmt.Errorf("a host to remove needs a MAC, name or IP address")
+		}
+		var matches []Host
+		for _, h := range s.hosts {
+			if selector.matches(h) {
+				matches = append(matches, h)
+			}
+		}
+		switch len(matches) {
+		case 0:
+			return fmt.Errorf("no host %s is reserved in network %s", selector, network)
+		case 1:
+			existing = append(existing, matches[0])
+		default:
+			return fmt.Errorf("host %s matches %d reservations of network %s", selector, len(matches), network)
+		}
+	}
+
+	for i, h := range existing {
+		if err := checkContext(ctx); err != nil {
+			return err
+		}
+		if err := c.updateHost(network, UpdateDelete, s.index, h); err != nil {
+			return errors.Wrapf(err, "removing host %s (%d of %d hosts removed)", h, i, len(existing))
+		}
+		log.Infof("released %s of host %s in network %s", h.IP, h.MAC, network)
+	}
+	return nil
+}
+
+func (c *Client) updateHost(network string, cmd UpdateCommand, index int, h Host) error {
+	hostXML, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"host"`
+		Host
+	}{Host: h})
+	if err != nil {
+		return err
+	}
+	return c.backend.UpdateNetwork(network, NetworkUpdate{Command: cmd, Section: SectionDHCPHost, ParentIndex: index, XML: string(hostXML)})
+}
+
+// dhcpSubnet returns the IPv4 DHCP subnet of the network
+func (c *Client) dhcpSubnet(network string) (*dhcpSubnet, error) {
+	xmlString, err := c.backend.NetworkXML(network)
+	if err != nil {
+		return nil, err
+	}
+	v, err := parseNetworkXML(xmlString)
+	if err != nil {
+		return nil, errors.Wrapf(err, "network '%s'", network)
+	}
+	for i, ip := range v.IPs {
+		if ip.DHCP == nil || net.ParseIP(ip.Address).To4() == nil {
+			continue
+		}
+		params, err := parametersFromXML(ip)
+		if err != nil {
+			return nil, errors.Wrapf(err, "network '%s'", network)
+		}
+		hosts := ip.DHCP.Hosts
+		for j := range hosts {
+			hosts[j].MAC = strings.ToLower(hosts[j].MAC)
+		}
+		return &dhcpSubnet{index: i, params: params, vip: reservedVIP(params), hosts: hosts}, nil
+	}
+	return nil, fmt.Errorf("network %s has no IPv4 subnet served by DHCP", network)
+}
+
+// validate normalizes the host and checks its address can be reserved in the subnet
+func (s *dhcpSubnet) validate(h *Host) error {
+	mac, err := net.ParseMAC(h.MAC)
+	if err != nil || len(mac) != 6 {
+		return fmt.Errorf("invalid MAC address %q", h.MAC)
+	}
+	h.MAC = mac.String()
+	ip := net.ParseIP(h.IP).To4()
+	if ip == nil {
+		return fmt.Errorf("invalid IPv4 address %q", h.IP)
+	}
+	h.IP = ip.String()
+
+	_, subnet, _ := net.ParseCIDR(s.params.CIDR)
+	switch h.IP {
+	case s.params.Gateway:
+		return fmt.Errorf("%s is the gateway of the network", h.IP)
+	case s.vip:
+		return fmt.Errorf("%s is reserved for the loadbalancer VIP", h.IP)
+	case s.params.IP, s.params.Broadcast:
+		return fmt.Errorf("%s is not a client address of subnet %s", h.IP, s.params.CIDR)
+	}
+	if !subnet.Contains(ip) {
+		return fmt.Errorf("%s is not in subnet %s", h.IP, s.params.CIDR)
+	}
+	if inRange(ip, s.params.ClientMin, s.params.ClientMax) {
+		// dnsmasq never leases a reserved address to another client, but one may still hold it until its lease expires
+		log.Warnf("%s is in the DHCP range %s-%s, make sure it is not leased to another client", h.IP, s.params.ClientMin, s.params.ClientMax)
+	}
+	return nil
+}
+
+// inRange reports whether ip is within [start, end]
+func inRange(ip net.IP, start, end string) bool {
+	first, last := net.ParseIP(start).To4(), net.ParseIP(end).To4()
+	return first != nil && last != nil && bytes.Compare(ip, first) >= 0 && bytes.Compare(ip, last) <= 0
+}
+
+// conflict returns what h has in common with other, empty if nothing
+func (h Host) conflict(other Host) string {
+	switch {
+	case h.MAC == other.MAC:
+		return "MAC " + h.MAC
+	case h.IP == other.IP:
+		return "address " + h.IP
+	case h.Name != "" && h.Name == other.Name:
+		return "name " + h.Name
+	}
+	return ""
+}
+
+// matches reports whether every non-empty field of h is equal in other
+func (h Host) matches(other Host) bool {
+	return (h.MAC == "" || h.MAC == other.MAC) && (h.Name == "" || h.Name == other.Name) && (h.IP == "" || h.IP == other.IP)
+}
+
+func (h Host) String() string {
+	var fields []string
+	for _, f := range []string{h.MAC, h.Name, h.IP} {
+		if f != "" {
+			fields = append(fields, f)
+		}
+	}
+	return strings.Join(fields, "/")
+}
+
+// LoadHosts reads hosts from a CSV file (mac,name,ip columns, with an optional header) if path ends with .csv,
+// or from a YAML or JSON list otherwise. "-" reads YAML from stdin
+func LoadHosts(path string) ([]Host, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading hosts: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var hosts []Host
+	var err error
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		hosts, err = ParseHostsCSV(r)
+	} else {
+		err = yaml.NewDecoder(r).Decode(&hosts)
+		if err == io.EOF {
+			err = nil
+		}
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "hosts file %s", path)
+	}
+	return hosts, nil
+}
+
+// ParseHostsCSV reads hosts from CSV records of mac,name,ip. A first record starting with "mac" is a header
+func ParseHostsCSV(r io.Reader) ([]Host, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 3
+	cr.TrimLeadingSpace = true
+	cr.Comment = '#'
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing hosts CSV: %w", err)
+	}
+	if len(records) > 0 && strings.EqualFold(records[0][0], "mac") {
+		records = records[1:]
+	}
+
+	hosts := make([]Host, 0, len(records))
+	for _, r := range records {
+		hosts = append(hosts, Host{MAC: r[0], Name: r[1], IP: r[2]})
+	}
+	return hosts, nil
+}
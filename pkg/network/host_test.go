@@ -0,0 +1,104 @@
+package network
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// createTestNetwork creates testNetwork (192.168.123.0/24, DHCP range .2-.253 and VIP .254)
+func createTestNetwork(t *testing.T) (*FakeBackend, *Client) {
+	t.Helper()
+	stubSubnets(t)
+	b := NewFakeBackend()
+	c := NewClientWithBackend(b)
+	if _, err := c.EnsureNetwork(context.Background(), testNetwork()); err != nil {
+		t.Fatal(err)
+	}
+	return b, c
+}
+
+func TestAddAndRemoveHosts(t *testing.T) {
+	b, c := createTestNetwork(t)
+	hosts := []Host{
+		{MAC: "52:54:00:AA:00:01", Name: "node-1", IP: "192.168.123.10"},
+		{MAC: "52:54:00:aa:00:02", Name: "node-2", IP: "192.168.123.11"},
+	}
+
+	if err := c.AddHosts(context.Background(), "test-net", hosts); err != nil {
+		t.Fatalf("AddHosts() error = %v", err)
+	}
+	got, err := c.ListHosts(context.Background(), "test-net")
+	if err != nil {
+		t.Fatalf("ListHosts() error = %v", err)
+	}
+	if len(got) != 2 || got[0] != (Host{MAC: "52:54:00:aa:00:01", Name: "node-1", IP: "192.168.123.10"}) {
+		t.Errorf("ListHosts() = %+v", got)
+	}
+	// the network is still recognized as created by netctl
+	if err := checkManaged(b, "test-net"); err != nil {
+		t.Errorf("checkManaged() after update error = %v", err)
+	}
+
+	if err := c.RemoveHosts(context.Background(), "test-net", []Host{{Name: "node-1"}}); err != nil {
+		t.Fatalf("RemoveHosts() error = %v", err)
+	}
+	got, _ = c.ListHosts(context.Background(), "test-net")
+	if len(got) != 1 || got[0].Name != "node-2" {
+		t.Errorf("ListHosts() after remove = %+v", got)
+	}
+	if err := c.RemoveHosts(context.Background(), "test-net", []Host{{Name: "node-1"}}); err == nil {
+		t.Error("RemoveHosts() of a missing host succeeded")
+	}
+}
+
+func TestAddHostsValidation(t *testing.T) {
+	_, c := createTestNetwork(t)
+	if err := c.AddHosts(context.Background(), "test-net", []Host{{MAC: "52:54:00:aa:00:01", Name: "node-1", IP: "192.168.123.10"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		hosts   []Host
+		wantErr string
+	}{
+		{name: "invalid MAC", hosts: []Host{{MAC: "52:54:00", IP: "192.168.123.20"}}, wantErr: "invalid MAC"},
+		{name: "invalid IP", hosts: []Host{{MAC: "52:54:00:aa:00:09", IP: "fd00::20"}}, wantErr: "invalid IPv4"},
+		{name: "outside subnet", hosts: []Host{{MAC: "52:54:00:aa:00:09", IP: "192.168.124.20"}}, wantErr: "not in subnet"},
+		{name: "gateway", hosts: []Host{{MAC: "52:54:00:aa:00:09", IP: "192.168.123.1"}}, wantErr: "gateway"},
+		{name: "VIP", hosts: []Host{{MAC: "52:54:00:aa:00:09", IP: "192.168.123.254"}}, wantErr: "VIP"},
+		{name: "broadcast", hosts: []Host{{MAC: "52:54:00:aa:00:09", IP: "192.168.123.255"}}, wantErr: "not a client address"},
+		{name: "taken MAC", hosts: []Host{{MAC: "52:54:00:AA:00:01", IP: "192.168.123.20"}}, wantErr: "MAC 52:54:00:aa:00:01 is already reserved"},
+		{name: "taken IP", hosts: []Host{{MAC: "52:54:00:aa:00:09", IP: "192.168.123.10"}}, wantErr: "address 192.168.123.10 is already reserved"},
+		{name: "duplicate in batch", hosts: []Host{
+			{MAC: "52:54:00:aa:00:08", Name: "node-8", IP: "192.168.123.20"},
+			{MAC: "52:54:00:aa:00:09", Name: "node-8", IP: "192.168.123.21"},
+		}, wantErr: "name node-8 is already reserved"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := c.AddHosts(context.Background(), "test-net", tt.hosts)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("AddHosts() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+	// nothing of a refused batch is added
+	if got, _ := c.ListHosts(context.Background(), "test-net"); len(got) != 1 {
+		t.Errorf("ListHosts() = %+v, want only node-1", got)
+	}
+}
+
+func TestParseHostsCSV(t *testing.T) {
+	hosts, err := ParseHostsCSV(strings.NewReader("mac,name,ip\n# workers\n52:54:00:aa:00:01, node-1, 192.168.123.10\n52:54:00:aa:00:02,,192.168.123.11\n"))
+	if err != nil {
+		t.Fatalf("ParseHostsCSV() error = %v", err)
+	}
+	if len(hosts) != 2 || hosts[0].Name != "node-1" || hosts[1] != (Host{MAC: "52:54:00:aa:00:02", IP: "192.168.123.11"}) {
+		t.Errorf("ParseHostsCSV() = %+v", hosts)
+	}
+	if _, err := ParseHostsCSV(strings.NewReader("52:54:00:aa:00:01,192.168.123.10\n")); err == nil {
+		t.Error("ParseHostsCSV() of a record without name column succeeded")
+	}
+}
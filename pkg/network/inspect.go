@@ -0,0 +1,138 @@
+package network
+
+import (
+	"context"
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// Details is the full model of a libvirt network as reported by Inspect
+type Details struct {
+	Name         string            `json:"name" yaml:"name"`
+	UUID         string            `json:"uuid" yaml:"uuid"`
+	Bridge       string            `json:"bridge" yaml:"bridge"`
+	ForwardMode  string            `json:"forwardMode" yaml:"forwardMode"`
+	ForwardDev   string            `json:"forwardDev,omitempty" yaml:"forwardDev,omitempty"`
+	Active       bool              `json:"active" yaml:"active"`
+	Autostart    bool              `json:"autostart" yaml:"autostart"`
+	Parameters   *Parameters       `json:"parameters,omitempty" yaml:"parameters,omitempty"`
+	VIP          string            `json:"vip,omitempty" yaml:"vip,omitempty"` // address reserved for the multi-control-plane loadbalancer
+	ParametersV6 *Parameters       `json:"parametersV6,omitempty" yaml:"parametersV6,omitempty"`
+	VIPv6        string            `json:"vipV6,omitempty" yaml:"vipV6,omitempty"`
+	IPv6Mode     string            `json:"ipv6Mode,omitempty" yaml:"ipv6Mode,omitempty"`
+	DNS          bool              `json:"dns" yaml:"dns"`
+	Domain       string            `json:"domain,omitempty" yaml:"domain,omitempty"`
+	Domains      []DomainInterface `json:"domains" yaml:"domains"`
+	Metadata     *Metadata         `json:"metadata,omitempty" yaml:"metadata,omitempty"` // set if the network was created by netctl
+}
+
+// Inspect returns the parsed model of the named network. See Client.Inspect
+func Inspect(connectionURI, name string) (d *Details, err error) {
+	err = withClient(connectionURI, func(c *Client) error {
+		d, err = c.Inspect(context.Background(), name)
+		return err
+	})
+	return d, err
+}
+
+// Inspect returns the parsed model of the named network, reverse-engineered from its live libvirt XML
+func (c *Client) Inspect(ctx context.Context, name string) (*Details, error) {
+	xmlString, err := c.backend.NetworkXML(name)
+	if err != nil {
+		return nil, err
+	}
+	v, err := parseNetworkXML(xmlString)
+	if err != nil {
+		return nil, errors.Wrapf(err, "network '%s'", name)
+	}
+
+	info, err := networkInfo(c.backend, name)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Details{
+		Name:        info.Name,
+		UUID:        info.UUID,
+		Bridge:      info.Bridge,
+		ForwardMode: info.ForwardMode,
+		ForwardDev:  v.Forward.Dev,
+		Active:      info.Active,
+		Autostart:   info.Autostart,
+		Metadata:    info.Metadata,
+		DNS:         v.DNS.Enable != "no",
+		Domain:      v.Domain.Name,
+	}
+
+	for _, ip := range v.IPs {
+		params, err := parametersFromXML(ip)
+		if err != nil {
+			return nil, errors.Wrapf(err, "network '%s'", name)
+		}
+		if params.IfaceName == "" {
+			// the bridge is not up, so fall back to what libvirt knows about it
+			params.IfaceName = v.Bridge.Name
+			params.IfaceMAC = v.MAC.Address
+			if params.IsIPv6 {
+				params.IfaceIPv6 = ip.Address
+			} else {
+				params.IfaceIPv4 = ip.Address
+			}
+		}
+
+		vip := ""
+		if ip.DHCP != nil {
+			vip = reservedVIP(params)
+		}
+		if params.IsIPv6 && d.ParametersV6 == nil {
+			d.ParametersV6, d.VIPv6 = params, vip
+			d.IPv6Mode = IPv6ModeRA
+			if ip.DHCP != nil {
+				d.IPv6Mode = IPv6ModeDHCP
+			}
+		} else if !params.IsIPv6 && d.Parameters == nil {
+			d.Parameters, d.VIP = params, vip
+		}
+	}
+
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	doms, err := listDomainXMLs(c.backend)
+	if err != nil {
+		return nil, err
+	}
+	d.Domains = interfacesUsing(doms, name, v.Bridge.Name)
+
+	return d, nil
+}
+
+// parametersFromXML rebuilds the network parameters from an ip element of the network XML
+func parametersFromXML(ip networkIPXML) (*Parameters, error) {
+	params, err := inspect(ip.cidr())
+	if err != nil {
+		return nil, err
+	}
+	// the gateway is the address of the bridge, not necessarily the first network address
+	params.Gateway = ip.Address
+	if ip.DHCP != nil {
+		params.ClientMin = ip.DHCP.Range.Start
+		params.ClientMax = ip.DHCP.Range.End
+	}
+	return params, nil
+}
+
+// reservedVIP returns the address createNetwork carves off the end of the DHCP range for the
+// multi-control-plane loadbalancer, or an empty string if the range is not the default one it reserves it from
+func reservedVIP(params *Parameters) string {
+	defaults, err := inspect(params.CIDR)
+	if err != nil {
+		return ""
+	}
+	reserveVIP(defaults)
+	if params.ClientMin != defaults.ClientMin || params.ClientMax != defaults.ClientMax {
+		return ""
+	}
+	return ipAdd(net.ParseIP(params.ClientMax), 1).String()
+}
@@ -0,0 +1,240 @@
+package network
+
+import (
+	"context"
+	"net"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/day0ops/netctl/pkg/ipam"
+	"github.com/day0ops/netctl/pkg/log"
+)
+
+// IPAMChange is a repair made (or planned) by ReconcileIPAM
+type IPAMChange struct {
+	Network string   `json:"network" yaml:"network"`
+	Action  string   `json:"action" yaml:"action"` // released, updated or reserved
+	CIDRs   []string `json:"cidrs" yaml:"cidrs"`
+}
+
+// WithIPAM makes the client record the subnets of the networks it creates in store, and avoid the
+// subnets recorded there for other networks. It returns the client
+func (c *Client) WithIPAM(store *ipam.Store) *Client {
+	c.ipam = store
+	return c
+}
+
+type ipamSource struct {
+	store   *ipam.Store
+	uri     string
+	network string
+}
+
+// NewIPAMSource returns a source reporting the subnets allocated in store to networks other than the named one
+func NewIPAMSource(store *ipam.Store, uri, network string) ConflictSource {
+	return &ipamSource{store: store, uri: uri, network: network}
+}
+
+func (s *ipamSource) Name() string {
+	return "IPAM database " + s.store.Path()
+}
+
+func (s *ipamSource) UsedSubnets() ([]UsedSubnet, error) {
+	allocs, err := s.store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var used []UsedSubnet
+	for _, a := range allocs {
+		if a.URI == s.uri && a.Network == s.network {
+			continue
+		}
+		for _, cidr := range a.CIDRs {
+			_, subnet, err := net.ParseCIDR(cidr)
+			if err != nil {
+				log.Debugf("failed parsing subnet %q allocated to network %s: %v", cidr, a.Network, err)
+				continue
+			}
+			used = append(used, UsedSubnet{Subnet: subnet, Owner: "IPAM allocation of network " + a.Network})
+		}
+	}
+	return used, nil
+}
+
+// reserveAllocation records the subnets chosen for the network in the IPAM database, if any
+func (c *Client) reserveAllocation(name string, subnets ...*Parameters) error {
+	if c.ipam == nil {
+		return nil
+	}
+	uri, err := c.backend.URI()
+	if err != nil {
+		return err
+	}
+
+	var cidrs []string
+	for _, subnet := range subnets {
+		if subnet != nil {
+			cidrs = append(cidrs, subnet.CIDR)
+		}
+	}
+	return c.ipam.Reserve(ipam.Allocation{Network: name, URI: uri, CIDRs: cidrs})
+}
+
+// allocation returns the IPAM allocation of the network, nil if it has none or there is no IPAM database
+func (c *Client) allocation(name string) (*ipam.Allocation, error) {
+	if c.ipam == nil {
+		return nil, nil
+	}
+	uri, err := c.backend.URI()
+	if err != nil {
+		return nil, err
+	}
+	return c.ipam.Get(uri, name)
+}
+
+// releaseAllocation removes the allocation of the network from the IPAM database, if any
+func (c *Client) releaseAllocation(name string) error {
+	if c.ipam == nil {
+		return nil
+	}
+	uri, err := c.backend.URI()
+	if err != nil {
+		return err
+	}
+	released, err := c.ipam.Release(uri, name)
+	if released {
+		log.Debugf("released IPAM allocation of network %s", name)
+	}
+	return err
+}
+
+// ReconcileIPAM repairs the drift between the IPAM database and the networks defined on the hypervisor:
+// allocations of networks that no longer exist are released, allocations whose subnets differ from the ones
+// of the network are updated, and the subnets of the networks created by netctl without an allocation are
+// reserved. With dryRun, the changes are only reported
+func (c *Client) ReconcileIPAM(ctx context.Context, dryRun bool) ([]IPAMChange, error) {
+	if c.ipam == nil {
+		return nil, errors.New("no IPAM database configured")
+	}
+	uri, err := c.backend.URI()
+	if err != nil {
+		return nil, err
+	}
+	allocs, err := c.ipam.List()
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []IPAMChange{}
+	allocated := map[string]bool{}
+	for _, a := range allocs {
+		if err := checkContext(ctx); err != nil {
+			return changes, err
+		}
+		if a.URI != uri {
+			continue
+		}
+		allocated[a.Network] = true
+
+		xmlString, err := c.backend.NetworkXML(a.Network)
+		if errors.Is(err, ErrNetworkNotFound) {
+			changes = append(changes, IPAMChange{Network: a.Network, Action: "released", CIDRs: a.CIDRs})
+			if !dryRun {
+				if _, err := c.ipam.Release(uri, a.Network); err != nil {
+					return changes, err
+				}
+			}
+			continue
+		}
+		if err != nil {
+			return changes, err
+		}
+
+		cidrs, err := networkCIDRs(xmlString)
+		if err != nil {
+			return changes, errors.Wrapf(err, "network '%s'", a.Network)
+		}
+		if equalCIDRs(cidrs, a.CIDRs) {
+			continue
+		}
+		changes = append(changes, IPAMChange{Network: a.Network, Action: "updated", CIDRs: cidrs})
+		if !dryRun {
+			a.CIDRs = cidrs
+			if err := c.ipam.Reserve(a); err != nil {
+				return changes, err
+			}
+		}
+	}
+
+	owned, err := c.ownedNetworks()
+	if err != nil {
+		return changes, err
+	}
+	for _, name := range owned {
+		if err := checkContext(ctx); err != nil {
+			return changes, err
+		}
+		if allocated[name] {
+			continue
+		}
+		xmlString, err := c.backend.NetworkXML(name)
+		if err != nil {
+			return changes, err
+		}
+		v, err := parseNetworkXML(xmlString)
+		if err != nil {
+			return changes, errors.Wrapf(err, "network '%s'", name)
+		}
+		cidrs := v.cidrs()
+		if len(cidrs) == 0 {
+			continue
+		}
+		changes = append(changes, IPAMChange{Network: name, Action: "reserved", CIDRs: cidrs})
+		if !dryRun {
+			// the allocation dates back to the creation of the network
+			m := v.metadata()
+			a := ipam.Allocation{Network: name, URI: uri, CIDRs: cidrs, Owner: m.Creator, Created: m.Created}
+			if err := c.ipam.Reserve(a); err != nil {
+				return changes, err
+			}
+		}
+	}
+	return changes, nil
+}
+
+// networkCIDRs returns the subnets of the network described by xmlString
+func networkCIDRs(xmlString string) ([]string, error) {
+	v, err := parseNetworkXML(xmlString)
+	if err != nil {
+		return nil, err
+	}
+	return v.cidrs(), nil
+}
+
+// cidrs returns the subnets of the network
+func (v *networkXML) cidrs() []string {
+	var cidrs []string
+	for _, ip := range v.IPs {
+		if _, subnet, err := net.ParseCIDR(ip.cidr()); err == nil {
+			cidrs = append(cidrs, subnet.String())
+		}
+	}
+	return cidrs
+}
+
+func equalCIDRs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a, b = append([]string(nil), a...), append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,104 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/day0ops/netctl/pkg/ipam"
+)
+
+func TestCreateNetworkRecordsAllocation(t *testing.T) {
+	stubSubnets(t)
+	store := ipam.NewStore(filepath.Join(t.TempDir(), "ipam.json"))
+	// another host process already holds the requested subnet
+	if err := store.Reserve(ipam.Allocation{Network: "other", URI: FakeURI, CIDRs: []string{"192.168.123.0/24"}}); err != nil {
+		t.Fatal(err)
+	}
+	b := NewFakeBackend()
+	c := NewClientWithBackend(b).WithIPAM(store)
+
+	if _, err := c.createNetwork(context.Background(), testNetwork()); err != nil {
+		t.Fatalf("createNetwork() error = %v", err)
+	}
+	a, err := store.Get(FakeURI, "test-net")
+	if err != nil || a == nil {
+		t.Fatalf("allocation of test-net = %v, %v", a, err)
+	}
+	if len(a.CIDRs) != 1 || a.CIDRs[0] == "192.168.123.0/24" {
+		t.Errorf("allocation of test-net = %v, want a subnet other than 192.168.123.0/24", a.CIDRs)
+	}
+
+	if _, err := c.DeleteNetwork(context.Background(), "test-net", DeleteOptions{}); err != nil {
+		t.Fatalf("DeleteNetwork() error = %v", err)
+	}
+	if a, _ := store.Get(FakeURI, "test-net"); a != nil {
+		t.Errorf("allocation of test-net was not released: %+v", a)
+	}
+}
+
+func TestCreateNetworkReleasesAllocationOnFailure(t *testing.T) {
+	stubSubnets(t)
+	store := ipam.NewStore(filepath.Join(t.TempDir(), "ipam.json"))
+	b := NewFakeBackend()
+	b.OnDefine = func(string) error { return errors.New("permission denied") }
+	c := NewClientWithBackend(b).WithIPAM(store)
+
+	if _, err := c.createNetwork(context.Background(), testNetwork()); err == nil {
+		t.Fatal("createNetwork() succeeded, want error")
+	}
+	if allocs, err := store.List(); err != nil || len(allocs) != 0 {
+		t.Errorf("allocations after a failed creation = %+v, %v, want none", allocs, err)
+	}
+}
+
+func TestReconcileIPAM(t *testing.T) {
+	store := ipam.NewStore(filepath.Join(t.TempDir(), "ipam.json"))
+	for _, a := range []ipam.Allocation{
+		{Network: "gone", URI: FakeURI, CIDRs: []string{"10.1.0.0/24"}},
+		{Network: "test-net", URI: FakeURI, CIDRs: []string{"10.2.0.0/24"}},
+		{Network: "elsewhere", URI: "qemu:///system", CIDRs: []string{"10.3.0.0/24"}},
+	} {
+		if err := store.Reserve(a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b := NewFakeBackend()
+	if err := b.DefineNetwork(staleNetworkXML); err != nil {
+		t.Fatal(err)
+	}
+	// created by netctl without the IPAM database
+	stubSubnets(t)
+	if _, err := NewClientWithBackend(b).createNetwork(context.Background(), &Network{Name: "lost", Bridge: "virbr-lost", Subnet: "192.168.60.1/24"}); err != nil {
+		t.Fatal(err)
+	}
+	c := NewClientWithBackend(b).WithIPAM(store)
+
+	changes, err := c.ReconcileIPAM(context.Background(), true)
+	if err != nil {
+		t.Fatalf("ReconcileIPAM() error = %v", err)
+	}
+	if len(changes) != 3 || changes[0].Action != "released" || changes[1].Action != "updated" || changes[1].CIDRs[0] != "10.200.0.0/24" ||
+		changes[2].Network != "lost" || changes[2].Action != "reserved" || changes[2].CIDRs[0] != "192.168.60.0/24" {
+		t.Fatalf("ReconcileIPAM() = %+v", changes)
+	}
+	if allocs, _ := store.List(); len(allocs) != 3 {
+		t.Errorf("dry run changed the database: %+v", allocs)
+	}
+
+	if _, err := c.ReconcileIPAM(context.Background(), false); err != nil {
+		t.Fatalf("ReconcileIPAM() error = %v", err)
+	}
+	allocs, _ := store.List()
+	if len(allocs) != 3 {
+		t.Fatalf("allocations after reconcile = %+v", allocs)
+	}
+	if a, _ := store.Get(FakeURI, "test-net"); a == nil || a.CIDRs[0] != "10.200.0.0/24" {
+		t.Errorf("allocation of test-net = %+v, want 10.200.0.0/24", a)
+	}
+	m, _ := networkMetadata(b, "lost")
+	if a, _ := store.Get(FakeURI, "lost"); a == nil || a.CIDRs[0] != "192.168.60.0/24" || !a.Created.Equal(m.Created) {
+		t.Errorf("allocation of lost = %+v, want 192.168.60.0/24 created with the network at %s", a, m.Created)
+	}
+}
@@ -0,0 +1,93 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Lease is a DHCP lease of a network, along with the domain whose interface holds it
+type Lease struct {
+	IP       string    `json:"ip" yaml:"ip"`
+	Prefix   int       `json:"prefix" yaml:"prefix"`
+	MAC      string    `json:"mac" yaml:"mac"`
+	Hostname string    `json:"hostname,omitempty" yaml:"hostname,omitempty"`
+	ClientID string    `json:"clientID,omitempty" yaml:"clientID,omitempty"`
+	Expiry   time.Time `json:"expiry" yaml:"expiry"`
+	Domain   string    `json:"domain,omitempty" yaml:"domain,omitempty"` // empty if no domain interface has the MAC
+}
+
+// Leases returns the DHCP leases of the network sorted by address, correlated to the domains by the MAC
+// addresses of their interfaces on the network
+func (c *Client) Leases(ctx context.Context, network string) ([]Lease, error) {
+	dhcpLeases, err := c.backend.DHCPLeases(network)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	ifaces, err := c.DomainsUsing(ctx, network)
+	if err != nil {
+		return nil, err
+	}
+	owners := map[string]string{}
+	for _, i := range ifaces {
+		owners[strings.ToLower(i.MAC)] = i.Domain
+	}
+
+	leases := make([]Lease, 0, len(dhcpLeases))
+	for _, l := range dhcpLeases {
+		leases = append(leases, Lease{
+			IP:       l.IP,
+			Prefix:   l.Prefix,
+			MAC:      l.MAC,
+			Hostname: l.Hostname,
+			ClientID: l.ClientID,
+			Expiry:   l.Expiry,
+			Domain:   owners[strings.ToLower(l.MAC)],
+		})
+	}
+	sort.Slice(leases, func(i, j int) bool {
+		a, b := net.ParseIP(leases[i].IP), net.ParseIP(leases[j].IP)
+		if len(a.To4()) != len(b.To4()) {
+			// IPv4 first
+			return a.To4() != nil
+		}
+		return bytes.Compare(a, b) < 0
+	})
+	return leases, nil
+}
+
+// WatchLeases polls the DHCP leases of the network every interval until ctx is done, calling f with the
+// leases at first and then whenever they change
+func (c *Client) WatchLeases(ctx context.Context, network string, interval time.Duration, f func([]Lease) error) error {
+	var last []Lease
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		leases, err := c.Leases(ctx, network)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if last == nil || !reflect.DeepEqual(leases, last) {
+			if err := f(leases); err != nil {
+				return err
+			}
+			last = leases
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
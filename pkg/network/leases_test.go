@@ -0,0 +1,56 @@
+package network
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLeases(t *testing.T) {
+	b, c := createTestNetwork(t)
+	b.AddDomain("vm", domainOn("test-net"))
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	if err := b.SetLeases("test-net", []DHCPLease{
+		{IP: "192.168.123.20", Prefix: 24, MAC: "52:54:00:aa:00:02", Expiry: expiry},
+		{IP: "192.168.123.3", Prefix: 24, MAC: "52:54:00:00:00:01", Hostname: "node-1", Expiry: expiry},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	leases, err := c.Leases(context.Background(), "test-net")
+	if err != nil {
+		t.Fatalf("Leases() error = %v", err)
+	}
+	want := []Lease{
+		{IP: "192.168.123.3", Prefix: 24, MAC: "52:54:00:00:00:01", Hostname: "node-1", Expiry: expiry, Domain: "vm"},
+		{IP: "192.168.123.20", Prefix: 24, MAC: "52:54:00:aa:00:02", Expiry: expiry},
+	}
+	if len(leases) != len(want) || leases[0] != want[0] || leases[1] != want[1] {
+		t.Errorf("Leases() = %+v, want %+v", leases, want)
+	}
+}
+
+func TestWatchLeases(t *testing.T) {
+	b, c := createTestNetwork(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var seen [][]Lease
+	err := c.WatchLeases(ctx, "test-net", time.Millisecond, func(leases []Lease) error {
+		seen = append(seen, leases)
+		switch len(seen) {
+		case 1:
+			// a client gets an address
+			return b.SetLeases("test-net", []DHCPLease{{IP: "192.168.123.3", Prefix: 24, MAC: "52:54:00:00:00:01"}})
+		case 2:
+			cancel()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WatchLeases() error = %v", err)
+	}
+	if len(seen) != 2 || len(seen[0]) != 0 || len(seen[1]) != 1 {
+		t.Errorf("WatchLeases() reported %+v, want no lease then one", seen)
+	}
+}
@@ -0,0 +1,470 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/pkg/errors"
+	"libvirt.org/go/libvirt"
+
+	"github.com/day0ops/netctl/pkg/log"
+)
+
+// libvirtBackend is the Backend talking to libvirt
+type libvirtBackend struct {
+	conn *libvirt.Connect
+
+	// whether the connection was opened by the backend, and so has to be closed by it
+	ownsConn bool
+}
+
+// NewLibvirtBackend returns a Backend using an existing libvirt connection. The connection stays owned by the caller
+func NewLibvirtBackend(conn *libvirt.Connect) Backend {
+	return &libvirtBackend{conn: conn}
+}
+
+func (b *libvirtBackend) ListNetworks() ([]string, error) {
+	nets, err := b.conn.ListAllNetworks(0)
+	if err != nil {
+		return nil, errors.Wrap(err, "list all networks")
+	}
+
+	defer func() {
+		for _, n := range nets {
+			if err := n.Free(); err != nil {
+				log.Errorf("failed freeing network: %v", lvErr(err))
+			}
+		}
+	}()
+
+	names := make([]string, 0, len(nets))
+	for _, n := range nets {
+		name, err := n.GetName()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get name of a network")
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (b *libvirtBackend) LookupNetwork(name string) (state NetworkState, err error) {
+	err = b.withNetwork(name, func(n *libvirt.Network) error {
+		if state.Active, err = n.IsActive(); err != nil {
+			return errors.Wrapf(err, "checking network status for %s", name)
+		}
+		if state.Autostart, err = n.GetAutostart(); err != nil {
+			return errors.Wrapf(err, "checking network %s autostart", name)
+		}
+		return nil
+	})
+	return state, err
+}
+
+func (b *libvirtBackend) NetworkXML(name string) (xml string, err error) {
+	err = b.withNetwork(name, func(n *libvirt.Network) error {
+		xml, err = n.GetXMLDesc(0)
+		return errors.Wrapf(err, "failed to get XML of network '%s'", name)
+	})
+	return xml, err
+}
+
+func (b *libvirtBackend) PersistentNetworkXML(name string) (xml string, err error) {
+	err = b.withNetwork(name, func(n *libvirt.Network) error {
+		xml, err = n.GetXMLDesc(libvirt.NETWORK_XML_INACTIVE)
+		return errors.Wrapf(err, "failed to get persistent XML of network '%s'", name)
+	})
+	return xml, err
+}
+
+func (b *libvirtBackend) DefineNetwork(xml string) error {
+	n, err := b.conn.NetworkDefineXML(xml)
+	if err != nil {
+		return lvErr(err)
+	}
+	if err := n.Free(); err != nil {
+		log.Errorf("failed freeing network: %v", lvErr(err))
+	}
+	return nil
+}
+
+func (b *libvirtBackend) CreateNetwork(name string) error {
+	return b.withNetwork(name, func(n *libvirt.Network) error {
+		return n.Create()
+	})
+}
+
+func (b *libvirtBackend) DestroyNetwork(name string) error {
+	return b.withNetwork(name, func(n *libvirt.Network) error {
+		return n.Destroy()
+	})
+}
+
+func (b *libvirtBackend) UndefineNetwork(name string) error {
+	return b.withNetwork(name, func(n *libvirt.Network) error {
+		return n.Undefine()
+	})
+}
+
+var (
+	updateCommands = map[UpdateCommand]libvirt.NetworkUpdateCommand{
+		UpdateAdd:    libvirt.NETWORK_UPDATE_COMMAND_ADD_LAST,
+		UpdateDelete: libvirt.NETWORK_UPDATE_COMMAND_DELETE,
+		UpdateModify: libvirt.NETWORK_UPDATE_COMMAND_MODIFY,
+	}
+	updateSections = map[UpdateSection]libvirt.NetworkUpdateSection{
+		SectionDHCPHost:  libvirt.NETWORK_SECTION_IP_DHCP_HOST,
+		SectionDNSHost:   libvirt.NETWORK_SECTION_DNS_HOST,
+		SectionDNSSRV:    libvirt.NETWORK_SECTION_DNS_SRV,
+		SectionDNSTXT:    libvirt.NETWORK_SECTION_DNS_TXT,
+		SectionDHCPRange: libvirt.NETWORK_SECTION_IP_DHCP_RANGE,
+	}
+)
+
+func (b *libvirtBackend) UpdateNetwork(name string, u NetworkUpdate) error {
+	return b.withNetwork(name, func(n *libvirt.Network) error {
+		flags := libvirt.NETWORK_UPDATE_AFFECT_CONFIG
+		active, err := n.IsActive()
+		if err != nil {
+			return errors.Wrapf(err, "checking network status for %s", name)
+		}
+		if active {
+			flags |= libvirt.NETWORK_UPDATE_AFFECT_LIVE
+		}
+		if err := n.Update(updateCommands[u.Command], updateSections[u.Section], u.ParentIndex, u.XML, flags); err != nil {
+			return fmt.Errorf("failed updating network %s: %w", name, lvErr(err))
+		}
+		return nil
+	})
+}
+
+func (b *libvirtBackend) SetNetworkAutostart(name string, autostart bool) error {
+	return b.withNetwork(name, func(n *libvirt.Network) error {
+		return n.SetAutostart(autostart)
+	})
+}
+
+func (b *libvirtBackend) DHCPLeases(name string) (leases []DHCPLease, err error) {
+	err = b.withNetwork(name, func(n *libvirt.Network) error {
+		lvLeases, err := n.GetDHCPLeases()
+		if err != nil {
+			return fmt.Errorf("failed getting DHCP leases of network %s: %w", name, lvErr(err))
+		}
+		for _, l := range lvLeases {
+			leases = append(leases, DHCPLease{
+				IP:       l.IPaddr,
+				Prefix:   int(l.Prefix),
+				MAC:      l.Mac,
+				Hostname: l.Hostname,
+				ClientID: l.Clientid,
+				Expiry:   l.ExpiryTime,
+			})
+		}
+		return nil
+	})
+	return leases, err
+}
+
+var domainStates = map[libvirt.DomainState]string{
+	libvirt.DOMAIN_NOSTATE:     DomainNoState,
+	libvirt.DOMAIN_RUNNING:     DomainRunning,
+	libvirt.DOMAIN_BLOCKED:     DomainBlocked,
+	libvirt.DOMAIN_PAUSED:      DomainPaused,
+	libvirt.DOMAIN_SHUTDOWN:    DomainShutdown,
+	libvirt.DOMAIN_SHUTOFF:     DomainShutoff,
+	libvirt.DOMAIN_CRASHED:     DomainCrashed,
+	libvirt.DOMAIN_PMSUSPENDED: DomainPMSuspended,
+}
+
+func (b *libvirtBackend) ListDomains() ([]DomainDesc, error) {
+	doms, err := b.conn.ListAllDomains(0)
+	if err != nil {
+		return nil, errors.Wrap(err, "list all domains")
+	}
+	defer func() {
+		for _, dom := range doms {
+			if err := dom.Free(); err != nil {
+				log.Errorf("failed freeing domain: %v", lvErr(err))
+			}
+		}
+	}()
+
+	descs := make([]DomainDesc, 0, len(doms))
+	for _, dom := range doms {
+		name, err := dom.GetName()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get name of a domain")
+		}
+		xmlString, err := dom.GetXMLDesc(libvirt.DOMAIN_XML_INACTIVE)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get XML of domain '%s'", name)
+		}
+		state, _, err := dom.GetState()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get state of domain '%s'", name)
+		}
+		descs = append(descs, DomainDesc{Name: name, State: domainStates[state], XML: xmlString})
+	}
+	return descs, nil
+}
+
+func (b *libvirtBackend) DomainState(name string) (state string, err error) {
+	err = b.withDomain(name, func(dom *libvirt.Domain) error {
+		s, _, err := dom.GetState()
+		if err != nil {
+			return errors.Wrapf(err, "failed to get state of domain '%s'", name)
+		}
+		state = domainStates[s]
+		return nil
+	})
+	return state, err
+}
+
+func (b *libvirtBackend) ShutdownDomain(name string) error {
+	return b.withDomain(name, func(dom *libvirt.Domain) error {
+		return dom.Shutdown()
+	})
+}
+
+func (b *libvirtBackend) DestroyDomain(name string) error {
+	return b.withDomain(name, func(dom *libvirt.Domain) error {
+		return dom.Destroy()
+	})
+}
+
+func (b *libvirtBackend) DetachInterface(name, ifaceXML string) error {
+	return b.withDomain(name, func(dom *libvirt.Domain) error {
+		var flags libvirt.DomainDeviceModifyFlags
+		if persistent, err := dom.IsPersistent(); err != nil {
+			return errors.Wrapf(err, "checking if domain %s is persistent", name)
+		} else if persistent {
+			flags |= libvirt.DOMAIN_DEVICE_MODIFY_CONFIG
+		}
+		if active, err := dom.IsActive(); err != nil {
+			return errors.Wrapf(err, "checking domain status for %s", name)
+		} else if active {
+			flags |= libvirt.DOMAIN_DEVICE_MODIFY_LIVE
+		}
+		return dom.DetachDeviceFlags(ifaceXML, flags)
+	})
+}
+
+var networkEvents = map[libvirt.NetworkEventLifecycleType]string{
+	libvirt.NETWORK_EVENT_DEFINED:   EventNetworkDefined,
+	libvirt.NETWORK_EVENT_UNDEFINED: EventNetworkUndefined,
+	libvirt.NETWORK_EVENT_STARTED:   EventNetworkStarted,
+	libvirt.NETWORK_EVENT_STOPPED:   EventNetworkStopped,
+}
+
+var (
+	eventLoopOnce sync.Once
+	eventLoopErr  error
+)
+
+// startEventLoop registers the default libvirt event loop implementation and runs it for the rest of the process
+func startEventLoop() error {
+	eventLoopOnce.Do(func() {
+		if eventLoopErr = libvirt.EventRegisterDefaultImpl(); eventLoopErr != nil {
+			eventLoopErr = fmt.Errorf("failed registering libvirt event loop: %w", lvErr(eventLoopErr))
+			return
+		}
+		go func() {
+			for {
+				if err := libvirt.EventRunDefaultImpl(); err != nil {
+					log.Errorf("failed running libvirt event loop: %v", lvErr(err))
+				}
+			}
+		}()
+	})
+	return eventLoopErr
+}
+
+func (b *libvirtBackend) WatchEvents(ctx context.Context) (<-chan BackendEvent, error) {
+	uri, err := b.URI()
+	if err != nil {
+		return nil, err
+	}
+	if err := startEventLoop(); err != nil {
+		return nil, err
+	}
+	// events are only delivered to connections opened after the event loop is registered, so the one of the
+	// backend may not get any
+	conn, err := getConnection(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	// the callbacks run in the single event loop of the process, so they queue the events rather than wait for the
+	// consumer
+	queue := newEventQueue()
+	send := queue.push
+	lost := make(chan libvirt.ConnectCloseReason, 1)
+
+	networkCallbackID := -1
+	var domainCallbackIDs []int
+	cleanup := func() {
+		if networkCallbackID >= 0 {
+			if err := conn.NetworkEventDeregister(networkCallbackID); err != nil {
+				log.Errorf("failed deregistering network events: %v", lvErr(err))
+			}
+		}
+		for _, id := range domainCallbackIDs {
+			if err := conn.DomainEventDeregister(id); err != nil {
+				log.Errorf("failed deregistering domain events: %v", lvErr(err))
+			}
+		}
+		if _, err := conn.Close(); err != nil {
+			log.Errorf("failed closing libvirt connection: %v", lvErr(err))
+		}
+	}
+
+	id, err := conn.NetworkEventLifecycleRegister(nil, func(_ *libvirt.Connect, n *libvirt.Network, e *libvirt.NetworkEventLifecycle) {
+		name, err := n.GetName()
+		if err != nil {
+			log.Errorf("failed getting name of network of event %s: %v", e, lvErr(err))
+			return
+		}
+		if eventType, ok := networkEvents[e.Event]; ok {
+			send(BackendEvent{Type: eventType, Network: name})
+		}
+	})
+	if err != nil {
+		cleanup()
+		return nil, fmt.Errorf("failed registering network events: %w", lvErr(err))
+	}
+	networkCallbackID = id
+
+	domainChanged := func(dom *libvirt.Domain) {
+		name, err := dom.GetName()
+		if err != nil {
+			log.Errorf("failed getting name of domain of event: %v", lvErr(err))
+			return
+		}
+		send(BackendEvent{Type: EventDomainChanged, Domain: name})
+	}
+	registrations := []func() (int, error){
+		func() (int, error) {
+			return conn.DomainEventDeviceAddedRegister(nil, func(_ *libvirt.Connect, dom *libvirt.Domain, _ *libvirt.DomainEventDeviceAdded) {
+				domainChanged(dom)
+			})
+		},
+		func() (int, error) {
+			return conn.DomainEventDeviceRemovedRegister(nil, func(_ *libvirt.Connect, dom *libvirt.Domain, _ *libvirt.DomainEventDeviceRemoved) {
+				domainChanged(dom)
+			})
+		},
+		func() (int, error) {
+			return conn.DomainEventLifecycleRegister(nil, func(_ *libvirt.Connect, dom *libvirt.Domain, e *libvirt.DomainEventLifecycle) {
+				// the interfaces of a domain only change when it is (re)defined or undefined
+				if e.Event == libvirt.DOMAIN_EVENT_DEFINED || e.Event == libvirt.DOMAIN_EVENT_UNDEFINED {
+					domainChanged(dom)
+				}
+			})
+		},
+	}
+	for _, register := range registrations {
+		id, err := register()
+		if err != nil {
+			cleanup()
+			return nil, fmt.Errorf("failed registering domain events: %w", lvErr(err))
+		}
+		domainCallbackIDs = append(domainCallbackIDs, id)
+	}
+	if err := conn.RegisterCloseCallback(func(_ *libvirt.Connect, reason libvirt.ConnectCloseReason) {
+		select {
+		case lost <- reason:
+		default:
+		}
+	}); err != nil {
+		cleanup()
+		return nil, fmt.Errorf("failed registering close callback: %w", lvErr(err))
+	}
+
+	events := make(chan BackendEvent)
+	stop := make(chan struct{})
+	go queue.forward(events, stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case reason := <-lost:
+			log.Errorf("lost libvirt connection %s (reason %d)", uri, reason)
+		}
+		close(stop)
+		cleanup()
+	}()
+	return events, nil
+}
+
+func (b *libvirtBackend) URI() (string, error) {
+	uri, err := b.conn.GetURI()
+	if err != nil {
+		return "", fmt.Errorf("failed getting libvirt connection URI: %w", lvErr(err))
+	}
+	return uri, nil
+}
+
+func (b *libvirtBackend) Close() error {
+	if !b.ownsConn || b.conn == nil {
+		return nil
+	}
+	if _, err := b.conn.Close(); err != nil {
+		return fmt.Errorf("failed closing libvirt connection: %w", lvErr(err))
+	}
+	b.conn = nil
+	return nil
+}
+
+// withNetwork looks up the named network and runs f with it, freeing it afterwards
+func (b *libvirtBackend) withNetwork(name string, f func(n *libvirt.Network) error) error {
+	n, err := b.conn.LookupNetworkByName(name)
+	if err != nil {
+		if lvErr(err).Code == libvirt.ERR_NO_NETWORK {
+			return fmt.Errorf("%w: %s", ErrNetworkNotFound, name)
+		}
+		return fmt.Errorf("failed looking up network %s: %w", name, lvErr(err))
+	}
+	defer func() {
+		if err := n.Free(); err != nil {
+			log.Errorf("failed freeing %s network: %v", name, lvErr(err))
+		}
+	}()
+	return f(n)
+}
+
+// withDomain looks up the named domain and runs f with it, freeing it afterwards
+func (b *libvirtBackend) withDomain(name string, f func(dom *libvirt.Domain) error) error {
+	dom, err := b.conn.LookupDomainByName(name)
+	if err != nil {
+		if lvErr(err).Code == libvirt.ERR_NO_DOMAIN {
+			return fmt.Errorf("%w: %s", ErrDomainNotFound, name)
+		}
+		return fmt.Errorf("failed looking up domain %s: %w", name, lvErr(err))
+	}
+	defer func() {
+		if err := dom.Free(); err != nil {
+			log.Errorf("failed freeing %s domain: %v", name, lvErr(err))
+		}
+	}()
+	return f(dom)
+}
+
+func getConnection(connectionURI string) (*libvirt.Connect, error) {
+	conn, err := libvirt.NewConnect(connectionURI)
+	if err != nil {
+		return nil, fmt.Errorf("failed connecting to libvirt socket: %w", lvErr(err))
+	}
+
+	return conn, nil
+}
+
+// lvErr will return libvirt Error struct containing specific libvirt error code, domain, message and level
+func lvErr(err error) libvirt.Error {
+	if err != nil {
+		if lverr, ok := err.(libvirt.Error); ok {
+			return lverr
+		}
+		return libvirt.Error{Code: libvirt.ERR_INTERNAL_ERROR, Message: "internal error"}
+	}
+	return libvirt.Error{Code: libvirt.ERR_OK, Message: ""}
+}
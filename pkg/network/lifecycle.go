@@ -0,0 +1,79 @@
+package network
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/day0ops/netctl/pkg/log"
+)
+
+// StartNetwork starts the named network, as it is defined
+func (c *Client) StartNetwork(ctx context.Context, name string) error {
+	state, err := c.backend.LookupNetwork(name)
+	if err != nil {
+		return errors.Wrapf(err, "failed looking up network %s", name)
+	}
+	if state.Active {
+		log.Infof("network %s is already active", name)
+		return nil
+	}
+	if err := c.backend.CreateNetwork(name); err != nil {
+		return errors.Wrapf(err, "starting network %s", name)
+	}
+	log.Infof("started network %s", name)
+	return nil
+}
+
+// StopNetwork stops the named network, keeping its definition, so its bridge is removed until it is started again.
+// Unless forced, a network used by domains that are not shut off or crashed is refused with a DomainsInUseError, as
+// they would lose their connectivity (paused and suspended ones once resumed)
+func (c *Client) StopNetwork(ctx context.Context, name string, force bool) error {
+	state, err := c.backend.LookupNetwork(name)
+	if err != nil {
+		return errors.Wrapf(err, "failed looking up network %s", name)
+	}
+	if !state.Active {
+		log.Infof("network %s is already inactive", name)
+		return nil
+	}
+
+	if !force {
+		ifaces, err := c.DomainsUsing(ctx, name)
+		if err != nil {
+			return err
+		}
+		var active []DomainInterface
+		for _, i := range ifaces {
+			if i.State != DomainShutoff && i.State != DomainCrashed {
+				active = append(active, i)
+			}
+		}
+		if len(active) > 0 {
+			return &DomainsInUseError{Network: name, Domains: active}
+		}
+	}
+
+	if err := c.backend.DestroyNetwork(name); err != nil {
+		return errors.Wrapf(err, "stopping network %s", name)
+	}
+	log.Infof("stopped network %s", name)
+	return nil
+}
+
+// SetAutostart configures whether the named network is started on host boot
+func (c *Client) SetAutostart(ctx context.Context, name string, enabled bool) error {
+	if _, err := c.backend.LookupNetwork(name); err != nil {
+		return errors.Wrapf(err, "failed looking up network %s", name)
+	}
+	if err := c.backend.SetNetworkAutostart(name, enabled); err != nil {
+		return fmt.Errorf("failed setting autostart of network %s: %w", name, err)
+	}
+	if enabled {
+		log.Infof("network %s will be started on boot", name)
+	} else {
+		log.Infof("network %s won't be started on boot", name)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestNetworkLifecycle(t *testing.T) {
+	stubSubnets(t)
+	b := NewFakeBackend()
+	c := NewClientWithBackend(b)
+	ctx := context.Background()
+	n := testNetwork()
+	n.NoAutostart = true
+
+	if _, err := c.EnsureNetwork(ctx, n); err != nil {
+		t.Fatal(err)
+	}
+	if state, _ := b.LookupNetwork("test-net"); !state.Active || state.Autostart {
+		t.Errorf("network state = %+v, want active without autostart", state)
+	}
+
+	b.AddDomain("vm", domainOn("test-net"))
+	for _, state := range []string{DomainRunning, DomainBlocked, DomainPaused, DomainShutdown, DomainPMSuspended, DomainNoState} {
+		if err := b.SetDomainState("vm", state); err != nil {
+			t.Fatal(err)
+		}
+		var inUse *DomainsInUseError
+		if err := c.StopNetwork(ctx, "test-net", false); !errors.As(err, &inUse) {
+			t.Errorf("StopNetwork() with a %s domain error = %v, want in use error", state, err)
+		}
+	}
+	for _, state := range []string{DomainShutoff, DomainCrashed} {
+		if err := b.SetDomainState("vm", state); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.StopNetwork(ctx, "test-net", false); err != nil {
+			t.Errorf("StopNetwork() with a %s domain error = %v", state, err)
+		}
+		if err := c.StartNetwork(ctx, "test-net"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.SetDomainState("vm", DomainRunning); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.StopNetwork(ctx, "test-net", true); err != nil {
+		t.Fatalf("StopNetwork(force) error = %v", err)
+	}
+	if state, _ := b.LookupNetwork("test-net"); state.Active {
+		t.Error("network still active after StopNetwork")
+	}
+	if b.Calls["UndefineNetwork"] != 0 {
+		t.Error("StopNetwork undefined the network")
+	}
+
+	if err := c.StartNetwork(ctx, "test-net"); err != nil {
+		t.Fatalf("StartNetwork() error = %v", err)
+	}
+	if err := c.StartNetwork(ctx, "test-net"); err != nil {
+		t.Errorf("StartNetwork() of an active network error = %v", err)
+	}
+	if err := c.SetAutostart(ctx, "test-net", true); err != nil {
+		t.Fatalf("SetAutostart() error = %v", err)
+	}
+	if state, _ := b.LookupNetwork("test-net"); !state.Active || !state.Autostart {
+		t.Errorf("network state = %+v, want active with autostart", state)
+	}
+	if err := c.StartNetwork(ctx, "missing"); !errors.Is(err, ErrNetworkNotFound) {
+		t.Errorf("StartNetwork(missing) error = %v, want ErrNetworkNotFound", err)
+	}
+}
@@ -0,0 +1,146 @@
+package network
+
+import (
+	"context"
+	"net"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/day0ops/netctl/pkg/log"
+)
+
+// NetworkInfo is the summary of a libvirt network as reported by ListNetworks
+type NetworkInfo struct {
+	Name        string    `json:"name" yaml:"name"`
+	UUID        string    `json:"uuid" yaml:"uuid"`
+	Bridge      string    `json:"bridge" yaml:"bridge"`
+	Subnet      string    `json:"subnet" yaml:"subnet"`
+	SubnetV6    string    `json:"subnetV6,omitempty" yaml:"subnetV6,omitempty"`
+	Gateway     string    `json:"gateway" yaml:"gateway"`
+	Netmask     string    `json:"netmask" yaml:"netmask"`
+	DHCPStart   string    `json:"dhcpStart,omitempty" yaml:"dhcpStart,omitempty"`
+	DHCPEnd     string    `json:"dhcpEnd,omitempty" yaml:"dhcpEnd,omitempty"`
+	ForwardMode string    `json:"forwardMode" yaml:"forwardMode"`
+	Active      bool      `json:"active" yaml:"active"`
+	Autostart   bool      `json:"autostart" yaml:"autostart"`
+	Domains     []string  `json:"domains" yaml:"domains"`
+	Metadata    *Metadata `json:"metadata,omitempty" yaml:"metadata,omitempty"` // set if the network was created by netctl
+}
+
+// ListFilter narrows down the networks returned by ListNetworks. The zero value matches every network
+type ListFilter struct {
+	// Managed only matches the networks created by netctl
+	Managed bool
+	// Labels only matches the networks created by netctl with all of these labels
+	Labels map[string]string
+}
+
+func (f ListFilter) matches(info *NetworkInfo) bool {
+	if (f.Managed || len(f.Labels) > 0) && info.Metadata == nil {
+		return false
+	}
+	return info.Metadata.hasLabels(f.Labels)
+}
+
+// ListNetworks returns every (active and inactive) network known to libvirt. See Client.ListNetworks
+func ListNetworks(connectionURI string) (infos []NetworkInfo, err error) {
+	err = withClient(connectionURI, func(c *Client) error {
+		infos, err = c.ListNetworks(context.Background(), ListFilter{})
+		return err
+	})
+	return infos, err
+}
+
+// ListNetworks returns every (active and inactive) network known to libvirt matching filter, along with the
+// domains using it
+func (c *Client) ListNetworks(ctx context.Context, filter ListFilter) ([]NetworkInfo, error) {
+	log.Debug("trying to list all networks...")
+	names, err := c.backend.ListNetworks()
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("listed all networks: total of %d networks", len(names))
+
+	infos := make([]NetworkInfo, 0, len(names))
+	for _, name := range names {
+		info, err := networkInfo(c.backend, name)
+		if err != nil {
+			return nil, err
+		}
+		if filter.matches(info) {
+			infos = append(infos, *info)
+		}
+	}
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	doms, err := listDomainXMLs(c.backend)
+	if err != nil {
+		return nil, err
+	}
+	for i := range infos {
+		infos[i].Domains = domainNames(interfacesUsing(doms, infos[i].Name, infos[i].Bridge))
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos, nil
+}
+
+// networkInfo builds the summary of a single network from its XML description and state
+func networkInfo(b Backend, name string) (*NetworkInfo, error) {
+	xmlString, err := b.NetworkXML(name)
+	if err != nil {
+		return nil, err
+	}
+	v, err := parseNetworkXML(xmlString)
+	if err != nil {
+		return nil, errors.Wrapf(err, "network '%s'", name)
+	}
+
+	state, err := b.LookupNetwork(name)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &NetworkInfo{
+		Name:        v.Name,
+		UUID:        v.UUID,
+		Bridge:      v.Bridge.Name,
+		ForwardMode: v.Forward.Mode,
+		Active:      state.Active,
+		Autostart:   state.Autostart,
+		Metadata:    v.metadata(),
+	}
+	// a network without a forward element is isolated
+	if info.ForwardMode == "" {
+		info.ForwardMode = "isolated"
+	}
+
+	for _, ip := range v.IPs {
+		_, subnet, err := net.ParseCIDR(ip.cidr())
+		if err != nil {
+			log.Debugf("failed parsing address of network %s: %v", name, err)
+			continue
+		}
+		if subnet.IP.To4() == nil {
+			if info.SubnetV6 == "" {
+				info.SubnetV6 = subnet.String()
+			}
+			continue
+		}
+		if info.Subnet != "" {
+			continue
+		}
+		info.Subnet = subnet.String()
+		info.Gateway = ip.Address
+		info.Netmask = net.IP(subnet.Mask).String()
+		if ip.DHCP != nil {
+			info.DHCPStart = ip.DHCP.Range.Start
+			info.DHCPEnd = ip.DHCP.Range.End
+		}
+	}
+
+	return info, nil
+}
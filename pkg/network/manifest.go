@@ -0,0 +1,337 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/day0ops/netctl/pkg/config"
+	"github.com/day0ops/netctl/pkg/log"
+)
+
+// Manifest declares the desired state of a set of networks
+type Manifest struct {
+	// QEMU Connection URI the networks are defined on. Empty means the URI the manifest is applied to
+	URI      string    `json:"uri,omitempty" yaml:"uri,omitempty"`
+	Networks []Network `json:"networks" yaml:"networks"`
+}
+
+// Actions planned for a network when applying a manifest
+const (
+	ActionNone   = "none"
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// Change is the action taken (or planned) on a network to reach the state declared in a manifest
+type Change struct {
+	Network string   `json:"network" yaml:"network"`
+	Action  string   `json:"action" yaml:"action"`
+	Reasons []string `json:"reasons,omitempty" yaml:"reasons,omitempty"` // why the network is updated
+	Pending []string `json:"pending,omitempty" yaml:"pending,omitempty"` // changes defined already, waiting for a restart
+}
+
+// LoadManifest reads a YAML or JSON manifest from path, "-" meaning stdin
+func LoadManifest(path string) (*Manifest, error) {
+	if path == "-" {
+		return ParseManifest(os.Stdin)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading manifest: %w", err)
+	}
+	defer f.Close()
+	m, err := ParseManifest(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "manifest %s", path)
+	}
+	return m, nil
+}
+
+// ParseManifest decodes and validates a YAML or JSON manifest. Unknown fields are refused, so typos
+// don't silently fall back to defaults
+func ParseManifest(r io.Reader) (*Manifest, error) {
+	m := &Manifest{}
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(m); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed decoding manifest: %w", err)
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Validate returns an error if a network of the manifest is invalid or declared more than once
+func (m *Manifest) Validate() error {
+	seen := map[string]bool{}
+	for i := range m.Networks {
+		n := &m.Networks[i]
+		if n.Name == "" {
+			return fmt.Errorf("network #%d has no name", i+1)
+		}
+		if seen[n.Name] {
+			return fmt.Errorf("network %s is declared more than once", n.Name)
+		}
+		seen[n.Name] = true
+		if n.Name == config.DefaultPrivateMinikubeNetworkName {
+			return fmt.Errorf("network can't be named %s. This is the name of the private network created by minikube by default", config.DefaultPrivateMinikubeNetworkName)
+		}
+		if n.Subnet == "" && n.SubnetV6 == "" {
+			return fmt.Errorf("network %s needs an IPv4 or an IPv6 subnet", n.Name)
+		}
+		if err := n.Validate(); err != nil {
+			return errors.Wrapf(err, "network %s", n.Name)
+		}
+	}
+	return nil
+}
+
+// Plan diffs the networks declared in m with the ones defined on the hypervisor. With prune, networks
+// created by netctl but missing from the manifest are deleted
+func (c *Client) Plan(ctx context.Context, m *Manifest, prune bool) ([]Change, error) {
+	changes := make([]Change, 0, len(m.Networks))
+	for i := range m.Networks {
+		if err := checkContext(ctx); err != nil {
+			return nil, err
+		}
+		n := &m.Networks[i]
+		xmlString, err := c.backend.PersistentNetworkXML(n.Name)
+		if errors.Is(err, ErrNetworkNotFound) {
+			changes = append(changes, Change{Network: n.Name, Action: ActionCreate})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		reasons, err := n.drift(xmlString)
+		if err != nil {
+			return nil, errors.Wrapf(err, "network '%s'", n.Name)
+		}
+		pending, err := c.pendingChanges(n, reasons)
+		if err != nil {
+			return nil, err
+		}
+		change := Change{Network: n.Name, Action: ActionNone, Pending: pending}
+		if len(reasons) > 0 {
+			change.Action, change.Reasons = ActionUpdate, reasons
+		}
+		changes = append(changes, change)
+	}
+
+	if !prune {
+		return changes, nil
+	}
+	owned, err := c.ownedNetworks()
+	if err != nil {
+		return nil, err
+	}
+	declared := map[string]bool{}
+	for _, n := range m.Networks {
+		declared[n.Name] = true
+	}
+	for _, name := range owned {
+		if !declared[name] {
+			changes = append(changes, Change{Network: name, Action: ActionDelete})
+		}
+	}
+	return changes, nil
+}
+
+// Apply makes the networks defined on the hypervisor match m: missing networks are created, drifted ones are
+// updated in place (see UpdateNetwork) or recreated when their subnets changed and, with prune, networks created
+// by netctl but missing from the manifest are deleted. Networks used by domains or not created by netctl are never
+// recreated or deleted. It returns the changes made, up to the first failure
+func (c *Client) Apply(ctx context.Context, m *Manifest, prune bool) ([]Change, error) {
+	changes, err := c.Plan(ctx, m, prune)
+	if err != nil {
+		return nil, err
+	}
+
+	desired := map[string]*Network{}
+	for i := range m.Networks {
+		desired[m.Networks[i].Name] = &m.Networks[i]
+	}
+	for i, change := range changes {
+		if err := checkContext(ctx); err != nil {
+			return changes[:i], err
+		}
+		switch change.Action {
+		case ActionNone, ActionCreate:
+			_, err = c.EnsureNetwork(ctx, desired[change.Network])
+		case ActionUpdate:
+			_, err = c.UpdateNetwork(ctx, desired[change.Network], UpdateOptions{})
+			if errors.Is(err, ErrRecreateRequired) {
+				log.Infof("recreating drifted network %s", change.Network)
+				_, err = c.UpdateNetwork(ctx, desired[change.Network], UpdateOptions{Recreate: true})
+			}
+		case ActionDelete:
+			log.Infof("pruning network %s", change.Network)
+			_, err = c.DeleteNetwork(ctx, change.Network, DeleteOptions{})
+		}
+		if err != nil {
+			return changes[:i], errors.Wrapf(err, "failed to %s network %s", change.Action, change.Network)
+		}
+	}
+	return changes, nil
+}
+
+// pendingChanges returns the drift of the network as it runs that is not in drift, the one of its definition:
+// the changes redefined already, waiting for the network to be restarted
+func (c *Client) pendingChanges(n *Network, drift []string) ([]string, error) {
+	xmlString, err := c.backend.NetworkXML(n.Name)
+	if err != nil {
+		return nil, err
+	}
+	live, err := n.drift(xmlString)
+	if err != nil {
+		return nil, errors.Wrapf(err, "network '%s'", n.Name)
+	}
+	var pending []string
+	for _, reason := range live {
+		if !slices.Contains(drift, reason) {
+			pending = append(pending, reason)
+		}
+	}
+	return pending, nil
+}
+
+// ownedNetworks returns the names of the networks created by netctl
+func (c *Client) ownedNetworks() ([]string, error) {
+	names, err := c.backend.ListNetworks()
+	if err != nil {
+		return nil, err
+	}
+
+	var owned []string
+	for _, name := range names {
+		m, err := networkMetadata(c.backend, name)
+		if err != nil {
+			return nil, err
+		}
+		if m != nil {
+			owned = append(owned, name)
+		}
+	}
+	return owned, nil
+}
+
+// drift returns how the network described by xmlString differs from n. Subnets the free subnet search could
+// have moved to from the requested ones are not a drift
+func (n *Network) drift(xmlString string) ([]string, error) {
+	v, err := parseNetworkXML(xmlString)
+	if err != nil {
+		return nil, err
+	}
+	return reasons(n.differences(v)), nil
+}
+
+// difference is a setting of a network that differs from its declaration
+type difference struct {
+	field  string // name of the Network field, as in a manifest
+	reason string
+}
+
+// differences returns the settings of the network described by v that differ from n, see drift
+func (n *Network) differences(v *networkXML) []difference {
+	var diffs []difference
+	differs := func(field, what, want, got string) {
+		if want != got {
+			diffs = append(diffs, difference{field, fmt.Sprintf("%s is %q, want %q", what, got, want)})
+		}
+	}
+	if n.Bridge != "" {
+		differs("bridge", "bridge", n.Bridge, v.Bridge.Name)
+	}
+	differs("forwardMode", "forward mode", n.forwardMode(), v.Forward.Mode)
+	differs("forwardDev", "forward device", n.ForwardDev, v.Forward.Dev)
+	if n.NATPortStart != v.Forward.NAT.Port.Start || n.NATPortEnd != v.Forward.NAT.Port.End {
+		diffs = append(diffs, difference{"natPortStart", fmt.Sprintf("NAT port range is %d-%d, want %d-%d",
+			v.Forward.NAT.Port.Start, v.Forward.NAT.Port.End, n.NATPortStart, n.NATPortEnd)})
+	}
+
+	differs("dns", "DNS", fmt.Sprint(n.DNS), fmt.Sprint(v.DNS.Enable != "no"))
+	differs("domain", "domain", n.Domain, v.Domain.Name)
+	differs("domainLocalOnly", "domain local only", fmt.Sprint(n.DomainLocalOnly), fmt.Sprint(v.Domain.LocalOnly == "yes"))
+	var forwarders []string
+	for _, f := range v.DNS.Forwarders {
+		if f.Domain != "" {
+			f.Addr = f.Domain + "=" + f.Addr
+		}
+		forwarders = append(forwarders, f.Addr)
+	}
+	differs("dnsForwarders", "DNS forwarders", strings.Join(n.DNSForwarders, ","), strings.Join(forwarders, ","))
+
+	ip4, ip6 := v.ips()
+	checkSubnet := func(field, what, want string, got *networkIPXML, ipv6 bool) {
+		switch {
+		case want == "" && got != nil:
+			diffs = append(diffs, difference{field, fmt.Sprintf("%s %s is not declared", what, got.cidr())})
+		case want != "" && got == nil:
+			diffs = append(diffs, difference{field, fmt.Sprintf("%s %s is missing", what, want)})
+		case want != "" && !withinSearch(want, got.cidr(), n.step(ipv6), n.tries()):
+			diffs = append(diffs, difference{field, fmt.Sprintf("%s is %s, want %s", what, got.cidr(), want)})
+		}
+	}
+	checkSubnet("subnet", "IPv4 subnet", n.Subnet, ip4, false)
+	checkSubnet("subnetV6", "IPv6 subnet", n.SubnetV6, ip6, true)
+	if n.SubnetV6 != "" && ip6 != nil {
+		mode := IPv6ModeRA
+		if ip6.DHCP != nil {
+			mode = IPv6ModeDHCP
+		}
+		differs("ipv6Mode", "IPv6 mode", n.ipv6Mode(), mode)
+	}
+
+	checkRange := func(field, what, want string, got *networkIPXML) {
+		if want == "" || got == nil || got.DHCP == nil {
+			return
+		}
+		differs(field, what, want, got.DHCP.Range.Start+"-"+got.DHCP.Range.End)
+	}
+	checkRange("dhcpRange", "DHCP range", n.DHCPRange, ip4)
+	checkRange("dhcpRangeV6", "DHCPv6 range", n.DHCPRangeV6, ip6)
+	return append(diffs, n.templateDifferences(v)...)
+}
+
+// templateDifferences compares the custom template of the network and its variables with the ones recorded in the
+// netctl metadata when it was rendered
+func (n *Network) templateDifferences(v *networkXML) []difference {
+	recorded := &Metadata{}
+	if m := v.metadata(); m != nil {
+		recorded = m
+	}
+	template := n.Template
+	if template != "" {
+		if abs, err := filepath.Abs(template); err == nil {
+			template = abs
+		}
+	}
+
+	var diffs []difference
+	switch {
+	case template != recorded.Template:
+		diffs = append(diffs, difference{"template", fmt.Sprintf("template is %q, want %q", recorded.Template, template)})
+	case template != "":
+		// an unreadable template fails when the network is rendered, not here
+		if text, err := loadTemplate(template); err == nil && templateSHA256(text) != recorded.TemplateSHA256 {
+			diffs = append(diffs, difference{"template", fmt.Sprintf("template %s changed", template)})
+		}
+	}
+	// variables are only recorded, and used, with a custom template
+	if (template != "" || recorded.Template != "") && !maps.Equal(n.TemplateVars, recorded.TemplateVars) {
+		diffs = append(diffs, difference{"templateVars", fmt.Sprintf("template variables are %v, want %v",
+			recorded.TemplateVars, n.TemplateVars)})
+	}
+	return diffs
+}
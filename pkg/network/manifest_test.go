@@ -0,0 +1,125 @@
+package network
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+const testManifest = `
+networks:
+  - name: test-net
+    bridge: virbr-test
+    subnet: 192.168.123.1/24
+  - name: lab
+    bridge: virbr-lab
+    subnet: 192.168.200.1/24
+    forwardMode: nat
+`
+
+func TestParseManifest(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		wantErr  string
+	}{
+		{name: "yaml", manifest: testManifest},
+		{name: "json", manifest: `{"networks": [{"name": "test-net", "subnetV6": "fd00:123::/64", "ipv6Mode": "ra"}]}`},
+		{name: "empty", manifest: ""},
+		{name: "unknown field", manifest: "networks:\n  - name: a\n    subnet: 10.0.0.1/24\n    forward: nat\n", wantErr: "field forward not found"},
+		{name: "duplicate", manifest: "networks:\n  - {name: a, subnet: 10.0.0.1/24}\n  - {name: a, subnet: 10.1.0.1/24}\n", wantErr: "more than once"},
+		{name: "no subnet", manifest: "networks:\n  - name: a\n", wantErr: "needs an IPv4 or an IPv6 subnet"},
+		{name: "invalid network", manifest: "networks:\n  - {name: a, subnet: 10.0.0.1/24, forwardMode: bridge}\n", wantErr: "unsupported forward mode"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseManifest(strings.NewReader(tt.manifest))
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("ParseManifest() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestApplyManifest(t *testing.T) {
+	stubSubnets(t)
+	m, err := ParseManifest(strings.NewReader(testManifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := NewFakeBackend()
+	c := NewClientWithBackend(b)
+	// an isolated lab network, which the manifest wants NATed
+	if _, err := c.createNetwork(context.Background(), &Network{Name: "lab", Bridge: "virbr-lab", Subnet: "192.168.200.1/24"}); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := c.Plan(context.Background(), m, false)
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	if len(plan) != 2 || plan[0].Action != ActionCreate || plan[1].Action != ActionUpdate || len(plan[1].Reasons) != 1 {
+		t.Fatalf("Plan() = %+v", plan)
+	}
+	if b.Calls["DefineNetwork"] != 1 || b.Calls["UndefineNetwork"] != 0 {
+		t.Error("Plan() changed the networks")
+	}
+
+	if _, err := c.Apply(context.Background(), m, false); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	xml, _ := b.PersistentNetworkXML("lab")
+	if !strings.Contains(xml, "<forward mode='nat'>") {
+		t.Errorf("drifted network was not updated:\n%s", xml)
+	}
+	if b.Calls["UndefineNetwork"] != 0 {
+		t.Error("Apply() recreated the drifted network instead of updating it")
+	}
+
+	plan, err = c.Plan(context.Background(), m, false)
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	for _, change := range plan {
+		if change.Action != ActionNone {
+			t.Errorf("Plan() after Apply() = %+v, want no changes", change)
+		}
+	}
+	// the running lab network keeps its forward mode until restarted
+	if len(plan[1].Pending) != 1 {
+		t.Errorf("Plan() after Apply() = %+v, want the forward mode pending a restart", plan[1])
+	}
+	defines := b.Calls["DefineNetwork"]
+	if _, err := c.Apply(context.Background(), m, false); err != nil || b.Calls["DefineNetwork"] != defines {
+		t.Errorf("Apply() again error = %v, redefined %d times, want nothing done", err, b.Calls["DefineNetwork"]-defines)
+	}
+}
+
+func TestApplyManifestPrunesOwnedNetworks(t *testing.T) {
+	stubSubnets(t)
+	b := NewFakeBackend()
+	c := NewClientWithBackend(b)
+	if _, err := c.createNetwork(context.Background(), &Network{Name: "old", Bridge: "virbr-old", Subnet: "192.168.50.1/24"}); err != nil {
+		t.Fatal(err)
+	}
+	// not created by netctl, so left alone
+	if err := b.DefineNetwork(`<network><name>default</name></network>`); err != nil {
+		t.Fatal(err)
+	}
+	m, err := ParseManifest(strings.NewReader(testManifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := c.Apply(context.Background(), m, true)
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if last := changes[len(changes)-1]; last.Network != "old" || last.Action != ActionDelete {
+		t.Errorf("Apply() = %+v, want old deleted", changes)
+	}
+	names, _ := b.ListNetworks()
+	if strings.Join(names, ",") != "default,lab,test-net" {
+		t.Errorf("networks after prune = %v", names)
+	}
+}
@@ -0,0 +1,160 @@
+package network
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/day0ops/netctl/pkg/config"
+	"github.com/day0ops/netctl/pkg/util"
+)
+
+// ErrNotManaged is returned when refusing to change a network that was not created by netctl
+var ErrNotManaged = errors.New("network was not created by netctl")
+
+// Metadata is the ownership information netctl records in the XML of the networks it creates
+type Metadata struct {
+	Creator string            `json:"creator" yaml:"creator"` // user that created the network
+	Version string            `json:"version" yaml:"version"` // netctl version that created the network
+	Created time.Time         `json:"created" yaml:"created"`
+	Labels  map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+
+	// Template is the absolute path of the custom template the network was rendered with, see Network.Template
+	Template       string            `json:"template,omitempty" yaml:"template,omitempty"`
+	TemplateSHA256 string            `json:"templateSHA256,omitempty" yaml:"templateSHA256,omitempty"` // of the template content
+	TemplateVars   map[string]string `json:"templateVars,omitempty" yaml:"templateVars,omitempty"`
+}
+
+// metadataXML is the netctl element of the network metadata, see config.NetworkTmpl
+type metadataXML struct {
+	Creator string `xml:"creator"`
+	Version string `xml:"version"`
+	Created string `xml:"created"`
+	Labels  []struct {
+		Key   string `xml:"key,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"label"`
+	Template *struct {
+		Path   string `xml:",chardata"`
+		SHA256 string `xml:"sha256,attr"`
+	} `xml:"template"`
+	TemplateVars []struct {
+		Key   string `xml:"key,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"templateVar"`
+}
+
+// metadataTmpl is Metadata with every value escaped for rendering in config.NetworkTmpl
+type metadataTmpl struct {
+	Creator string
+	Version string
+	Created string
+	Labels  map[string]string
+}
+
+var labelKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9]([-A-Za-z0-9_./]*[A-Za-z0-9])?$`)
+
+// validateLabels returns an error if a label key is not made of alphanumerics, '-', '_', '.' or '/'
+func validateLabels(labels map[string]string) error {
+	for key := range labels {
+		if !labelKeyRegexp.MatchString(key) {
+			return fmt.Errorf("invalid label key %q (must be alphanumerics, '-', '_', '.' or '/', starting and ending with an alphanumeric)", key)
+		}
+	}
+	return nil
+}
+
+// newMetadata returns the metadata of a network created now by the current user
+func newMetadata(labels map[string]string) *Metadata {
+	return &Metadata{
+		Creator: util.CurrentUser(),
+		Version: config.AppVersion().Version,
+		Created: time.Now().UTC().Truncate(time.Second),
+		Labels:  labels,
+	}
+}
+
+func (m *Metadata) template() *metadataTmpl {
+	t := &metadataTmpl{
+		Creator: escapeXML(m.Creator),
+		Version: escapeXML(m.Version),
+		Created: m.Created.Format(time.RFC3339),
+		Labels:  map[string]string{},
+	}
+	for key, value := range m.Labels {
+		t.Labels[escapeXML(key)] = escapeXML(value)
+	}
+	return t
+}
+
+// metadata returns the netctl metadata of the network, nil if it was not created by netctl
+func (v *networkXML) metadata() *Metadata {
+	x := v.Metadata.Netctl
+	if x == nil {
+		return nil
+	}
+	m := &Metadata{Creator: x.Creator, Version: x.Version}
+	// a malformed creation time is left out rather than disowning the network
+	m.Created, _ = time.Parse(time.RFC3339, x.Created)
+	for _, label := range x.Labels {
+		if m.Labels == nil {
+			m.Labels = map[string]string{}
+		}
+		m.Labels[label.Key] = label.Value
+	}
+	if x.Template != nil {
+		m.Template, m.TemplateSHA256 = x.Template.Path, x.Template.SHA256
+	}
+	for _, v := range x.TemplateVars {
+		if m.TemplateVars == nil {
+			m.TemplateVars = map[string]string{}
+		}
+		m.TemplateVars[v.Key] = v.Value
+	}
+	return m
+}
+
+// hasLabels reports whether every given label is set to the same value in m
+func (m *Metadata) hasLabels(labels map[string]string) bool {
+	for key, value := range labels {
+		if m == nil || m.Labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// networkMetadata returns the netctl metadata of the named network, nil if it was not created by netctl
+func networkMetadata(b Backend, name string) (*Metadata, error) {
+	xmlString, err := b.PersistentNetworkXML(name)
+	if err != nil {
+		return nil, err
+	}
+	v, err := parseNetworkXML(xmlString)
+	if err != nil {
+		return nil, errors.Wrapf(err, "network '%s'", name)
+	}
+	return v.metadata(), nil
+}
+
+// checkManaged returns ErrNotManaged if the named network was not created by netctl
+func checkManaged(b Backend, name string) error {
+	m, err := networkMetadata(b, name)
+	if err != nil {
+		return err
+	}
+	if m == nil {
+		return fmt.Errorf("%w: %s", ErrNotManaged, name)
+	}
+	return nil
+}
+
+func escapeXML(s string) string {
+	var b bytes.Buffer
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
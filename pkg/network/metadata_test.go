@@ -0,0 +1,94 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCreateNetworkRecordsMetadata(t *testing.T) {
+	stubSubnets(t)
+	b := NewFakeBackend()
+	c := NewClientWithBackend(b)
+	n := testNetwork()
+	n.Labels = map[string]string{"team": "qa", "purpose": "<ha & lb>"}
+
+	if _, err := c.createNetwork(context.Background(), n); err != nil {
+		t.Fatalf("createNetwork() error = %v", err)
+	}
+	xml, _ := b.NetworkXML("test-net")
+	if !strings.Contains(xml, "<netctl:label key='purpose'>&lt;ha &amp; lb&gt;</netctl:label>") {
+		t.Errorf("label was not escaped in network XML:\n%s", xml)
+	}
+
+	d, err := c.Inspect(context.Background(), "test-net")
+	if err != nil {
+		t.Fatalf("Inspect() error = %v", err)
+	}
+	m := d.Metadata
+	if m == nil || m.Creator == "" || m.Version == "" || m.Created.IsZero() || m.Labels["purpose"] != "<ha & lb>" || m.Labels["team"] != "qa" {
+		t.Errorf("Inspect() metadata = %+v", m)
+	}
+}
+
+func TestListNetworksFilter(t *testing.T) {
+	stubSubnets(t)
+	b := NewFakeBackend()
+	c := NewClientWithBackend(b)
+	if err := b.DefineNetwork(staleNetworkXML); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []*Network{
+		{Name: "qa", Bridge: "virbr-qa", Subnet: "192.168.10.1/24", Labels: map[string]string{"team": "qa"}},
+		{Name: "dev", Bridge: "virbr-dev", Subnet: "192.168.20.1/24", Labels: map[string]string{"team": "dev"}},
+	} {
+		if _, err := c.createNetwork(context.Background(), n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter ListFilter
+		want   string
+	}{
+		{name: "all", want: "dev,qa,test-net"},
+		{name: "managed", filter: ListFilter{Managed: true}, want: "dev,qa"},
+		{name: "labels", filter: ListFilter{Labels: map[string]string{"team": "qa"}}, want: "qa"},
+		{name: "no match", filter: ListFilter{Labels: map[string]string{"team": "ops"}}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			infos, err := c.ListNetworks(context.Background(), tt.filter)
+			if err != nil {
+				t.Fatalf("ListNetworks() error = %v", err)
+			}
+			var names []string
+			for _, info := range infos {
+				names = append(names, info.Name)
+			}
+			if got := strings.Join(names, ","); got != tt.want {
+				t.Errorf("ListNetworks() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeleteNetworkRefusesUnmanagedNetwork(t *testing.T) {
+	b := NewFakeBackend()
+	if err := b.DefineNetwork(staleNetworkXML); err != nil {
+		t.Fatal(err)
+	}
+	c := NewClientWithBackend(b)
+
+	if _, err := c.DeleteNetwork(context.Background(), "test-net", DeleteOptions{}); !errors.Is(err, ErrNotManaged) {
+		t.Fatalf("DeleteNetwork() error = %v, want ErrNotManaged", err)
+	}
+	if _, err := c.DeleteNetwork(context.Background(), "test-net", DeleteOptions{Force: true}); err != nil {
+		t.Fatalf("forced DeleteNetwork() error = %v", err)
+	}
+	if _, err := b.LookupNetwork("test-net"); !errors.Is(err, ErrNetworkNotFound) {
+		t.Errorf("network was not deleted: %v", err)
+	}
+}
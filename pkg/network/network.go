@@ -2,272 +2,832 @@ package network
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
 	"fmt"
+	"maps"
 	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"text/template"
 	"time"
+	"unicode"
 
 	"github.com/pkg/errors"
-	"libvirt.org/go/libvirt"
 
 	"github.com/day0ops/netctl/pkg/config"
+	"github.com/day0ops/netctl/pkg/ipam"
 	"github.com/day0ops/netctl/pkg/log"
 	"github.com/day0ops/netctl/pkg/util"
 )
 
 type Network struct {
 	// The name of the network
-	Name string
+	Name string `json:"name" yaml:"name"`
 
 	// The name of the bridge to create
-	Bridge string
+	Bridge string `json:"bridge,omitempty" yaml:"bridge,omitempty"`
 
-	// Subnet of the network
-	Subnet string
+	// IPv4 subnet of the network
+	Subnet string `json:"subnet,omitempty" yaml:"subnet,omitempty"`
+
+	// IPv6 subnet of the network, making it dual-stack when Subnet is set too
+	SubnetV6 string `json:"subnetV6,omitempty" yaml:"subnetV6,omitempty"`
+
+	// Number of prefix-sized blocks to move forward when the subnet is taken. Defaults to 11 for IPv4 and 1 for IPv6
+	Step int `json:"step,omitempty" yaml:"step,omitempty"`
+
+	// Number of subnets to try before giving up. Defaults to 20
+	Tries int `json:"tries,omitempty" yaml:"tries,omitempty"`
+
+	// Container runtimes (docker, podman) whose networks the subnet must not overlap with
+	ContainerRuntimes []string `json:"containerRuntimes,omitempty" yaml:"containerRuntimes,omitempty"`
+
+	// DHCP range of the IPv4 subnet as start-end addresses. Defaults to the whole subnet but the gateway and the VIP
+	DHCPRange string `json:"dhcpRange,omitempty" yaml:"dhcpRange,omitempty"`
+
+	// DHCPv6 range of the IPv6 subnet as start-end addresses
+	DHCPRangeV6 string `json:"dhcpRangeV6,omitempty" yaml:"dhcpRangeV6,omitempty"`
+
+	// How IPv6 clients are configured: dhcp (DHCPv6 range) or ra (router advertisements only, i.e. SLAAC)
+	IPv6Mode string `json:"ipv6Mode,omitempty" yaml:"ipv6Mode,omitempty"`
+
+	// Forward mode of the network (none, nat, route or open). Empty or none means the network is isolated
+	ForwardMode string `json:"forwardMode,omitempty" yaml:"forwardMode,omitempty"`
+
+	// Host device the traffic is forwarded to (nat and route modes only). Empty means any device
+	ForwardDev string `json:"forwardDev,omitempty" yaml:"forwardDev,omitempty"`
+
+	// Range of source ports used when masquerading (nat mode only)
+	NATPortStart int `json:"natPortStart,omitempty" yaml:"natPortStart,omitempty"`
+	NATPortEnd   int `json:"natPortEnd,omitempty" yaml:"natPortEnd,omitempty"`
+
+	// Whether the DNS server of the network (dnsmasq) is enabled
+	DNS bool `json:"dns,omitempty" yaml:"dns,omitempty"`
+
+	// Domain of the network, the DHCP clients are registered in
+	Domain string `json:"domain,omitempty" yaml:"domain,omitempty"`
+
+	// Whether names in Domain are only resolved locally, never forwarded upstream
+	DomainLocalOnly bool `json:"domainLocalOnly,omitempty" yaml:"domainLocalOnly,omitempty"`
+
+	// Upstream DNS servers, given as addr or as domain=addr to only forward the names of domain
+	DNSForwarders []string `json:"dnsForwarders,omitempty" yaml:"dnsForwarders,omitempty"`
+
+	// User labels recorded in the network metadata
+	Labels map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+
+	// Whether the network is left out of the networks started on host boot
+	NoAutostart bool `json:"noAutostart,omitempty" yaml:"noAutostart,omitempty"`
+
+	// Path of a Go template of the network XML replacing config.NetworkTmpl. It is rendered with the same data, and
+	// the xml function to escape values
+	Template string `json:"template,omitempty" yaml:"template,omitempty"`
+
+	// User variables given to the template as .Vars
+	TemplateVars map[string]string `json:"templateVars,omitempty" yaml:"templateVars,omitempty"`
 
 	// QEMU Connection URI
-	ConnectionURI string
+	ConnectionURI string `json:"-" yaml:"-"`
 }
 
+// Forward modes supported for a network
+const (
+	ForwardModeNone  = "none"
+	ForwardModeNAT   = "nat"
+	ForwardModeRoute = "route"
+	ForwardModeOpen  = "open"
+)
+
+// IPv6 modes supported for a network
+const (
+	IPv6ModeDHCP = "dhcp"
+	IPv6ModeRA   = "ra"
+)
+
 type libvirtNetwork struct {
-	Name   string
-	Bridge string
+	Name            string
+	UUID            string // kept when redefining an existing network
+	MAC             string // of the bridge, kept when redefining an existing network
+	Bridge          string
+	ForwardMode     string
+	ForwardDev      string
+	NATPortStart    int
+	NATPortEnd      int
+	IPv6Mode        string
+	DNS             bool
+	DNSForwarders   []dnsForwarder
+	Domain          string
+	DomainLocalOnly bool
+	Metadata        *metadataTmpl
 	Parameters
+	ParametersV6 *Parameters
+	Vars         map[string]string // user variables of a custom template, see Network.TemplateVars
+
+	template     string // custom template, config.NetworkTmpl if empty
+	templatePath string // absolute path of the custom template
 }
 
-// EnsureNetwork is called to set up the network if one doesn't exist. If it does exist it will try to recreate it
-func (n *Network) EnsureNetwork() error {
-	conn, err := getConnection(n.ConnectionURI)
-	if err != nil {
-		return fmt.Errorf("failed opening libvirt connection: %w", err)
+// Validate returns an error if the network options are a combination libvirt would refuse
+func (n *Network) Validate() error {
+	if strings.ContainsFunc(n.Name, func(r rune) bool { return r == '/' || !unicode.IsPrint(r) }) {
+		return fmt.Errorf("invalid network name %q (must be printable characters but '/')", n.Name)
 	}
-	defer func() {
-		if _, err := conn.Close(); err != nil {
-			log.Errorf("failed closing libvirt connection: %v", lvErr(err))
+	if err := validateIfaceName("bridge", n.Bridge); err != nil {
+		return err
+	}
+	if err := validateIfaceName("forward device", n.ForwardDev); err != nil {
+		return err
+	}
+
+	if n.Subnet != "" {
+		if ip, _, err := net.ParseCIDR(n.Subnet); err != nil || ip.To4() == nil {
+			return fmt.Errorf("invalid IPv4 subnet %q (for e.g. it should be of the form 10.89.0.1/24)", n.Subnet)
 		}
-	}()
+	}
+	if n.SubnetV6 != "" {
+		ip, ipnet, err := net.ParseCIDR(n.SubnetV6)
+		if err != nil || ip.To4() != nil {
+			return fmt.Errorf("invalid IPv6 subnet %q (for e.g. it should be of the form fd00:89::/64)", n.SubnetV6)
+		}
+		switch n.IPv6Mode {
+		case "", IPv6ModeDHCP:
+		case IPv6ModeRA:
+			if ones, _ := ipnet.Mask.Size(); ones != 64 {
+				return fmt.Errorf("IPv6 mode %s (SLAAC) requires a /64 subnet, got /%d", IPv6ModeRA, ones)
+			}
+		default:
+			return fmt.Errorf("unsupported IPv6 mode %q (must be one of %s or %s)", n.IPv6Mode, IPv6ModeDHCP, IPv6ModeRA)
+		}
+	} else if n.IPv6Mode != "" {
+		return fmt.Errorf("IPv6 mode %s requires an IPv6 subnet", n.IPv6Mode)
+	}
 
-	log.Infof("ensuring network %s is active", n.Name)
-	// retry once to recreate the network, but only if is not used
-	if err := setupNetwork(conn, n.Name); err != nil {
-		log.Debugf("network %s is inoperable, will try to recreate it: %v", n.Name, err)
-		if err := n.DeleteNetwork(); err != nil {
-			return errors.Wrapf(err, "deleting inoperable network %s", n.Name)
+	if err := validateDHCPRange(n.DHCPRange, n.Subnet, false); err != nil {
+		return err
+	}
+	if err := validateDHCPRange(n.DHCPRangeV6, n.SubnetV6, true); err != nil {
+		return err
+	}
+	if n.DHCPRangeV6 != "" && n.IPv6Mode == IPv6ModeRA {
+		return fmt.Errorf("DHCPv6 range is not supported with IPv6 mode %s", IPv6ModeRA)
+	}
+
+	switch n.ForwardMode {
+	case "", ForwardModeNone:
+		if n.ForwardDev != "" {
+			return fmt.Errorf("forward device %s requires a forward mode (%s or %s)", n.ForwardDev, ForwardModeNAT, ForwardModeRoute)
+		}
+	case ForwardModeNAT, ForwardModeRoute:
+	case ForwardModeOpen:
+		if n.ForwardDev != "" {
+			return fmt.Errorf("forward device is not supported with forward mode %s", ForwardModeOpen)
+		}
+	default:
+		return fmt.Errorf("unsupported forward mode %q (must be one of %s, %s, %s or %s)", n.ForwardMode, ForwardModeNone, ForwardModeNAT, ForwardModeRoute, ForwardModeOpen)
+	}
+
+	for _, runtime := range n.ContainerRuntimes {
+		if runtime != ContainerRuntimeDocker && runtime != ContainerRuntimePodman {
+			return fmt.Errorf("unsupported container runtime %q (must be %s or %s)", runtime, ContainerRuntimeDocker, ContainerRuntimePodman)
 		}
-		log.Debugf("deleted or skipped %s network", n.Name)
-		if err := n.createNetwork(); err != nil {
-			return errors.Wrapf(err, "recreating inoperable network %s", n.Name)
+	}
+
+	if err := n.validateDNS(); err != nil {
+		return err
+	}
+
+	if err := validateLabels(n.Labels); err != nil {
+		return err
+	}
+
+	if n.Template != "" {
+		text, err := loadTemplate(n.Template)
+		if err != nil {
+			return err
 		}
-		log.Debugf("🎉 successfully recreated %s network", n.Name)
-		if err := setupNetwork(conn, n.Name); err != nil {
+		if _, err := parseTemplate(text); err != nil {
 			return err
 		}
-		log.Debugf("🎉 successfully activated %s network", n.Name)
 	}
 
+	if n.Step < 0 || n.Tries < 0 {
+		return fmt.Errorf("subnet step and tries can't be negative")
+	}
+
+	if n.NATPortStart != 0 || n.NATPortEnd != 0 {
+		if n.ForwardMode != ForwardModeNAT {
+			return fmt.Errorf("NAT port range is only supported with forward mode %s", ForwardModeNAT)
+		}
+		if n.NATPortStart < 1 || n.NATPortEnd > 65535 || n.NATPortStart > n.NATPortEnd {
+			return fmt.Errorf("invalid NAT port range %d-%d (must be within 1-65535 with start <= end)", n.NATPortStart, n.NATPortEnd)
+		}
+	}
 	return nil
 }
 
-// createNetwork is not called directly. See EnsureNetwork
-func (n *Network) createNetwork() error {
-	if n.Name == config.DefaultPrivateMinikubeNetworkName {
-		return fmt.Errorf("network can't be named %s. This is the name of the private network created by minikube by default", config.DefaultPrivateMinikubeNetworkName)
+// validateIfaceName returns an error if name is set but is not a name the kernel accepts for a network interface
+func validateIfaceName(what, name string) error {
+	if name == "" {
+		return nil
+	}
+	invalid := func(r rune) bool { return r == '/' || r == ':' || unicode.IsSpace(r) || !unicode.IsPrint(r) }
+	if len(name) > 15 || name == "." || name == ".." || strings.ContainsFunc(name, invalid) {
+		return fmt.Errorf("invalid %s %q (must be at most 15 characters, without '/', ':' or spaces)", what, name)
 	}
+	return nil
+}
 
-	conn, err := getConnection(n.ConnectionURI)
-	if err != nil {
-		return fmt.Errorf("failed opening libvirt connection: %w", err)
+// validateDHCPRange returns an error if dhcpRange is set but is not a start-end range of the given family within subnet
+func validateDHCPRange(dhcpRange, subnet string, ipv6 bool) error {
+	if dhcpRange == "" {
+		return nil
 	}
-	defer func() {
-		if _, err := conn.Close(); err != nil {
-			log.Errorf("failed closing libvirt connection: %v", lvErr(err))
+	what, example := "DHCP range", "10.89.0.100-10.89.0.200"
+	if ipv6 {
+		what, example = "DHCPv6 range", "fd00:89::100-fd00:89::1ff"
+	}
+	if subnet == "" {
+		return fmt.Errorf("%s %s requires a subnet of the same family", what, dhcpRange)
+	}
+	start, end, err := parseDHCPRange(dhcpRange)
+	if err != nil || (start.To4() == nil) != ipv6 {
+		return fmt.Errorf("invalid %s %q (for e.g. it should be of the form %s)", what, dhcpRange, example)
+	}
+	_, ipnet, _ := net.ParseCIDR(subnet)
+	if !ipnet.Contains(start) || !ipnet.Contains(end) {
+		return fmt.Errorf("%s %s is outside subnet %s", what, dhcpRange, subnet)
+	}
+	return nil
+}
+
+// parseDHCPRange parses a start-end range of addresses, start being lower than or equal to end
+func parseDHCPRange(dhcpRange string) (net.IP, net.IP, error) {
+	s, e, ok := strings.Cut(dhcpRange, "-")
+	start, end := net.ParseIP(s), net.ParseIP(e)
+	if !ok || start == nil || end == nil || (start.To4() == nil) != (end.To4() == nil) {
+		return nil, nil, fmt.Errorf("invalid range %q", dhcpRange)
+	}
+	if start.To4() != nil {
+		start, end = start.To4(), end.To4()
+	}
+	if bytes.Compare(start, end) > 0 {
+		return nil, nil, fmt.Errorf("range %q starts after its end", dhcpRange)
+	}
+	return start, end, nil
+}
+
+// step returns the number of prefix-sized blocks FreeSubnet moves forward. IPv6 pools are large
+// enough to simply try the next block
+func (n *Network) step(ipv6 bool) int {
+	switch {
+	case n.Step > 0:
+		return n.Step
+	case ipv6:
+		return config.DefaultSubnetStepV6
+	default:
+		return config.DefaultSubnetStep
+	}
+}
+
+// tries returns the number of subnets FreeSubnet tries before giving up
+func (n *Network) tries() int {
+	if n.Tries > 0 {
+		return n.Tries
+	}
+	return config.DefaultSubnetTries
+}
+
+// ipv6Mode returns the IPv6 mode to render in the network XML, defaulting to DHCPv6
+func (n *Network) ipv6Mode() string {
+	if n.IPv6Mode == "" {
+		return IPv6ModeDHCP
+	}
+	return n.IPv6Mode
+}
+
+// forwardMode returns the forward mode to render in the network XML, empty for isolated networks
+func (n *Network) forwardMode() string {
+	if n.ForwardMode == ForwardModeNone {
+		return ""
+	}
+	return n.ForwardMode
+}
+
+// EnsureNetwork is called to set up the network if one doesn't exist. If it does exist it will try to recreate it.
+// See Client.EnsureNetwork
+func (n *Network) EnsureNetwork() error {
+	return withClient(n.ConnectionURI, func(c *Client) error {
+		_, err := c.EnsureNetwork(context.Background(), n)
+		return err
+	})
+}
+
+// DeleteNetwork deletes the network if it is not used by any domain, whether it was created by netctl or not.
+// See Client.DeleteNetwork
+func (n *Network) DeleteNetwork() error {
+	return withClient(n.ConnectionURI, func(c *Client) error {
+		_, err := c.DeleteNetwork(context.Background(), n.Name, DeleteOptions{Force: true})
+		return err
+	})
+}
+
+// EnsureNetwork is called to set up the network if one doesn't exist. If it does exist it will try to recreate it.
+// It returns whether the network was created, recreated or reused, and its addressing
+func (c *Client) EnsureNetwork(ctx context.Context, n *Network) (*Result, error) {
+	l := log.With(log.Fields{log.FieldNetwork: n.Name})
+	l.Infof("ensuring network %s is active", n.Name)
+	outcome := OutcomeReused
+	var skipped []SkippedSubnet
+	// retry once to recreate the network, but only if is not used
+	if err := setupNetwork(c.backend, n.Name, !n.NoAutostart); err != nil {
+		l.Debugf("network %s is inoperable, will try to recreate it: %v", n.Name, err)
+		outcome = OutcomeCreated
+		if _, err := c.backend.LookupNetwork(n.Name); err == nil {
+			outcome = OutcomeRecreated
 		}
-	}()
+		if _, err := c.DeleteNetwork(ctx, n.Name, DeleteOptions{Force: true}); err != nil {
+			return nil, errors.Wrapf(err, "deleting inoperable network %s", n.Name)
+		}
+		l.Debugf("deleted or skipped %s network", n.Name)
+		if skipped, err = c.createNetwork(ctx, n); err != nil {
+			return nil, errors.Wrapf(err, "recreating inoperable network %s", n.Name)
+		}
+		l.Debugf("🎉 successfully recreated %s network", n.Name)
+		if err := setupNetwork(c.backend, n.Name, !n.NoAutostart); err != nil {
+			return nil, err
+		}
+		l.Debugf("🎉 successfully activated %s network", n.Name)
+	}
 
+	r, err := networkResult(c.backend, n.Name, outcome)
+	if err != nil {
+		return nil, err
+	}
+	r.Skipped = skipped
+	return r, nil
+}
+
+// createNetwork is not called directly. See EnsureNetwork. It returns the subnets skipped while looking for free ones
+func (c *Client) createNetwork(ctx context.Context, n *Network) ([]SkippedSubnet, error) {
+	return c.createNetworkWith(ctx, n, n.renderXML)
+}
+
+// createNetworkWith creates the network like createNetwork, its XML rendered by render for the free subnets found,
+// either of which may be nil
+func (c *Client) createNetworkWith(ctx context.Context, n *Network, render func(subnet, subnetV6 *Parameters) (string, error)) ([]SkippedSubnet, error) {
+	if n.Name == config.DefaultPrivateMinikubeNetworkName {
+		return nil, fmt.Errorf("network can't be named %s. This is the name of the private network created by minikube by default", config.DefaultPrivateMinikubeNetworkName)
+	}
+	if err := n.Validate(); err != nil {
+		return nil, err
+	}
+	if n.Subnet == "" && n.SubnetV6 == "" {
+		return nil, fmt.Errorf("network %s needs an IPv4 or an IPv6 subnet", n.Name)
+	}
+
+	l := log.With(log.Fields{log.FieldNetwork: n.Name})
 	// Only create the network if it does not already exist
-	if netp, err := conn.LookupNetworkByName(n.Name); err == nil {
-		log.Warnf("found existing %s network, skipping creation", n.Name)
+	if _, err := c.backend.LookupNetwork(n.Name); err == nil {
+		l.Warnf("found existing %s network, skipping creation", n.Name)
 
-		if netXML, err := netp.GetXMLDesc(0); err != nil {
-			log.Debugf("failed getting %s network XML: %v", n.Name, lvErr(err))
+		if netXML, err := c.backend.NetworkXML(n.Name); err != nil {
+			l.Debugf("failed getting %s network XML: %v", n.Name, err)
 		} else {
-			log.Debug(netXML)
+			l.Trace(netXML)
 		}
+		return nil, nil
+	}
 
-		if err := netp.Free(); err != nil {
-			log.Errorf("failed freeing %s network: %v", n.Name, lvErr(err))
-		}
-		return nil
+	sources, err := c.subnetSources(n)
+	if err != nil {
+		return nil, err
 	}
 
+	// release the subnets recorded for the network, unless it gets created
+	reserved, created := false, false
+	defer func() {
+		if reserved && !created {
+			if err := c.releaseAllocation(n.Name); err != nil {
+				l.Errorf("failed releasing IPAM allocation of network %s: %v", n.Name, err)
+			}
+		}
+	}()
+
 	// retry up to 5 times to create kvm network
-	for attempts, subnetAddr := 0, n.Subnet; attempts < 5; attempts++ {
-		// rather than iterate through all the valid subnets, give up at 20 to avoid a lengthy user delay for something that is unlikely to work.
-		// will be like 192.168.39.0/24,..., 192.168.248.0/24 (in increment steps of 11)
-		var subnet *Parameters
-		subnet, err = FreeSubnet(subnetAddr, 11, 20)
-		if err != nil {
-			log.Debugf("failed finding free subnet for private network %s after %d attempts: %v", n.Name, 20, err)
-			return fmt.Errorf("un-retryable: %w", err)
+	var skipped []SkippedSubnet
+	for attempts, subnetAddr, subnetAddrV6 := 0, n.Subnet, n.SubnetV6; attempts < 5; attempts++ {
+		if err := checkContext(ctx); err != nil {
+			return nil, err
 		}
+		l := l.With(log.Fields{log.FieldAttempt: attempts + 1})
 
-		// reserve last client ip address for multi-control-plane loadbalancer vip address in ha cluster
-		clientMaxIP := net.ParseIP(subnet.ClientMax)
-		clientMaxIP.To4()[3]--
-		subnet.ClientMax = clientMaxIP.String()
+		// rather than iterate through all the valid subnets, give up at 20 (by default) to avoid a lengthy user delay for something that is unlikely to work.
+		// will be like 192.168.39.0/24,..., 192.168.248.0/24 (in increment steps of 11)
+		var subnet, subnetV6 *Parameters
+		if subnetAddr != "" {
+			var s []SkippedSubnet
+			subnet, s, err = FindFreeSubnet(subnetAddr, n.step(false), n.tries(), sources...)
+			skipped = append(skipped, s...)
+			if err != nil {
+				l.Debugf("failed finding free subnet for private network %s after %d attempts: %v", n.Name, n.tries(), err)
+				return nil, fmt.Errorf("un-retryable: %w", err)
+			}
+			reserveVIP(subnet)
+		}
+		if subnetAddrV6 != "" {
+			var s []SkippedSubnet
+			subnetV6, s, err = FindFreeSubnet(subnetAddrV6, n.step(true), n.tries(), sources...)
+			skipped = append(skipped, s...)
+			if err != nil {
+				l.Debugf("failed finding free IPv6 subnet for private network %s after %d attempts: %v", n.Name, n.tries(), err)
+				return nil, fmt.Errorf("un-retryable: %w", err)
+			}
+			reserveVIP(subnetV6)
+		}
+		cidrs := joinCIDRs(subnet, subnetV6)
+		l = l.With(log.Fields{log.FieldSubnet: cidrs})
 
-		// create the XML for the private network from our networkTmpl
-		tryNet := libvirtNetwork{
-			Name:       n.Name,
-			Bridge:     n.Bridge,
-			Parameters: *subnet,
+		// record the subnets so other netctl processes keep away from them, even before the bridge is up
+		if err = c.reserveAllocation(n.Name, subnet, subnetV6); err != nil {
+			if !errors.Is(err, ipam.ErrConflict) {
+				return nil, err
+			}
+			l.Debugf("subnets %s of network %s were allocated meanwhile, will retry: %v", cidrs, n.Name, err)
+			subnetAddr, subnetAddrV6 = nextAddrs(subnet, subnetV6)
+			continue
 		}
-		tmpl := template.Must(template.New("network").Parse(config.NetworkTmpl))
-		var networkXML bytes.Buffer
-		if err = tmpl.Execute(&networkXML, tryNet); err != nil {
-			return fmt.Errorf("executing private network template: %w", err)
+		reserved = true
+
+		var networkXML string
+		if networkXML, err = render(subnet, subnetV6); err != nil {
+			return nil, err
 		}
 
 		// define the network using our template
-		log.Debugf("generated network template as XML:\n%s", networkXML.String())
-		libvirtNet, err := conn.NetworkDefineXML(networkXML.String())
-		if err != nil {
-			return fmt.Errorf("defining network %s %s from xml %s: %w", n.Name, subnet.CIDR, networkXML.String(), err)
+		l.Tracef("generated network template as XML:\n%s", networkXML)
+		if err := c.backend.DefineNetwork(networkXML); err != nil {
+			return nil, fmt.Errorf("defining network %s %s from xml %s: %w", n.Name, cidrs, networkXML, err)
 		}
 
 		// and finally create & start it
-		log.Debugf("creating network %s %s...", n.Name, subnet.CIDR)
-		if err = libvirtNet.Create(); err == nil {
-			log.Debugf("network %s %s created", n.Name, subnet.CIDR)
-			if netXML, err := libvirtNet.GetXMLDesc(0); err != nil {
-				log.Debugf("failed getting %s network XML: %v", n.Name, lvErr(err))
+		l.Debugf("creating network %s %s...", n.Name, cidrs)
+		if err = c.backend.CreateNetwork(n.Name); err == nil {
+			l.Debugf("network %s %s created", n.Name, cidrs)
+			if netXML, err := c.backend.NetworkXML(n.Name); err != nil {
+				l.Debugf("failed getting %s network XML: %v", n.Name, err)
 			} else {
-				log.Debugf("dumping network information as XML:\n%s", netXML)
+				l.Tracef("dumping network information as XML:\n%s", netXML)
 			}
 
-			return nil
+			created = true
+			return skipped, nil
 		}
-		log.Debugf("failed creating network %s %s, will retry: %v", n.Name, subnet.CIDR, err)
-		subnetAddr = subnet.IP
+		l.Debugf("failed creating network %s %s, will retry: %v", n.Name, cidrs, err)
+		subnetAddr, subnetAddrV6 = nextAddrs(subnet, subnetV6)
 	}
-	return fmt.Errorf("failed creating network %s: %w", n.Name, err)
+	return nil, fmt.Errorf("failed creating network %s: %w", n.Name, err)
 }
 
-func (n *Network) DeleteNetwork() error {
-	conn, err := getConnection(n.ConnectionURI)
-	if err != nil {
-		return fmt.Errorf("failed opening libvirt connection: %w", err)
+// subnetSources returns the sources of the subnets the network must not overlap with
+func (c *Client) subnetSources(n *Network) ([]ConflictSource, error) {
+	sources := conflictSources(c.backend)
+	for _, runtime := range n.ContainerRuntimes {
+		sources = append(sources, NewContainerNetworkSource(runtime))
 	}
-	defer func() {
-		if _, err := conn.Close(); err != nil {
-			log.Errorf("failed closing libvirt connection: %v", lvErr(err))
+	if c.ipam != nil {
+		uri, err := c.backend.URI()
+		if err != nil {
+			return nil, err
 		}
-	}()
+		sources = append(sources, NewIPAMSource(c.ipam, uri, n.Name))
+	}
+	return sources, nil
+}
 
-	log.Debugf("checking if network %s exists...", n.Name)
-	libvirtNet, err := conn.LookupNetworkByName(n.Name)
+// renderXML renders the libvirt XML of a new network with the given subnets, either of which may be nil
+func (n *Network) renderXML(subnet, subnetV6 *Parameters) (string, error) {
+	tryNet, err := n.libvirtNetwork(subnet, subnetV6)
 	if err != nil {
-		if lvErr(err).Code == libvirt.ERR_NO_NETWORK {
-			log.Warnf("network %s does not exist. Skipping deletion", n.Name)
-			return nil
+		return "", err
+	}
+	tryNet.Metadata = newMetadata(n.Labels).template()
+	return tryNet.render()
+}
+
+// libvirtNetwork returns the template data of the network with the given subnets, either of which may be nil,
+// their DHCP ranges set as requested
+func (n *Network) libvirtNetwork(subnet, subnetV6 *Parameters) (*libvirtNetwork, error) {
+	tryNet := &libvirtNetwork{
+		Name:            n.Name,
+		Bridge:          n.Bridge,
+		ForwardMode:     n.forwardMode(),
+		ForwardDev:      n.ForwardDev,
+		NATPortStart:    n.NATPortStart,
+		NATPortEnd:      n.NATPortEnd,
+		IPv6Mode:        n.ipv6Mode(),
+		DNS:             n.DNS,
+		DNSForwarders:   n.dnsForwarders(),
+		Domain:          n.Domain,
+		DomainLocalOnly: n.DomainLocalOnly,
+		Vars:            n.TemplateVars,
+	}
+	if n.Template != "" {
+		text, err := loadTemplate(n.Template)
+		if err != nil {
+			return nil, err
 		}
-		return errors.Wrapf(err, "failed looking up network %s", n.Name)
+		if tryNet.templatePath, err = filepath.Abs(n.Template); err != nil {
+			return nil, err
+		}
+		tryNet.template = text
 	}
-	defer func() {
-		if libvirtNet == nil {
-			log.Warnf("nil network, cannot free")
-		} else if err := libvirtNet.Free(); err != nil {
-			log.Errorf("failed freeing %s network: %v", n.Name, lvErr(err))
+	if subnet != nil {
+		tryNet.Parameters = *subnet
+		if err := setDHCPRange(&tryNet.Parameters, n.DHCPRange); err != nil {
+			return nil, err
 		}
-	}()
-
-	log.Debugf("network %s exists", n.Name)
+	}
+	if subnetV6 != nil {
+		params := *subnetV6
+		if err := setDHCPRange(&params, n.DHCPRangeV6); err != nil {
+			return nil, err
+		}
+		tryNet.ParametersV6 = &params
+	}
+	return tryNet, nil
+}
 
-	err = n.checkDomains(conn)
+// render executes the network template, checking the result is the XML of the network
+func (tryNet *libvirtNetwork) render() (string, error) {
+	text := config.NetworkTmpl
+	if tryNet.template != "" {
+		text = tryNet.template
+	}
+	tmpl, err := parseTemplate(text)
 	if err != nil {
-		return err
+		return "", err
+	}
+	var networkXML bytes.Buffer
+	if err := tmpl.Execute(&networkXML, tryNet); err != nil {
+		return "", fmt.Errorf("executing private network template: %w", err)
+	}
+	if err := tryNet.check(networkXML.String()); err != nil {
+		return "", fmt.Errorf("invalid network XML rendered by the template: %w", err)
 	}
+	if tryNet.template == "" || tryNet.Metadata == nil {
+		return networkXML.String(), nil
+	}
+	return tryNet.recordTemplate(networkXML.String())
+}
 
-	// when we reach this point, it means it is safe to delete the network
+// recordTemplate adds the custom template and its variables to the netctl metadata of the network XML rendered
+// with it, so the network can be rendered the same way again when updated
+func (tryNet *libvirtNetwork) recordTemplate(xmlString string) (string, error) {
+	var record strings.Builder
+	fmt.Fprintf(&record, "<template xmlns='%s' sha256='%s'>%s</template>",
+		config.MetadataNamespace, templateSHA256(tryNet.template), escapeXML(tryNet.templatePath))
+	for _, key := range slices.Sorted(maps.Keys(tryNet.Vars)) {
+		fmt.Fprintf(&record, "<templateVar xmlns='%s' key='%s'>%s</templateVar>",
+			config.MetadataNamespace, escapeXML(key), escapeXML(tryNet.Vars[key]))
+	}
 
-	log.Debugf("trying to delete network %s...", n.Name)
-	deleteFunc := func() error {
-		active, err := libvirtNet.IsActive()
+	// the record goes right before the end of the netctl element, keeping the XML as the template rendered it
+	dec := xml.NewDecoder(strings.NewReader(xmlString))
+	for {
+		offset := dec.InputOffset()
+		tok, err := dec.Token()
 		if err != nil {
-			return err
+			return "", fmt.Errorf("network has no netctl metadata: %w", err)
 		}
-		if active {
-			log.Debugf("destroying active network %s", n.Name)
-			if err := libvirtNet.Destroy(); err != nil {
-				return err
-			}
+		if end, ok := tok.(xml.EndElement); ok && end.Name.Space == config.MetadataNamespace && end.Name.Local == "network" {
+			return xmlString[:offset] + record.String() + xmlString[offset:], nil
 		}
-		log.Debugf("undefining inactive network %s", n.Name)
-		return libvirtNet.Undefine()
 	}
-	if err := util.LocalRetry(deleteFunc, 10*time.Second); err != nil {
-		return errors.Wrap(err, "deleting network")
-	}
-	log.Debugf("network %s deleted", n.Name)
+}
 
-	return nil
+// templateSHA256 returns the hex SHA-256 digest of a template, recorded to notice it changed
+func templateSHA256(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
 }
 
-func setupNetwork(conn *libvirt.Connect, name string) error {
-	n, err := conn.LookupNetworkByName(name)
+// check returns an error if xmlString is not the XML of a network named like tryNet, with its metadata and an ip
+// element for each of its subnets
+func (tryNet *libvirtNetwork) check(xmlString string) error {
+	var root struct {
+		XMLName xml.Name
+	}
+	if err := xml.Unmarshal([]byte(xmlString), &root); err != nil {
+		return err
+	}
+	if root.XMLName.Local != "network" {
+		return fmt.Errorf("root element is %s, want network", root.XMLName.Local)
+	}
+	v, err := parseNetworkXML(xmlString)
 	if err != nil {
-		return fmt.Errorf("failed looking up network %s: %w", name, lvErr(err))
+		return err
 	}
-	defer func() {
-		if n == nil {
-			log.Warn("nil network, cannot free")
-		} else if err := n.Free(); err != nil {
-			log.Errorf("failed freeing %s network: %v", name, lvErr(err))
+	if v.Name != tryNet.Name {
+		return fmt.Errorf("network is named %q, want %q", v.Name, tryNet.Name)
+	}
+	if tryNet.Metadata != nil && v.metadata() == nil {
+		return fmt.Errorf("network has no netctl metadata, so it would not be recognized as created by netctl")
+	}
+	for _, params := range []*Parameters{&tryNet.Parameters, tryNet.ParametersV6} {
+		if params == nil || params.Gateway == "" {
+			continue
 		}
-	}()
+		found := false
+		for _, ip := range v.IPs {
+			found = found || ip.Address == params.Gateway
+		}
+		if !found {
+			return fmt.Errorf("network has no ip element with address %s for subnet %s", params.Gateway, params.CIDR)
+		}
+	}
+	return nil
+}
 
-	// always ensure autostart is set on the network
-	autostart, err := n.GetAutostart()
+// loadTemplate reads the custom network template at path
+func loadTemplate(path string) (string, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		return errors.Wrapf(err, "checking network %s autostart", name)
+		return "", fmt.Errorf("failed reading network template: %w", err)
 	}
-	if !autostart {
-		if err := n.SetAutostart(true); err != nil {
-			return errors.Wrapf(err, "setting autostart for network %s", name)
-		}
+	return string(data), nil
+}
+
+// parseTemplate parses a network template, which can escape values with the xml function. Variables missing from
+// .Vars are errors rather than empty values
+func parseTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("network").Funcs(template.FuncMap{"xml": escapeXML}).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing network template: %w", err)
 	}
+	return tmpl, nil
+}
 
-	// always ensure the network is started (active)
-	active, err := n.IsActive()
+// setDHCPRange replaces the client range of the subnet with dhcpRange, if set. The free subnet search may have
+// moved away from the subnet the range was given in
+func setDHCPRange(subnet *Parameters, dhcpRange string) error {
+	if dhcpRange == "" {
+		return nil
+	}
+	start, end, err := parseDHCPRange(dhcpRange)
+	if err != nil {
+		return err
+	}
+	_, ipnet, err := net.ParseCIDR(subnet.CIDR)
 	if err != nil {
-		return errors.Wrapf(err, "checking network status for %s", name)
+		return err
 	}
+	if !ipnet.Contains(start) || !ipnet.Contains(end) {
+		return fmt.Errorf("DHCP range %s is outside subnet %s", dhcpRange, subnet.CIDR)
+	}
+	subnet.ClientMin, subnet.ClientMax = start.String(), end.String()
+	return nil
+}
 
-	if !active {
-		log.Debugf("network %s is not active, trying to start it...", name)
-		if err := n.Create(); err != nil {
-			return errors.Wrapf(err, "starting network %s", name)
+// nextAddrs returns where the next free subnet search starts from after the given subnets could not be used.
+// The subnets stay reserved by this process, so the search moves past them
+func nextAddrs(subnet, subnetV6 *Parameters) (string, string) {
+	var subnetAddr, subnetAddrV6 string
+	if subnet != nil {
+		subnetAddr = subnet.CIDR
+	}
+	if subnetV6 != nil {
+		subnetAddrV6 = subnetV6.CIDR
+	}
+	return subnetAddr, subnetAddrV6
+}
+
+// reserveVIP reserves the last client ip address for multi-control-plane loadbalancer vip address in ha cluster
+func reserveVIP(subnet *Parameters) {
+	clientMaxIP := net.ParseIP(subnet.ClientMax)
+	if clientMaxIP4 := clientMaxIP.To4(); clientMaxIP4 != nil {
+		clientMaxIP = clientMaxIP4
+	}
+	subnet.ClientMax = ipAdd(clientMaxIP, -1).String()
+}
+
+// joinCIDRs returns the CIDRs of the given subnets for logging, skipping nil ones
+func joinCIDRs(subnets ...*Parameters) string {
+	var cidrs []string
+	for _, subnet := range subnets {
+		if subnet != nil {
+			cidrs = append(cidrs, subnet.CIDR)
 		}
 	}
-	return nil
+	return strings.Join(cidrs, ",")
+}
+
+// DeleteOptions tunes how DeleteNetwork deletes a network
+type DeleteOptions struct {
+	// Force deletes the network even if it was not created by netctl
+	Force bool
+
+	// Cascade releases the domains using the network before deleting it: CascadeShutdown stops them and
+	// CascadeDetach removes their interfaces on the network. Empty refuses to delete a network in use
+	Cascade string
+
+	// How long CascadeShutdown waits for a domain to shut down gracefully before destroying it. Defaults to 2 minutes
+	ShutdownTimeout time.Duration
 }
 
-func getConnection(connectionURI string) (*libvirt.Connect, error) {
-	conn, err := libvirt.NewConnect(connectionURI)
+// DeleteNetwork deletes the named network if it is not used by any domain, or after releasing them as set by
+// opts.Cascade. Unless forced, networks not created by netctl are refused with ErrNotManaged. It returns the
+// addressing the network had
+func (c *Client) DeleteNetwork(ctx context.Context, name string, opts DeleteOptions) (*Result, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	l := log.With(log.Fields{log.FieldNetwork: name})
+	l.Debugf("checking if network %s exists...", name)
+	if _, err := c.backend.LookupNetwork(name); err != nil {
+		if errors.Is(err, ErrNetworkNotFound) {
+			l.Warnf("network %s does not exist. Skipping deletion", name)
+			return &Result{Name: name, Outcome: OutcomeAbsent}, c.releaseAllocation(name)
+		}
+		return nil, errors.Wrapf(err, "failed looking up network %s", name)
+	}
+
+	l.Debugf("network %s exists", name)
+
+	if !opts.Force {
+		if err := checkManaged(c.backend, name); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Cascade != "" {
+		if err := c.releaseDomains(ctx, name, opts); err != nil {
+			return nil, err
+		}
+	}
+	// domains stopped by the cascade keep referencing the network, libvirt only refuses to start them until it is back
+	if opts.Cascade != CascadeShutdown {
+		if err := c.checkDomains(ctx, name); err != nil {
+			return nil, err
+		}
+	}
+
+	// when we reach this point, it means it is safe to delete the network
+	r, err := networkResult(c.backend, name, OutcomeDeleted)
 	if err != nil {
-		return nil, fmt.Errorf("failed connecting to libvirt socket: %w", lvErr(err))
+		return nil, err
 	}
 
-	return conn, nil
+	l.Debugf("trying to delete network %s...", name)
+	deleteFunc := func() error {
+		state, err := c.backend.LookupNetwork(name)
+		if err != nil {
+			return err
+		}
+		if state.Active {
+			l.Debugf("destroying active network %s", name)
+			if err := c.backend.DestroyNetwork(name); err != nil {
+				return err
+			}
+		}
+		l.Debugf("undefining inactive network %s", name)
+		return c.backend.UndefineNetwork(name)
+	}
+	if err := util.LocalRetryWithContext(ctx, deleteFunc, 10*time.Second); err != nil {
+		return nil, errors.Wrap(err, "deleting network")
+	}
+	l.Debugf("network %s deleted", name)
+
+	if err := c.releaseAllocation(name); err != nil {
+		return nil, errors.Wrapf(err, "releasing IPAM allocation of network %s", name)
+	}
+
+	return r, nil
 }
 
-// lvErr will return libvirt Error struct containing specific libvirt error code, domain, message and level
-func lvErr(err error) libvirt.Error {
+// setupNetwork sets the autostart of the network as requested and starts it if it is inactive
+func setupNetwork(b Backend, name string, autostart bool) error {
+	state, err := b.LookupNetwork(name)
 	if err != nil {
-		if lverr, ok := err.(libvirt.Error); ok {
-			return lverr
+		return fmt.Errorf("failed looking up network %s: %w", name, err)
+	}
+
+	if state.Autostart != autostart {
+		if err := b.SetNetworkAutostart(name, autostart); err != nil {
+			return errors.Wrapf(err, "setting autostart for network %s", name)
 		}
-		return libvirt.Error{Code: libvirt.ERR_INTERNAL_ERROR, Message: "internal error"}
 	}
-	return libvirt.Error{Code: libvirt.ERR_OK, Message: ""}
+
+	// always ensure the network is started (active)
+	if !state.Active {
+		log.Debugf("network %s is not active, trying to start it...", name)
+		if err := b.CreateNetwork(name); err != nil {
+			return errors.Wrapf(err, "starting network %s", name)
+		}
+	}
+	return nil
 }
@@ -0,0 +1,384 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/juju/mutex/v2"
+	"github.com/pkg/errors"
+)
+
+type noopReleaser struct{}
+
+func (noopReleaser) Release() {}
+
+// stubSubnets makes every private subnet not used by a libvirt network look free for the duration of the test.
+// Like the real reservations, a subnet stays reserved until the end of the test once it has been handed out
+func stubSubnets(t *testing.T) {
+	t.Helper()
+	origTaken, origReserve, origSources := isSubnetTaken, reserveSubnet, conflictSources
+	reserved := map[string]bool{}
+	conflictSources = func(b Backend) []ConflictSource { return []ConflictSource{NewLibvirtNetworkSource(b)} }
+	isSubnetTaken = func(string) (bool, error) { return false, nil }
+	reserveSubnet = func(subnet string) (mutex.Releaser, error) {
+		if reserved[subnet] {
+			return nil, fmt.Errorf("subnet %s is already reserved", subnet)
+		}
+		reserved[subnet] = true
+		return noopReleaser{}, nil
+	}
+	t.Cleanup(func() {
+		isSubnetTaken, reserveSubnet, conflictSources = origTaken, origReserve, origSources
+	})
+}
+
+func testNetwork() *Network {
+	return &Network{Name: "test-net", Bridge: "virbr-test", Subnet: "192.168.123.1/24"}
+}
+
+const staleNetworkXML = `
+<network>
+  <name>test-net</name>
+  <bridge name='virbr-old'/>
+  <ip address='10.200.0.1' netmask='255.255.255.0'/>
+</network>`
+
+func domainOn(network string) string {
+	return `<domain><name>vm</name><devices><interface type='network'><mac address='52:54:00:00:00:01'/><source network='` + network + `'/></interface></devices></domain>`
+}
+
+func TestEnsureNetworkCreatesMissingNetwork(t *testing.T) {
+	stubSubnets(t)
+	b := NewFakeBackend()
+	c := NewClientWithBackend(b)
+
+	if _, err := c.EnsureNetwork(context.Background(), testNetwork()); err != nil {
+		t.Fatalf("EnsureNetwork() error = %v", err)
+	}
+
+	state, err := b.LookupNetwork("test-net")
+	if err != nil {
+		t.Fatalf("network was not defined: %v", err)
+	}
+	if !state.Active || !state.Autostart {
+		t.Errorf("network state = %+v, want active and autostarted", state)
+	}
+	xml, _ := b.NetworkXML("test-net")
+	if !strings.Contains(xml, "virbr-test") || !strings.Contains(xml, "192.168.123.1") {
+		t.Errorf("unexpected network XML:\n%s", xml)
+	}
+}
+
+func TestEnsureNetworkRecreatesInoperableNetwork(t *testing.T) {
+	stubSubnets(t)
+	b := NewFakeBackend()
+	if err := b.DefineNetwork(staleNetworkXML); err != nil {
+		t.Fatal(err)
+	}
+	// the stale definition can't be started, forcing the delete-and-recreate path
+	b.OnCreate = func(name string) error {
+		if strings.Contains(b.networks[name].xml, "virbr-old") {
+			return errors.New("bridge virbr-old is in use")
+		}
+		return nil
+	}
+	c := NewClientWithBackend(b)
+
+	r, err := c.EnsureNetwork(context.Background(), testNetwork())
+	if err != nil {
+		t.Fatalf("EnsureNetwork() error = %v", err)
+	}
+	if r.Outcome != OutcomeRecreated {
+		t.Errorf("EnsureNetwork() outcome = %s, want %s", r.Outcome, OutcomeRecreated)
+	}
+
+	if b.Calls["UndefineNetwork"] != 1 {
+		t.Errorf("UndefineNetwork called %d times, want 1", b.Calls["UndefineNetwork"])
+	}
+	xml, err := b.NetworkXML("test-net")
+	if err != nil {
+		t.Fatalf("network was not recreated: %v", err)
+	}
+	if strings.Contains(xml, "virbr-old") || !strings.Contains(xml, "virbr-test") {
+		t.Errorf("network was not redefined from the desired state:\n%s", xml)
+	}
+	if state, _ := b.LookupNetwork("test-net"); !state.Active {
+		t.Error("recreated network is not active")
+	}
+}
+
+func TestEnsureAndDeleteNetworkResults(t *testing.T) {
+	stubSubnets(t)
+	b := NewFakeBackend()
+	if err := b.DefineNetwork(`<network><name>other</name><ip address='192.168.123.1' netmask='255.255.255.0'/></network>`); err != nil {
+		t.Fatal(err)
+	}
+	c := NewClientWithBackend(b)
+	ctx := context.Background()
+
+	r, err := c.EnsureNetwork(ctx, testNetwork())
+	if err != nil {
+		t.Fatalf("EnsureNetwork() error = %v", err)
+	}
+	want := SubnetResult{CIDR: "192.168.134.0/24", Gateway: "192.168.134.1", DHCPStart: "192.168.134.2", DHCPEnd: "192.168.134.253", VIP: "192.168.134.254"}
+	if r.Outcome != OutcomeCreated || r.Bridge != "virbr-test" || r.Subnet == nil || *r.Subnet != want || r.SubnetV6 != nil {
+		t.Errorf("EnsureNetwork() = %+v (subnet %+v), want created with subnet %+v", r, r.Subnet, want)
+	}
+	if len(r.Skipped) != 1 || r.Skipped[0].CIDR != "192.168.123.0/24" {
+		t.Errorf("EnsureNetwork() skipped = %+v, want 192.168.123.0/24", r.Skipped)
+	}
+
+	if r, err = c.EnsureNetwork(ctx, testNetwork()); err != nil || r.Outcome != OutcomeReused || len(r.Skipped) != 0 {
+		t.Errorf("EnsureNetwork() again = %+v, %v, want reused", r, err)
+	}
+
+	r, err = c.DeleteNetwork(ctx, "test-net", DeleteOptions{})
+	if err != nil || r.Outcome != OutcomeDeleted || r.Subnet == nil || *r.Subnet != want {
+		t.Errorf("DeleteNetwork() = %+v, %v, want deleted with subnet %+v", r, err, want)
+	}
+	if r, err = c.DeleteNetwork(ctx, "test-net", DeleteOptions{}); err != nil || r.Outcome != OutcomeAbsent {
+		t.Errorf("DeleteNetwork() again = %+v, %v, want absent", r, err)
+	}
+}
+
+func TestEnsureNetworkKeepsInoperableNetworkInUse(t *testing.T) {
+	stubSubnets(t)
+	b := NewFakeBackend()
+	if err := b.DefineNetwork(staleNetworkXML); err != nil {
+		t.Fatal(err)
+	}
+	b.OnCreate = func(string) error { return errors.New("bridge virbr-old is in use") }
+	b.AddDomain("vm", domainOn("test-net"))
+	c := NewClientWithBackend(b)
+
+	_, err := c.EnsureNetwork(context.Background(), testNetwork())
+	if err == nil || !strings.Contains(err.Error(), "still in use by domains 'vm' (running)") {
+		t.Fatalf("EnsureNetwork() error = %v, want in use error", err)
+	}
+	if b.Calls["UndefineNetwork"] != 0 {
+		t.Error("network used by a domain was undefined")
+	}
+}
+
+func TestCreateNetworkRetriesFiveTimes(t *testing.T) {
+	stubSubnets(t)
+	b := NewFakeBackend()
+	b.OnCreate = func(string) error { return errors.New("address already in use") }
+	c := NewClientWithBackend(b)
+
+	_, err := c.createNetwork(context.Background(), testNetwork())
+	if err == nil {
+		t.Fatal("createNetwork() succeeded, want error")
+	}
+	if got := b.Calls["DefineNetwork"]; got != 5 {
+		t.Errorf("DefineNetwork called %d times, want 5", got)
+	}
+	if got := b.Calls["CreateNetwork"]; got != 5 {
+		t.Errorf("CreateNetwork called %d times, want 5", got)
+	}
+}
+
+func TestCreateNetworkStepsSubnetOnRetry(t *testing.T) {
+	stubSubnets(t)
+	b := NewFakeBackend()
+	failures := 2
+	b.OnCreate = func(string) error {
+		if failures > 0 {
+			failures--
+			return errors.New("address already in use")
+		}
+		return nil
+	}
+	c := NewClientWithBackend(b)
+
+	if _, err := c.createNetwork(context.Background(), testNetwork()); err != nil {
+		t.Fatalf("createNetwork() error = %v", err)
+	}
+	if got := b.Calls["CreateNetwork"]; got != 3 {
+		t.Errorf("CreateNetwork called %d times, want 3", got)
+	}
+	xml, _ := b.NetworkXML("test-net")
+	if strings.Contains(xml, "192.168.123.1'") {
+		t.Errorf("network kept the subnet that failed to start:\n%s", xml)
+	}
+}
+
+func TestDeleteNetworkRefusedWhileDomainsAttached(t *testing.T) {
+	b := NewFakeBackend()
+	if err := b.DefineNetwork(staleNetworkXML); err != nil {
+		t.Fatal(err)
+	}
+	b.AddDomain("vm", domainOn("test-net"))
+	c := NewClientWithBackend(b)
+
+	_, err := c.DeleteNetwork(context.Background(), "test-net", DeleteOptions{Force: true})
+	if err == nil || !strings.Contains(err.Error(), "'vm'") {
+		t.Fatalf("DeleteNetwork() error = %v, want in use error", err)
+	}
+	if _, err := b.LookupNetwork("test-net"); err != nil {
+		t.Errorf("network in use was deleted: %v", err)
+	}
+}
+
+func TestDeleteNetworkSkipsMissingNetwork(t *testing.T) {
+	b := NewFakeBackend()
+	c := NewClientWithBackend(b)
+
+	if _, err := c.DeleteNetwork(context.Background(), "test-net", DeleteOptions{}); err != nil {
+		t.Fatalf("DeleteNetwork() error = %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		network Network
+		wantErr bool
+	}{
+		{name: "isolated", network: Network{}},
+		{name: "name with XML characters", network: Network{Name: "lab's <net> & co", Bridge: "virbr-lab&1"}},
+		{name: "name with slash", network: Network{Name: "lab/1"}, wantErr: true},
+		{name: "bridge too long", network: Network{Bridge: "virbr-lab-cluster-1"}, wantErr: true},
+		{name: "bridge with space", network: Network{Bridge: "virbr lab"}, wantErr: true},
+		{name: "forward device with colon", network: Network{ForwardMode: ForwardModeNAT, ForwardDev: "eth0:1"}, wantErr: true},
+		{name: "none", network: Network{ForwardMode: ForwardModeNone}},
+		{name: "nat with device and ports", network: Network{ForwardMode: ForwardModeNAT, ForwardDev: "eth0", NATPortStart: 1024, NATPortEnd: 65535}},
+		{name: "route with device", network: Network{ForwardMode: ForwardModeRoute, ForwardDev: "eth0"}},
+		{name: "open", network: Network{ForwardMode: ForwardModeOpen}},
+		{name: "unknown mode", network: Network{ForwardMode: "bridge"}, wantErr: true},
+		{name: "device without mode", network: Network{ForwardDev: "eth0"}, wantErr: true},
+		{name: "open with device", network: Network{ForwardMode: ForwardModeOpen, ForwardDev: "eth0"}, wantErr: true},
+		{name: "ports without nat", network: Network{ForwardMode: ForwardModeRoute, NATPortStart: 1024, NATPortEnd: 2048}, wantErr: true},
+		{name: "reversed ports", network: Network{ForwardMode: ForwardModeNAT, NATPortStart: 2048, NATPortEnd: 1024}, wantErr: true},
+		{name: "missing end port", network: Network{ForwardMode: ForwardModeNAT, NATPortStart: 1024}, wantErr: true},
+		{name: "dual-stack", network: Network{Subnet: "10.89.0.1/24", SubnetV6: "fd00:89::/64", IPv6Mode: IPv6ModeRA}},
+		{name: "IPv6 as IPv4 subnet", network: Network{Subnet: "fd00:89::/64"}, wantErr: true},
+		{name: "IPv4 as IPv6 subnet", network: Network{SubnetV6: "10.89.0.1/24"}, wantErr: true},
+		{name: "router advertisements on non /64", network: Network{SubnetV6: "fd00:89::/80", IPv6Mode: IPv6ModeRA}, wantErr: true},
+		{name: "IPv6 mode without IPv6 subnet", network: Network{Subnet: "10.89.0.1/24", IPv6Mode: IPv6ModeDHCP}, wantErr: true},
+		{name: "DNS", network: Network{DNS: true, Domain: "lab.local", DomainLocalOnly: true, DNSForwarders: []string{"1.1.1.1", "corp.example.com=10.0.0.53"}}},
+		{name: "invalid domain", network: Network{DNS: true, Domain: "lab_1"}, wantErr: true},
+		{name: "local only without domain", network: Network{DNS: true, DomainLocalOnly: true}, wantErr: true},
+		{name: "forwarders without DNS", network: Network{DNSForwarders: []string{"1.1.1.1"}}, wantErr: true},
+		{name: "invalid forwarder", network: Network{DNS: true, DNSForwarders: []string{"corp.example.com=dns"}}, wantErr: true},
+		{name: "DHCP ranges", network: Network{Subnet: "10.89.0.1/24", DHCPRange: "10.89.0.100-10.89.0.200", SubnetV6: "fd00:89::/64", DHCPRangeV6: "fd00:89::100-fd00:89::1ff"}},
+		{name: "DHCP range outside subnet", network: Network{Subnet: "10.89.0.1/24", DHCPRange: "10.89.1.100-10.89.1.200"}, wantErr: true},
+		{name: "reversed DHCP range", network: Network{Subnet: "10.89.0.1/24", DHCPRange: "10.89.0.200-10.89.0.100"}, wantErr: true},
+		{name: "DHCP range without subnet", network: Network{DHCPRange: "10.89.0.100-10.89.0.200"}, wantErr: true},
+		{name: "IPv6 DHCP range as IPv4", network: Network{Subnet: "10.89.0.1/24", DHCPRange: "fd00:89::100-fd00:89::1ff"}, wantErr: true},
+		{name: "DHCPv6 range with router advertisements", network: Network{SubnetV6: "fd00:89::/64", IPv6Mode: IPv6ModeRA, DHCPRangeV6: "fd00:89::100-fd00:89::1ff"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.network.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCreateNetworkRendersForwardMode(t *testing.T) {
+	stubSubnets(t)
+	b := NewFakeBackend()
+	c := NewClientWithBackend(b)
+	n := testNetwork()
+	n.ForwardMode = ForwardModeNAT
+	n.ForwardDev = "eth0"
+	n.NATPortStart, n.NATPortEnd = 1024, 65535
+
+	if _, err := c.createNetwork(context.Background(), n); err != nil {
+		t.Fatalf("createNetwork() error = %v", err)
+	}
+	xml, _ := b.NetworkXML("test-net")
+	for _, want := range []string{"<forward mode='nat' dev='eth0'>", "<port start='1024' end='65535'/>"} {
+		if !strings.Contains(xml, want) {
+			t.Errorf("network XML does not contain %s:\n%s", want, xml)
+		}
+	}
+}
+
+func TestCreateNetworkEscapesValues(t *testing.T) {
+	stubSubnets(t)
+	b := NewFakeBackend()
+	c := NewClientWithBackend(b)
+	n := testNetwork()
+	n.Name, n.Bridge = "lab's <net> & co", "virbr-'lab'"
+
+	if _, err := c.createNetwork(context.Background(), n); err != nil {
+		t.Fatalf("createNetwork() error = %v", err)
+	}
+	xml, _ := b.NetworkXML(n.Name)
+	v, err := parseNetworkXML(xml)
+	if err != nil || v.Name != n.Name || v.Bridge.Name != n.Bridge {
+		t.Errorf("network XML = %+v, %v, want name %q and bridge %q:\n%s", v, err, n.Name, n.Bridge, xml)
+	}
+}
+
+func TestCreateNetworkDualStack(t *testing.T) {
+	stubSubnets(t)
+	b := NewFakeBackend()
+	c := NewClientWithBackend(b)
+	n := testNetwork()
+	n.SubnetV6 = "fd00:123::/64"
+
+	if _, err := c.createNetwork(context.Background(), n); err != nil {
+		t.Fatalf("createNetwork() error = %v", err)
+	}
+	xml, _ := b.NetworkXML("test-net")
+	for _, want := range []string{
+		"<ip address='192.168.123.1' netmask='255.255.255.0'>",
+		"<ip family='ipv6' address='fd00:123::1' prefix='64'>",
+		"<range start='fd00:123::2' end='fd00:123::fffe'/>",
+	} {
+		if !strings.Contains(xml, want) {
+			t.Errorf("network XML does not contain %s:\n%s", want, xml)
+		}
+	}
+
+	d, err := c.Inspect(context.Background(), "test-net")
+	if err != nil {
+		t.Fatalf("Inspect() error = %v", err)
+	}
+	if d.ParametersV6 == nil || d.ParametersV6.CIDR != "fd00:123::/64" || d.VIPv6 != "fd00:123::ffff" || d.IPv6Mode != IPv6ModeDHCP {
+		t.Errorf("Inspect() IPv6 = %+v, VIP %s, mode %s", d.ParametersV6, d.VIPv6, d.IPv6Mode)
+	}
+}
+
+func TestCustomDHCPRangeHasNoVIP(t *testing.T) {
+	stubSubnets(t)
+	c := NewClientWithBackend(NewFakeBackend())
+	n := testNetwork()
+	n.DHCPRange = "192.168.123.100-192.168.123.200"
+
+	r, err := c.EnsureNetwork(context.Background(), n)
+	if err != nil {
+		t.Fatalf("EnsureNetwork() error = %v", err)
+	}
+	if r.Subnet == nil || r.Subnet.DHCPEnd != "192.168.123.200" || r.Subnet.VIP != "" {
+		t.Errorf("EnsureNetwork() subnet = %+v, want no VIP", r.Subnet)
+	}
+	d, err := c.Inspect(context.Background(), "test-net")
+	if err != nil {
+		t.Fatalf("Inspect() error = %v", err)
+	}
+	if d.VIP != "" {
+		t.Errorf("Inspect() VIP = %s, want none", d.VIP)
+	}
+}
+
+func TestCreateNetworkIPv6RouterAdvertisementsOnly(t *testing.T) {
+	stubSubnets(t)
+	b := NewFakeBackend()
+	c := NewClientWithBackend(b)
+	n := &Network{Name: "test-net", Bridge: "virbr-test", SubnetV6: "fd00:123::/64", IPv6Mode: IPv6ModeRA}
+
+	if _, err := c.createNetwork(context.Background(), n); err != nil {
+		t.Fatalf("createNetwork() error = %v", err)
+	}
+	xml, _ := b.NetworkXML("test-net")
+	if strings.Contains(xml, "<dhcp>") || strings.Contains(xml, "netmask=") {
+		t.Errorf("IPv6-only RA network XML has DHCP or IPv4 elements:\n%s", xml)
+	}
+}
@@ -0,0 +1,131 @@
+package network
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/day0ops/netctl/pkg/config"
+)
+
+// CreatePlan is what EnsureNetwork would do to a network, see PlanCreate
+type CreatePlan struct {
+	Result `yaml:",inline"`
+	// Start is set if the existing network is inactive, and would be started (or recreated if that fails)
+	Start bool   `json:"start,omitempty" yaml:"start,omitempty"`
+	XML   string `json:"xml" yaml:"xml"` // rendered for a new network, as defined for an existing one
+}
+
+// DeletePlan is what DeleteNetwork would do to a network, see PlanDelete
+type DeletePlan struct {
+	Result  `yaml:",inline"`
+	Active  bool              `json:"active" yaml:"active"` // whether the network would be stopped
+	Cascade string            `json:"cascade,omitempty" yaml:"cascade,omitempty"`
+	Domains []DomainInterface `json:"domains,omitempty" yaml:"domains,omitempty"` // released by the cascade
+}
+
+// PlanCreate returns what EnsureNetwork would do to the network without changing anything: for a new network, the
+// free subnets it would get (not holding their reservation afterwards), the subnets skipped and why, and its rendered
+// XML. The subnets may be taken by the time the network is actually created
+func (c *Client) PlanCreate(ctx context.Context, n *Network) (*CreatePlan, error) {
+	if n.Name == config.DefaultPrivateMinikubeNetworkName {
+		return nil, fmt.Errorf("network can't be named %s. This is the name of the private network created by minikube by default", config.DefaultPrivateMinikubeNetworkName)
+	}
+	if err := n.Validate(); err != nil {
+		return nil, err
+	}
+	if n.Subnet == "" && n.SubnetV6 == "" {
+		return nil, fmt.Errorf("network %s needs an IPv4 or an IPv6 subnet", n.Name)
+	}
+
+	state, err := c.backend.LookupNetwork(n.Name)
+	if err == nil {
+		xmlString, err := c.backend.NetworkXML(n.Name)
+		if err != nil {
+			return nil, err
+		}
+		r, err := resultFromXML(n.Name, OutcomeReused, xmlString)
+		if err != nil {
+			return nil, err
+		}
+		return &CreatePlan{Result: *r, Start: !state.Active, XML: xmlString}, nil
+	}
+	if !errors.Is(err, ErrNetworkNotFound) {
+		return nil, errors.Wrapf(err, "failed looking up network %s", n.Name)
+	}
+
+	sources, err := c.subnetSources(n)
+	if err != nil {
+		return nil, err
+	}
+	var skipped []SkippedSubnet
+	findSubnet := func(startSubnet string, ipv6 bool) (*Parameters, error) {
+		if startSubnet == "" {
+			return nil, nil
+		}
+		if err := checkContext(ctx); err != nil {
+			return nil, err
+		}
+		subnet, s, err := FindFreeSubnet(startSubnet, n.step(ipv6), n.tries(), sources...)
+		skipped = append(skipped, s...)
+		if err != nil {
+			return nil, err
+		}
+		subnet.reservation.Release()
+		reserveVIP(subnet)
+		return subnet, nil
+	}
+	subnet, err := findSubnet(n.Subnet, false)
+	if err != nil {
+		return nil, err
+	}
+	subnetV6, err := findSubnet(n.SubnetV6, true)
+	if err != nil {
+		return nil, err
+	}
+
+	xmlString, err := n.renderXML(subnet, subnetV6)
+	if err != nil {
+		return nil, err
+	}
+	r, err := resultFromXML(n.Name, OutcomeCreated, xmlString)
+	if err != nil {
+		return nil, err
+	}
+	r.Skipped = skipped
+	return &CreatePlan{Result: *r, XML: xmlString}, nil
+}
+
+// PlanDelete returns what DeleteNetwork would do to the named network without changing anything, failing like it
+// would if the network was not created by netctl (unless forced) or is in use without a cascade
+func (c *Client) PlanDelete(ctx context.Context, name string, opts DeleteOptions) (*DeletePlan, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	state, err := c.backend.LookupNetwork(name)
+	if errors.Is(err, ErrNetworkNotFound) {
+		return &DeletePlan{Result: Result{Name: name, Outcome: OutcomeAbsent}}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed looking up network %s", name)
+	}
+	if !opts.Force {
+		if err := checkManaged(c.backend, name); err != nil {
+			return nil, err
+		}
+	}
+
+	r, err := networkResult(c.backend, name, OutcomeDeleted)
+	if err != nil {
+		return nil, err
+	}
+	ifaces, err := c.DomainsUsing(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(ifaces) > 0 && opts.Cascade == "" {
+		return nil, &DomainsInUseError{Network: name, Domains: ifaces}
+	}
+	return &DeletePlan{Result: *r, Active: state.Active, Cascade: opts.Cascade, Domains: ifaces}, nil
+}
@@ -0,0 +1,70 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPlanCreate(t *testing.T) {
+	stubSubnets(t)
+	b := NewFakeBackend()
+	if err := b.DefineNetwork(`<network><name>other</name><ip address='192.168.123.1' netmask='255.255.255.0'/></network>`); err != nil {
+		t.Fatal(err)
+	}
+	c := NewClientWithBackend(b)
+
+	p, err := c.PlanCreate(context.Background(), testNetwork())
+	if err != nil {
+		t.Fatalf("PlanCreate() error = %v", err)
+	}
+	if p.Outcome != OutcomeCreated || p.Subnet == nil || p.Subnet.CIDR != "192.168.134.0/24" || p.Subnet.VIP != "192.168.134.254" {
+		t.Errorf("PlanCreate() = %+v (subnet %+v), want to create 192.168.134.0/24", p.Result, p.Subnet)
+	}
+	if len(p.Skipped) != 1 || !strings.Contains(p.Skipped[0].Reason, "used by libvirt network other") {
+		t.Errorf("PlanCreate() skipped = %+v, want 192.168.123.0/24 used by other", p.Skipped)
+	}
+	if !strings.Contains(p.XML, "<bridge name='virbr-test'") || !strings.Contains(p.XML, "192.168.134.1") {
+		t.Errorf("PlanCreate() XML = %s", p.XML)
+	}
+	if b.Calls["DefineNetwork"] != 1 || b.Calls["CreateNetwork"] != 0 {
+		t.Errorf("PlanCreate() changed the backend: %v", b.Calls)
+	}
+
+	if _, err := c.EnsureNetwork(context.Background(), testNetwork()); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.DestroyNetwork("test-net"); err != nil {
+		t.Fatal(err)
+	}
+	if p, err = c.PlanCreate(context.Background(), testNetwork()); err != nil || p.Outcome != OutcomeReused || !p.Start {
+		t.Errorf("PlanCreate() of an inactive network = %+v, %v, want to start it", p, err)
+	}
+}
+
+func TestPlanDelete(t *testing.T) {
+	b, c := createTestNetwork(t)
+	b.AddDomain("vm", domainOn("test-net"))
+	ctx := context.Background()
+
+	var inUse *DomainsInUseError
+	if _, err := c.PlanDelete(ctx, "test-net", DeleteOptions{}); !errors.As(err, &inUse) {
+		t.Errorf("PlanDelete() error = %v, want in use error", err)
+	}
+
+	p, err := c.PlanDelete(ctx, "test-net", DeleteOptions{Cascade: CascadeShutdown})
+	if err != nil {
+		t.Fatalf("PlanDelete() error = %v", err)
+	}
+	if p.Outcome != OutcomeDeleted || !p.Active || len(p.Domains) != 1 || p.Domains[0].Domain != "vm" || p.Subnet == nil {
+		t.Errorf("PlanDelete() = %+v, want to delete the active network and shut down vm", p)
+	}
+	if b.Calls["ShutdownDomain"] != 0 || b.Calls["DestroyNetwork"] != 0 || b.Calls["UndefineNetwork"] != 0 {
+		t.Errorf("PlanDelete() changed the backend: %v", b.Calls)
+	}
+
+	if p, err = c.PlanDelete(ctx, "missing", DeleteOptions{}); err != nil || p.Outcome != OutcomeAbsent {
+		t.Errorf("PlanDelete(missing) = %+v, %v, want absent", p, err)
+	}
+}
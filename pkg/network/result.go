@@ -0,0 +1,68 @@
+package network
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Outcomes of EnsureNetwork and DeleteNetwork
+const (
+	OutcomeCreated   = "created"
+	OutcomeReused    = "reused" // the network was already set up
+	OutcomeRecreated = "recreated"
+	OutcomeDeleted   = "deleted"
+	OutcomeAbsent    = "absent" // the network to delete did not exist
+)
+
+// Result is what EnsureNetwork or DeleteNetwork did to a network, along with its addressing (before deletion)
+type Result struct {
+	Name     string          `json:"name" yaml:"name"`
+	Outcome  string          `json:"outcome" yaml:"outcome"`
+	Bridge   string          `json:"bridge,omitempty" yaml:"bridge,omitempty"`
+	Subnet   *SubnetResult   `json:"subnet,omitempty" yaml:"subnet,omitempty"`
+	SubnetV6 *SubnetResult   `json:"subnetV6,omitempty" yaml:"subnetV6,omitempty"`
+	Skipped  []SkippedSubnet `json:"skipped,omitempty" yaml:"skipped,omitempty"` // subnets passed over while creating it
+}
+
+// SubnetResult is the addressing of a subnet of a network
+type SubnetResult struct {
+	CIDR      string `json:"cidr" yaml:"cidr"`
+	Gateway   string `json:"gateway" yaml:"gateway"`
+	DHCPStart string `json:"dhcpStart,omitempty" yaml:"dhcpStart,omitempty"`
+	DHCPEnd   string `json:"dhcpEnd,omitempty" yaml:"dhcpEnd,omitempty"`
+	VIP       string `json:"vip,omitempty" yaml:"vip,omitempty"` // address reserved for the multi-control-plane loadbalancer
+}
+
+// networkResult describes the named network as it is defined now
+func networkResult(b Backend, name, outcome string) (*Result, error) {
+	xmlString, err := b.NetworkXML(name)
+	if err != nil {
+		return nil, err
+	}
+	return resultFromXML(name, outcome, xmlString)
+}
+
+// resultFromXML describes the named network defined by xmlString
+func resultFromXML(name, outcome, xmlString string) (*Result, error) {
+	v, err := parseNetworkXML(xmlString)
+	if err != nil {
+		return nil, errors.Wrapf(err, "network '%s'", name)
+	}
+
+	r := &Result{Name: name, Outcome: outcome, Bridge: v.Bridge.Name}
+	for _, ip := range v.IPs {
+		params, err := parametersFromXML(ip)
+		if err != nil {
+			return nil, errors.Wrapf(err, "network '%s'", name)
+		}
+		s := &SubnetResult{CIDR: params.CIDR, Gateway: params.Gateway}
+		if ip.DHCP != nil {
+			s.DHCPStart, s.DHCPEnd, s.VIP = params.ClientMin, params.ClientMax, reservedVIP(params)
+		}
+		if params.IsIPv6 && r.SubnetV6 == nil {
+			r.SubnetV6 = s
+		} else if !params.IsIPv6 && r.Subnet == nil {
+			r.Subnet = s
+		}
+	}
+	return r, nil
+}
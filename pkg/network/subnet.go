@@ -1,8 +1,9 @@
 package network
 
 import (
-	"encoding/binary"
+	"bytes"
 	"fmt"
+	"math/big"
 	"net"
 	"time"
 
@@ -14,68 +15,93 @@ import (
 
 // Interface contains main network interface parameters.
 type Interface struct {
-	IfaceName string
-	IfaceIPv4 string
-	IfaceMTU  int
-	IfaceMAC  string
+	IfaceName string `json:"ifaceName,omitempty" yaml:"ifaceName,omitempty"`
+	IfaceIPv4 string `json:"ifaceIPv4,omitempty" yaml:"ifaceIPv4,omitempty"`
+	IfaceIPv6 string `json:"ifaceIPv6,omitempty" yaml:"ifaceIPv6,omitempty"`
+	IfaceMTU  int    `json:"ifaceMTU,omitempty" yaml:"ifaceMTU,omitempty"`
+	IfaceMAC  string `json:"ifaceMAC,omitempty" yaml:"ifaceMAC,omitempty"`
 }
 
 // Parameters contains main network parameters.
 type Parameters struct {
-	IP        string // IP address of network
-	Netmask   string // dotted-decimal format ('a.b.c.d')
-	Prefix    int    // network prefix length (number of leading ones in network mask)
-	CIDR      string // CIDR format ('a.b.c.d/n')
-	Gateway   string // taken from network interface address or assumed as first network IP address from given addr
-	ClientMin string // first available client IP address after gateway
-	ClientMax string // last available client IP address before broadcast
-	Broadcast string // last network IP address
-	IsPrivate bool   // whether the IP is private or not
-	Interface
+	IP          string `json:"ip" yaml:"ip"`               // IP address of network
+	IsIPv6      bool   `json:"isIPv6" yaml:"isIPv6"`       // whether this is an IPv6 network
+	Netmask     string `json:"netmask" yaml:"netmask"`     // dotted-decimal format ('a.b.c.d') for IPv4, colon-hexadecimal for IPv6
+	Prefix      int    `json:"prefix" yaml:"prefix"`       // network prefix length (number of leading ones in network mask)
+	CIDR        string `json:"cidr" yaml:"cidr"`           // CIDR format ('a.b.c.d/n')
+	Gateway     string `json:"gateway" yaml:"gateway"`     // taken from network interface address or assumed as first network IP address from given addr
+	ClientMin   string `json:"clientMin" yaml:"clientMin"` // first available client IP address after gateway
+	ClientMax   string `json:"clientMax" yaml:"clientMax"` // last available client IP address before broadcast (IPv6: capped to the range size libvirt accepts)
+	Broadcast   string `json:"broadcast" yaml:"broadcast"` // last network IP address
+	IsPrivate   bool   `json:"isPrivate" yaml:"isPrivate"` // whether the IP is private or not
+	Interface   `yaml:",inline"`
 	reservation mutex.Releaser // subnet reservation has lifespan of the process: "If a process dies while the mutex is held, the mutex is automatically released."
 }
 
+// SkippedSubnet is a subnet passed over by the free subnet search
+type SkippedSubnet struct {
+	CIDR   string `json:"cidr" yaml:"cidr"`
+	Reason string `json:"reason" yaml:"reason"`
+}
+
 // FreeSubnet will try to find free private network beginning with startSubnet, incrementing it in steps up to number of tries.
-func FreeSubnet(startSubnet string, step, tries int) (*Parameters, error) {
-	currSubnet := startSubnet
+// The prefix length of startSubnet is preserved and every step moves to the next aligned block of that size,
+// for e.g. 172.20.5.0/22 with a step of 1 is followed by 172.20.8.0/22.
+// Besides the local network interfaces, subnets overlapping with any used by the given sources are skipped.
+func FreeSubnet(startSubnet string, step, tries int, sources ...ConflictSource) (*Parameters, error) {
+	n, _, err := FindFreeSubnet(startSubnet, step, tries, sources...)
+	return n, err
+}
+
+// FindFreeSubnet is FreeSubnet, also returning the subnets that were skipped and why
+func FindFreeSubnet(startSubnet string, step, tries int, sources ...ConflictSource) (*Parameters, []SkippedSubnet, error) {
+	currSubnet, err := subnetCIDR(startSubnet)
+	if err != nil {
+		return nil, nil, err
+	}
+	used, err := collectUsedSubnets(sources)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var skipped []SkippedSubnet
+	skip := func(n *Parameters, reason string) {
+		log.With(log.Fields{log.FieldSubnet: n.CIDR}).Infof("skipping subnet %s that %s", n.CIDR, reason)
+		skipped = append(skipped, SkippedSubnet{CIDR: n.CIDR, Reason: reason})
+	}
 	for try := 0; try < tries; try++ {
 		n, err := inspect(currSubnet)
 		if err != nil {
-			return nil, err
+			return nil, skipped, err
 		}
 		subnet := n.IP
 		if n.IsPrivate {
 			taken, err := isSubnetTaken(subnet)
 			if err != nil {
-				return nil, err
+				return nil, skipped, err
 			}
-			if !taken {
-				if reservation, err := reserveSubnet(subnet); err == nil {
-					n.reservation = reservation
-					log.Infof("using free subnet %s: %+v", n.CIDR, n)
-					return n, nil
-				}
-				log.Infof("skipping subnet %s that is reserved: %+v", n.CIDR, n)
+			_, network, _ := net.ParseCIDR(n.CIDR)
+			if taken {
+				skip(n, "is taken by a local network interface")
+			} else if u, conflict := overlaps(network, used); conflict {
+				skip(n, fmt.Sprintf("overlaps with %s used by %s", u.Subnet, u.Owner))
+			} else if reservation, err := reserveSubnet(subnet); err == nil {
+				n.reservation = reservation
+				log.With(log.Fields{log.FieldSubnet: n.CIDR}).Infof("using free subnet %s: %+v", n.CIDR, n)
+				return n, skipped, nil
 			} else {
-				log.Infof("skipping subnet %s that is taken: %+v", n.CIDR, n)
+				skip(n, "is reserved")
 			}
 		} else {
-			log.Infof("skipping subnet %s that is not private", n.CIDR)
+			skip(n, "is not private")
 		}
-		prefix, _ := net.ParseIP(n.IP).DefaultMask().Size()
-		nextSubnet := net.ParseIP(currSubnet).To4()
-		if prefix <= 16 {
-			nextSubnet[1] += byte(step)
-		} else {
-			nextSubnet[2] += byte(step)
-		}
-		currSubnet = nextSubnet.String()
+		currSubnet = stepSubnet(currSubnet, step)
 	}
-	return nil, fmt.Errorf("no free private network subnets found with given parameters (start: %q, step: %d, tries: %d)", startSubnet, step, tries)
+	return nil, skipped, fmt.Errorf("no free private network subnets found with given parameters (start: %q, step: %d, tries: %d)", startSubnet, step, tries)
 }
 
-// inspect initialises IPv4 network parameters struct from given address addr.
-// addr can be single address (like "192.168.17.42"), network address (like "192.168.17.0") or in CIDR form (like "192.168.17.42/24 or "192.168.17.0/24").
+// inspect initialises IPv4 or IPv6 network parameters struct from given address addr.
+// addr can be single address (like "192.168.17.42"), network address (like "192.168.17.0") or in CIDR form (like "192.168.17.42/24 or "fd00:17::/64").
 // If addr belongs to network of local network interface, parameters will also contain info about that network interface.
 var inspect = func(addr string) (*Parameters, error) {
 	// extract ip from addr
@@ -83,6 +109,9 @@ var inspect = func(addr string) (*Parameters, error) {
 	if err != nil {
 		return nil, err
 	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
 
 	n := &Parameters{}
 
@@ -97,9 +126,13 @@ var inspect = func(addr string) (*Parameters, error) {
 
 	// couldn't determine network parameters from addr nor from network interfaces
 	if network == nil {
+		mask := ip.DefaultMask() // assume default network mask
+		if mask == nil {
+			mask = net.CIDRMask(defaultIPv6Prefix, 8*net.IPv6len) // IPv6 has no classful masks, assume the usual /64
+		}
 		ipnet := &net.IPNet{
 			IP:   ip,
-			Mask: ip.DefaultMask(), // assume default network mask
+			Mask: mask,
 		}
 		_, network, err = net.ParseCIDR(ipnet.String())
 		if err != nil {
@@ -108,38 +141,121 @@ var inspect = func(addr string) (*Parameters, error) {
 	}
 
 	n.IP = network.IP.String()
+	n.IsIPv6 = network.IP.To4() == nil
 	n.Netmask = net.IP(network.Mask).String() // dotted-decimal format ('a.b.c.d')
 	n.Prefix, _ = network.Mask.Size()
 	n.CIDR = network.String()
 	n.IsPrivate = network.IP.IsPrivate()
 
-	networkIP := binary.BigEndian.Uint32(network.IP)                      // IP address of network
-	networkMask := binary.BigEndian.Uint32(network.Mask)                  // network mask
-	broadcastIP := (networkIP & networkMask) | (networkMask ^ 0xffffffff) // last network IP address
-
-	broadcast := make(net.IP, 4)
-	binary.BigEndian.PutUint32(broadcast, broadcastIP)
+	broadcast := lastAddr(network) // last network IP address
 	n.Broadcast = broadcast.String()
 
-	gateway := net.ParseIP(n.Gateway).To4() // has to be converted to 4-byte representation!
+	gateway := net.ParseIP(n.Gateway)
 	if gateway == nil {
-		gateway = make(net.IP, 4)
-		binary.BigEndian.PutUint32(gateway, networkIP+1) // assume first network IP address
+		gateway = ipAdd(network.IP, 1) // assume first network IP address
 		n.Gateway = gateway.String()
+	} else if gateway4 := gateway.To4(); gateway4 != nil {
+		gateway = gateway4 // has to be converted to 4-byte representation!
 	}
-	gatewayIP := binary.BigEndian.Uint32(gateway)
 
-	minIP := make(net.IP, 4)
-	binary.BigEndian.PutUint32(minIP, gatewayIP+1) // clients-from: first network IP address after gateway
-	n.ClientMin = minIP.String()
+	n.ClientMin = ipAdd(gateway, 1).String() // clients-from: first network IP address after gateway
 
-	maxIP := make(net.IP, 4)
-	binary.BigEndian.PutUint32(maxIP, broadcastIP-1) // clients-to: last network IP address before broadcast
+	maxIP := ipAdd(broadcast, -1) // clients-to: last network IP address before broadcast
+	if n.IsIPv6 {
+		// libvirt refuses DHCP ranges larger than 65535 addresses
+		if limit := ipAdd(network.IP, maxIPv6RangeSize); bytes.Compare(limit, maxIP) < 0 {
+			maxIP = limit
+		}
+	}
 	n.ClientMax = maxIP.String()
 
 	return n, nil
 }
 
+const (
+	// defaultIPv6Prefix is the prefix length assumed for IPv6 addresses given without one
+	defaultIPv6Prefix = 64
+	// maxIPv6RangeSize is the largest offset from the network address libvirt accepts for the end of a DHCPv6 range
+	maxIPv6RangeSize = 0xffff
+)
+
+// ipAdd returns ip offset by delta addresses, wrapping around within the address family
+func ipAdd(ip net.IP, delta int64) net.IP {
+	v := new(big.Int).SetBytes(ip)
+	v.Add(v, big.NewInt(delta))
+	v.Mod(v, new(big.Int).Lsh(big.NewInt(1), uint(8*len(ip))))
+	out := make(net.IP, len(ip))
+	v.FillBytes(out)
+	return out
+}
+
+// lastAddr returns the last address of network (the broadcast address for IPv4)
+func lastAddr(network *net.IPNet) net.IP {
+	last := make(net.IP, len(network.IP))
+	for i := range network.IP {
+		last[i] = network.IP[i] | ^network.Mask[i]
+	}
+	return last
+}
+
+// subnetCIDR returns the network of addr in CIDR form. A prefix length given in addr is kept as is,
+// a single address is assumed to belong to its default (classful for IPv4, /64 for IPv6) network
+func subnetCIDR(addr string) (string, error) {
+	ip, network, err := parseAddr(addr)
+	if err != nil {
+		return "", err
+	}
+	if network != nil {
+		return network.String(), nil
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	mask := ip.DefaultMask()
+	if mask == nil {
+		mask = net.CIDRMask(defaultIPv6Prefix, 8*net.IPv6len)
+	}
+	return (&net.IPNet{IP: ip.Mask(mask), Mask: mask}).String(), nil
+}
+
+// stepSubnet returns the network step prefix-sized blocks after the given network, in CIDR form
+func stepSubnet(cidr string, step int) string {
+	_, network, _ := net.ParseCIDR(cidr)
+	ones, bits := network.Mask.Size()
+	offset := new(big.Int).Lsh(big.NewInt(int64(step)), uint(bits-ones))
+
+	v := new(big.Int).SetBytes(network.IP)
+	v.Add(v, offset)
+	v.Mod(v, new(big.Int).Lsh(big.NewInt(1), uint(bits)))
+	next := make(net.IP, len(network.IP))
+	v.FillBytes(next)
+	return (&net.IPNet{IP: next, Mask: network.Mask}).String()
+}
+
+// withinSearch reports whether a free subnet search starting at startSubnet could have ended up with the
+// subnet of cidr, i.e. if both have the same prefix and cidr is one of the tries blocks step apart visited
+func withinSearch(startSubnet, cidr string, step, tries int) bool {
+	start, err := subnetCIDR(startSubnet)
+	if err != nil {
+		return false
+	}
+	_, startNet, _ := net.ParseCIDR(start)
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil || len(network.IP) != len(startNet.IP) {
+		return false
+	}
+	ones, bits := network.Mask.Size()
+	if startOnes, _ := startNet.Mask.Size(); startOnes != ones {
+		return false
+	}
+
+	offset := new(big.Int).Sub(new(big.Int).SetBytes(network.IP), new(big.Int).SetBytes(startNet.IP))
+	offset.Mod(offset, new(big.Int).Lsh(big.NewInt(1), uint(bits)))
+	blocks := offset.Rsh(offset, uint(bits-ones))
+	quo, rem := new(big.Int).QuoRem(blocks, big.NewInt(int64(step)), new(big.Int))
+	return rem.Sign() == 0 && quo.Cmp(big.NewInt(int64(tries))) < 0
+}
+
 // parseAddr will try to parse an ip or a cidr address
 func parseAddr(addr string) (net.IP, *net.IPNet, error) {
 	ip, network, err := net.ParseCIDR(addr)
@@ -179,15 +295,19 @@ func lookupInInterfaces(ip net.IP) (*Parameters, *net.IPNet, error) {
 				return nil, nil, fmt.Errorf("failed parsing network interface address %+v: %w", ifAddr, err)
 			}
 			if lan.Contains(ip) {
-				ip4 := ifip.To4().String()
 				rt := Parameters{
 					Interface: Interface{
 						IfaceName: iface.Name,
-						IfaceIPv4: ip4,
 						IfaceMTU:  iface.MTU,
 						IfaceMAC:  iface.HardwareAddr.String(),
 					},
-					Gateway: ip4,
+				}
+				if ip4 := ifip.To4(); ip4 != nil {
+					rt.IfaceIPv4 = ip4.String()
+					rt.Gateway = rt.IfaceIPv4
+				} else {
+					rt.IfaceIPv6 = ifip.String()
+					rt.Gateway = rt.IfaceIPv6
 				}
 				return &rt, lan, nil
 			}
@@ -0,0 +1,107 @@
+package network
+
+import (
+	"testing"
+)
+
+func TestInspectIPv6(t *testing.T) {
+	n, err := inspect("fd00:89::/64")
+	if err != nil {
+		t.Fatalf("inspect() error = %v", err)
+	}
+	want := Parameters{
+		IP:        "fd00:89::",
+		IsIPv6:    true,
+		Netmask:   "ffff:ffff:ffff:ffff::",
+		Prefix:    64,
+		CIDR:      "fd00:89::/64",
+		Gateway:   "fd00:89::1",
+		ClientMin: "fd00:89::2",
+		ClientMax: "fd00:89::ffff",
+		Broadcast: "fd00:89::ffff:ffff:ffff:ffff",
+		IsPrivate: true,
+	}
+	if *n != want {
+		t.Errorf("inspect() = %+v, want %+v", *n, want)
+	}
+}
+
+func TestFreeSubnetStepsIPv6Prefixes(t *testing.T) {
+	stubSubnets(t)
+	taken := isSubnetTaken
+	isSubnetTaken = func(subnet string) (bool, error) {
+		return subnet == "fd00:89::" || subnet == "fd00:89:0:1::", nil
+	}
+	t.Cleanup(func() { isSubnetTaken = taken })
+
+	n, err := FreeSubnet("fd00:89::/64", 1, 5)
+	if err != nil {
+		t.Fatalf("FreeSubnet() error = %v", err)
+	}
+	if n.CIDR != "fd00:89:0:2::/64" {
+		t.Errorf("FreeSubnet() = %s, want fd00:89:0:2::/64", n.CIDR)
+	}
+}
+
+func TestFreeSubnetKeepsPrefix(t *testing.T) {
+	tests := []struct {
+		name    string
+		start   string
+		taken   []string
+		step    int
+		want    string
+		wantErr bool
+	}{
+		{name: "class A address with /24", start: "10.89.0.1/24", taken: []string{"10.89.0.0"}, step: 11, want: "10.89.11.0/24"},
+		{name: "unaligned /22", start: "172.20.5.0/22", taken: []string{"172.20.4.0"}, step: 1, want: "172.20.8.0/22"},
+		{name: "stepping out of the private range", start: "192.168.250.0/24", taken: []string{"192.168.250.0"}, step: 11, wantErr: true},
+		{name: "plain class C address", start: "192.168.39.0", step: 11, want: "192.168.39.0/24"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubSubnets(t)
+			isSubnetTaken = func(subnet string) (bool, error) {
+				for _, taken := range tt.taken {
+					if subnet == taken {
+						return true, nil
+					}
+				}
+				return false, nil
+			}
+
+			n, err := FreeSubnet(tt.start, tt.step, 2)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("FreeSubnet() = %s, want error", n.CIDR)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FreeSubnet() error = %v", err)
+			}
+			if n.CIDR != tt.want {
+				t.Errorf("FreeSubnet() = %s, want %s", n.CIDR, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithinSearch(t *testing.T) {
+	tests := []struct {
+		start, cidr string
+		want        bool
+	}{
+		{start: "192.168.39.1/24", cidr: "192.168.39.0/24", want: true},
+		{start: "192.168.39.1/24", cidr: "192.168.50.0/24", want: true},
+		{start: "192.168.39.1/24", cidr: "192.168.45.0/24", want: false},
+		{start: "192.168.39.1/24", cidr: "192.168.39.0/23", want: false},
+		{start: "192.168.39.1/24", cidr: "192.168.38.0/24", want: false},
+		{start: "fd00:89::/64", cidr: "fd00:89:0:21::/64", want: true},
+		{start: "192.168.39.1/24", cidr: "fd00:89::/64", want: false},
+	}
+	for _, tt := range tests {
+		if got := withinSearch(tt.start, tt.cidr, 11, 20); got != tt.want {
+			t.Errorf("withinSearch(%s, %s) = %v, want %v", tt.start, tt.cidr, got, tt.want)
+		}
+	}
+}
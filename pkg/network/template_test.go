@@ -0,0 +1,120 @@
+package network
+
+import (
+	"context"
+	"encoding/xml"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/day0ops/netctl/pkg/config"
+)
+
+func TestCreateNetworkCustomTemplate(t *testing.T) {
+	stubSubnets(t)
+	dir := t.TempDir()
+	mtuTemplate := filepath.Join(dir, "mtu.tmpl")
+	writeFile(t, mtuTemplate, strings.Replace(config.NetworkTmpl, "<bridge", "<mtu size='{{.Vars.mtu}}'/>\n  <bridge", 1))
+	noMetadataTemplate := filepath.Join(dir, "no-metadata.tmpl")
+	writeFile(t, noMetadataTemplate, `<network><name>{{.Name}}</name><ip address='{{.Gateway}}' netmask='{{.Netmask}}'/></network>`)
+	brokenTemplate := filepath.Join(dir, "broken.tmpl")
+	writeFile(t, brokenTemplate, `<network><name>{{.Name</name></network>`)
+
+	tests := []struct {
+		name     string
+		template string
+		vars     map[string]string
+		wantErr  string
+	}{
+		{name: "variables", template: mtuTemplate, vars: map[string]string{"mtu": "9000"}},
+		{name: "missing variable", template: mtuTemplate, wantErr: "mtu"},
+		{name: "no metadata", template: noMetadataTemplate, wantErr: "metadata"},
+		{name: "missing file", template: filepath.Join(dir, "missing.tmpl"), wantErr: "reading"},
+		{name: "broken template", template: brokenTemplate, wantErr: "parsing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewFakeBackend()
+			c := NewClientWithBackend(b)
+			n := testNetwork()
+			n.Template, n.TemplateVars = tt.template, tt.vars
+
+			_, err := c.EnsureNetwork(context.Background(), n)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("EnsureNetwork() error = %v, want %q", err, tt.wantErr)
+				}
+				if b.Calls["DefineNetwork"] != 0 {
+					t.Error("the network was defined from an invalid template")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("EnsureNetwork() error = %v", err)
+			}
+			if s, _ := b.NetworkXML("test-net"); !strings.Contains(s, "<mtu size='9000'/>") {
+				t.Errorf("network XML does not use the template:\n%s", s)
+			}
+		})
+	}
+}
+
+func TestUpdateNetworkKeepsTemplate(t *testing.T) {
+	stubSubnets(t)
+	mtuTemplate := filepath.Join(t.TempDir(), "mtu.tmpl")
+	writeFile(t, mtuTemplate, strings.Replace(config.NetworkTmpl, "<bridge", "<mtu size='{{.Vars.mtu}}'/>\n  <bridge", 1))
+	b := NewFakeBackend()
+	c := NewClientWithBackend(b)
+	ctx := context.Background()
+	n := testNetwork()
+	n.Template, n.TemplateVars = mtuTemplate, map[string]string{"mtu": "9000"}
+	if _, err := c.EnsureNetwork(ctx, n); err != nil {
+		t.Fatal(err)
+	}
+	mtu := func() string {
+		s, _ := b.PersistentNetworkXML("test-net")
+		root := &xmlNode{}
+		if err := xml.Unmarshal([]byte(s), root); err != nil {
+			t.Fatal(err)
+		}
+		if mtu := root.child("mtu"); mtu != nil {
+			return mtu.attr("size")
+		}
+		return ""
+	}
+
+	// the declaration of the network keeps its template, so a redefinition renders it again
+	d, err := c.Declaration(ctx, "test-net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Template != mtuTemplate || d.TemplateVars["mtu"] != "9000" {
+		t.Fatalf("Declaration() template = %q %v, want the one the network was created with", d.Template, d.TemplateVars)
+	}
+	d.ForwardMode = ForwardModeNAT
+	r, err := c.UpdateNetwork(ctx, d, UpdateOptions{})
+	if err != nil {
+		t.Fatalf("UpdateNetwork() error = %v", err)
+	}
+	if len(r.Redefined) != 1 {
+		t.Errorf("UpdateNetwork() = %+v, want the forward mode redefined", r)
+	}
+	if got := mtu(); got != "9000" {
+		t.Errorf("MTU after update = %q, want 9000", got)
+	}
+
+	// a change of the template variables is one to redefine
+	d.TemplateVars = map[string]string{"mtu": "1500"}
+	if r, err = c.UpdateNetwork(ctx, d, UpdateOptions{}); err != nil {
+		t.Fatalf("UpdateNetwork() error = %v", err)
+	}
+	if len(r.Redefined) != 1 || r.Redefined[0] != "template variables are map[mtu:9000], want map[mtu:1500]" {
+		t.Errorf("UpdateNetwork() of the template variables = %+v", r)
+	}
+	if got := mtu(); got != "1500" {
+		t.Errorf("MTU after update = %q, want 1500", got)
+	}
+	if r, err = c.UpdateNetwork(ctx, d, UpdateOptions{}); err != nil || len(r.Redefined) != 0 {
+		t.Errorf("UpdateNetwork() of an up to date network = %+v, %v", r, err)
+	}
+}
@@ -0,0 +1,412 @@
+package network
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/day0ops/netctl/pkg/ipam"
+	"github.com/day0ops/netctl/pkg/log"
+)
+
+// ErrRecreateRequired is returned when the changes to a network can only be applied by recreating it
+var ErrRecreateRequired = errors.New("network has to be recreated to apply the changes")
+
+// UpdateOptions tunes how UpdateNetwork applies the changes to a network
+type UpdateOptions struct {
+	// Force updates the network even if it was not created by netctl
+	Force bool
+
+	// Recreate deletes and creates the network again when the changes can't be applied live, instead of leaving
+	// them pending until the network is restarted. Subnet changes always need it. Refused if domains use the network
+	Recreate bool
+}
+
+// UpdateResult is how UpdateNetwork applied the changes to a network, nothing being set if it was up to date
+type UpdateResult struct {
+	Name      string   `json:"name" yaml:"name"`
+	Live      []string `json:"live,omitempty" yaml:"live,omitempty"`           // changes applied in place
+	Redefined []string `json:"redefined,omitempty" yaml:"redefined,omitempty"` // changes written to the definition
+	Pending   bool     `json:"pending,omitempty" yaml:"pending,omitempty"`     // whether changes written to the definition wait for a restart
+	Recreated []string `json:"recreated,omitempty" yaml:"recreated,omitempty"` // changes applied by recreating the network
+}
+
+// UpdateNetwork makes the existing network match n. The changes libvirt supports on a running network (DHCP ranges
+// and autostart) are applied in place. The others are written to its definition, taking effect the next time the
+// network is started, or right away by recreating it with opts.Recreate. Subnet changes can only be applied by
+// recreating the network, which is refused if domains use it. Unless forced, networks not created by netctl are
+// refused with ErrNotManaged
+func (c *Client) UpdateNetwork(ctx context.Context, n *Network, opts UpdateOptions) (*UpdateResult, error) {
+	if err := n.Validate(); err != nil {
+		return nil, err
+	}
+	state, err := c.backend.LookupNetwork(n.Name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed looking up network %s", n.Name)
+	}
+	// changes are made to the definition, which may have some pending a restart already
+	xmlString, err := c.backend.PersistentNetworkXML(n.Name)
+	if err != nil {
+		return nil, err
+	}
+	v, err := parseNetworkXML(xmlString)
+	if err != nil {
+		return nil, errors.Wrapf(err, "network '%s'", n.Name)
+	}
+	m := v.metadata()
+	if m == nil && !opts.Force {
+		return nil, fmt.Errorf("%w: %s", ErrNotManaged, n.Name)
+	}
+
+	diffs := n.differences(v)
+	if m != nil && !maps.Equal(m.Labels, n.Labels) {
+		diffs = append(diffs, difference{"labels", fmt.Sprintf("labels are %v, want %v", m.Labels, n.Labels)})
+	}
+	var recreate, live, redefine []difference
+	for _, d := range diffs {
+		switch d.field {
+		case "subnet", "subnetV6":
+			recreate = append(recreate, d)
+		case "dhcpRange", "dhcpRangeV6":
+			live = append(live, d)
+		default:
+			redefine = append(redefine, d)
+		}
+	}
+
+	l := log.With(log.Fields{log.FieldNetwork: n.Name})
+	r := &UpdateResult{Name: n.Name}
+	if len(recreate) > 0 && !opts.Recreate {
+		return nil, fmt.Errorf("%w: %s: %s", ErrRecreateRequired, n.Name, strings.Join(reasons(recreate), ", "))
+	}
+	pending, err := c.pendingChanges(n, reasons(diffs))
+	if err != nil {
+		return nil, err
+	}
+	if len(recreate) > 0 || opts.Recreate && state.Active && (len(redefine) > 0 || len(pending) > 0) {
+		if err := c.checkDomains(ctx, n.Name); err != nil {
+			return nil, err
+		}
+		l.Infof("recreating network %s", n.Name)
+		if err := c.recreateNetwork(ctx, n, state, xmlString, v, m); err != nil {
+			return nil, err
+		}
+		r.Recreated = append(reasons(diffs), pending...)
+		return r, nil
+	}
+
+	for _, d := range live {
+		if err := checkContext(ctx); err != nil {
+			return nil, err
+		}
+		ipv6, dhcpRange := d.field == "dhcpRangeV6", n.DHCPRange
+		if ipv6 {
+			dhcpRange = n.DHCPRangeV6
+		}
+		if err := c.updateDHCPRange(v, ipv6, dhcpRange); err != nil {
+			l.Debugf("failed changing the DHCP range of network %s live, will redefine it: %v", n.Name, err)
+			redefine = append(redefine, d)
+			continue
+		}
+		l.Infof("changed the DHCP range of network %s to %s", n.Name, dhcpRange)
+		r.Live = append(r.Live, d.reason)
+	}
+	if state.Autostart == n.NoAutostart {
+		if err := c.backend.SetNetworkAutostart(n.Name, !n.NoAutostart); err != nil {
+			return nil, errors.Wrapf(err, "setting autostart for network %s", n.Name)
+		}
+		r.Live = append(r.Live, fmt.Sprintf("autostart is %t, want %t", state.Autostart, !n.NoAutostart))
+	}
+
+	if len(redefine) > 0 {
+		if err := c.redefineNetwork(n, xmlString, v, m); err != nil {
+			return nil, err
+		}
+		r.Redefined, r.Pending = reasons(redefine), state.Active
+		if r.Pending {
+			l.Infof("redefined network %s, the changes take effect the next time it is started", n.Name)
+		} else {
+			l.Infof("redefined network %s", n.Name)
+		}
+	}
+	if len(pending) > 0 {
+		r.Pending = true
+		l.Infof("network %s has changes pending a restart: %s", n.Name, strings.Join(pending, ", "))
+	}
+	if len(r.Live) == 0 && len(r.Redefined) == 0 && !r.Pending {
+		l.Infof("network %s is up to date", n.Name)
+	}
+	return r, nil
+}
+
+// updateDHCPRange replaces the DHCP range of the IPv4 or IPv6 subnet of the network described by v. The new range
+// is added before the old one is deleted, so the network always has one
+func (c *Client) updateDHCPRange(v *networkXML, ipv6 bool, dhcpRange string) error {
+	ip4, ip6 := v.ips()
+	ip := ip4
+	if ipv6 {
+		ip = ip6
+	}
+	if ip == nil || ip.DHCP == nil {
+		return fmt.Errorf("network %s has no DHCP range to change", v.Name)
+	}
+	index := 0
+	for i := range v.IPs {
+		if &v.IPs[i] == ip {
+			index = i
+		}
+	}
+	params, err := parametersFromXML(*ip)
+	if err != nil {
+		return err
+	}
+	if err := setDHCPRange(params, dhcpRange); err != nil {
+		return err
+	}
+
+	rangeXML := func(start, end string) string {
+		return fmt.Sprintf("<range start='%s' end='%s'/>", start, end)
+	}
+	update := func(cmd UpdateCommand, rangeXML string) error {
+		return c.backend.UpdateNetwork(v.Name, NetworkUpdate{Command: cmd, Section: SectionDHCPRange, ParentIndex: index, XML: rangeXML})
+	}
+	added := rangeXML(params.ClientMin, params.ClientMax)
+	if err := update(UpdateAdd, added); err != nil {
+		return err
+	}
+	if err := update(UpdateDelete, rangeXML(ip.DHCP.Range.Start, ip.DHCP.Range.End)); err != nil {
+		if err := update(UpdateDelete, added); err != nil {
+			log.Errorf("failed removing DHCP range %s added to network %s: %v", dhcpRange, v.Name, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// recreateNetwork deletes the network described by state, xmlString and v and creates it again as n, keeping its
+// identity, DHCP hosts, DNS records, creation metadata m (nil if it was not created by netctl) and IPAM allocation
+// history. The records move along with the subnets. The previous network is put back if it can't be created again
+func (c *Client) recreateNetwork(ctx context.Context, n *Network, state NetworkState, xmlString string, v *networkXML, m *Metadata) error {
+	persistentXML, err := c.backend.PersistentNetworkXML(n.Name)
+	if err != nil {
+		return err
+	}
+	previous, err := c.allocation(n.Name)
+	if err != nil {
+		return err
+	}
+	if _, err := c.DeleteNetwork(ctx, n.Name, DeleteOptions{Force: true}); err != nil {
+		return errors.Wrapf(err, "deleting network %s", n.Name)
+	}
+
+	ip4, ip6 := v.ips()
+	render := func(subnet, subnetV6 *Parameters) (string, error) {
+		tryNet, err := n.libvirtNetwork(subnet, subnetV6)
+		if err != nil {
+			return "", err
+		}
+		tryNet.UUID, tryNet.MAC = v.UUID, v.MAC.Address
+		if tryNet.Bridge == "" {
+			tryNet.Bridge = v.Bridge.Name
+		}
+		if m != nil {
+			m.Labels = n.Labels
+			tryNet.Metadata = m.template()
+		}
+		networkXML, err := tryNet.render()
+		if err != nil {
+			return "", err
+		}
+		current := &xmlNode{}
+		if err := xml.Unmarshal([]byte(xmlString), current); err != nil {
+			return "", err
+		}
+		if ip4 != nil {
+			remapSubnet(current, n.Name, ip4.cidr(), subnet)
+		}
+		if ip6 != nil {
+			remapSubnet(current, n.Name, ip6.cidr(), subnetV6)
+		}
+		moved, err := xml.Marshal(current)
+		if err != nil {
+			return "", err
+		}
+		return keepRecords(n.Name, string(moved), networkXML)
+	}
+	_, err = c.createNetworkWith(ctx, n, render)
+	if err == nil {
+		err = setupNetwork(c.backend, n.Name, !n.NoAutostart)
+	}
+	if err != nil {
+		if restoreErr := c.restoreNetwork(ctx, n.Name, state, persistentXML, previous); restoreErr != nil {
+			log.Errorf("failed putting back network %s: %v", n.Name, restoreErr)
+		}
+		return errors.Wrapf(err, "recreating network %s", n.Name)
+	}
+
+	if previous == nil {
+		return nil
+	}
+	a, err := c.allocation(n.Name)
+	if err != nil || a == nil {
+		return err
+	}
+	a.Owner, a.Created = previous.Owner, previous.Created
+	return c.ipam.Reserve(*a)
+}
+
+// restoreNetwork puts back the network deleted by a failed recreation: its persistent XML, its autostart, whether
+// it was active and its IPAM allocation a (nil if it had none). It runs even if ctx is canceled
+func (c *Client) restoreNetwork(ctx context.Context, name string, state NetworkState, persistentXML string, a *ipam.Allocation) error {
+	ctx = context.WithoutCancel(ctx)
+	if _, err := c.DeleteNetwork(ctx, name, DeleteOptions{Force: true}); err != nil {
+		return err
+	}
+	if err := c.backend.DefineNetwork(persistentXML); err != nil {
+		return err
+	}
+	if state.Active {
+		if err := setupNetwork(c.backend, name, state.Autostart); err != nil {
+			return err
+		}
+	} else if err := c.backend.SetNetworkAutostart(name, state.Autostart); err != nil {
+		return err
+	}
+	if a != nil {
+		if err := c.ipam.Reserve(*a); err != nil {
+			return err
+		}
+	}
+	log.Warnf("put back network %s as it was before the update", name)
+	return nil
+}
+
+// redefineNetwork writes n to the definition of the network described by xmlString and v, keeping its subnets,
+// identity, DHCP hosts, DNS records and creation metadata m (nil if it was not created by netctl)
+func (c *Client) redefineNetwork(n *Network, xmlString string, v *networkXML, m *Metadata) error {
+	ip4, ip6 := v.ips()
+	subnet, err := currentSubnet(ip4)
+	if err != nil {
+		return errors.Wrapf(err, "network '%s'", n.Name)
+	}
+	subnetV6, err := currentSubnet(ip6)
+	if err != nil {
+		return errors.Wrapf(err, "network '%s'", n.Name)
+	}
+
+	tryNet, err := n.libvirtNetwork(subnet, subnetV6)
+	if err != nil {
+		return err
+	}
+	tryNet.UUID, tryNet.MAC = v.UUID, v.MAC.Address
+	if tryNet.Bridge == "" {
+		tryNet.Bridge = v.Bridge.Name
+	}
+	if m != nil {
+		m.Labels = n.Labels
+		tryNet.Metadata = m.template()
+	}
+	networkXML, err := tryNet.render()
+	if err != nil {
+		return err
+	}
+	if networkXML, err = keepRecords(n.Name, xmlString, networkXML); err != nil {
+		return err
+	}
+
+	log.Tracef("redefining network %s as XML:\n%s", n.Name, networkXML)
+	if err := c.backend.DefineNetwork(networkXML); err != nil {
+		return fmt.Errorf("redefining network %s from xml %s: %w", n.Name, networkXML, err)
+	}
+	return nil
+}
+
+// currentSubnet returns the parameters of the subnet of the ip element, nil if there is none. Without DHCP (IPv6
+// router advertisements only), the client range is the one a new network would get
+func currentSubnet(ip *networkIPXML) (*Parameters, error) {
+	if ip == nil {
+		return nil, nil
+	}
+	params, err := parametersFromXML(*ip)
+	if err != nil {
+		return nil, err
+	}
+	if ip.DHCP == nil {
+		reserveVIP(params)
+	}
+	return params, nil
+}
+
+// keepRecords copies the DHCP hosts and DNS records of the network XML current, which were added in place, to the
+// network XML rendered for it. Records the rendered network has no place for (DNS disabled, no DHCP) are dropped
+func keepRecords(name, current, rendered string) (string, error) {
+	from, to := &xmlNode{}, &xmlNode{}
+	if err := xml.Unmarshal([]byte(current), from); err != nil {
+		return "", err
+	}
+	if err := xml.Unmarshal([]byte(rendered), to); err != nil {
+		return "", err
+	}
+
+	kept := 0
+	copyChildren := func(from, to *xmlNode, names ...string) int {
+		dropped := 0
+		for _, name := range names {
+			for _, node := range from.children(name) {
+				if to == nil {
+					dropped++
+					continue
+				}
+				to.Nodes = append(to.Nodes, *node)
+				kept++
+			}
+		}
+		return dropped
+	}
+	dropped := 0
+	for _, ip := range from.children("ip") {
+		dhcp := ip.children("dhcp")
+		if len(dhcp) == 0 {
+			continue
+		}
+		var target *xmlNode
+		for _, toIP := range to.children("ip") {
+			if toIP.attr("address") == ip.attr("address") && len(toIP.children("dhcp")) > 0 {
+				target = toIP.child("dhcp")
+			}
+		}
+		dropped += copyChildren(dhcp[0], target, "host")
+	}
+	if dns := from.children("dns"); len(dns) > 0 {
+		var target *xmlNode
+		if toDNS := to.children("dns"); len(toDNS) > 0 && toDNS[0].attr("enable") != "no" {
+			target = toDNS[0]
+		}
+		dropped += copyChildren(dns[0], target, "host", "srv", "txt")
+	}
+	if dropped > 0 {
+		log.Warnf("dropping %d DHCP hosts and DNS records network %s has no place for anymore", dropped, name)
+	}
+	if kept == 0 {
+		return rendered, nil
+	}
+
+	out, err := xml.MarshalIndent(to, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// reasons returns the reasons of the differences
+func reasons(diffs []difference) []string {
+	var rs []string
+	for _, d := range diffs {
+		rs = append(rs, d.reason)
+	}
+	return rs
+}
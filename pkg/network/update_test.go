@@ -0,0 +1,169 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestUpdateNetworkLive(t *testing.T) {
+	b, c := createTestNetwork(t)
+	ctx := context.Background()
+	if err := c.AddHosts(ctx, "test-net", []Host{{MAC: "52:54:00:aa:00:01", IP: "192.168.123.10"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := c.UpdateNetwork(ctx, testNetwork(), UpdateOptions{})
+	if err != nil {
+		t.Fatalf("UpdateNetwork() error = %v", err)
+	}
+	if len(r.Live)+len(r.Redefined)+len(r.Recreated) != 0 || b.Calls["DefineNetwork"] != 1 {
+		t.Errorf("UpdateNetwork() of an up to date network = %+v", r)
+	}
+
+	n := testNetwork()
+	n.DHCPRange = "192.168.123.100-192.168.123.200"
+	n.NoAutostart = true
+	if r, err = c.UpdateNetwork(ctx, n, UpdateOptions{}); err != nil {
+		t.Fatalf("UpdateNetwork() error = %v", err)
+	}
+	if len(r.Live) != 2 || len(r.Redefined) != 0 || b.Calls["DefineNetwork"] != 1 {
+		t.Errorf("UpdateNetwork() = %+v, want the DHCP range and autostart changed live", r)
+	}
+	xml, _ := b.NetworkXML("test-net")
+	if !strings.Contains(xml, "192.168.123.100") || strings.Contains(xml, "192.168.123.253") {
+		t.Errorf("DHCP range not replaced:\n%s", xml)
+	}
+	if state, _ := b.LookupNetwork("test-net"); state.Autostart {
+		t.Error("autostart still enabled")
+	}
+}
+
+func TestUpdateNetworkRedefine(t *testing.T) {
+	b, c := createTestNetwork(t)
+	ctx := context.Background()
+	n := testNetwork()
+	n.DNS = true
+	if err := c.AddHosts(ctx, "test-net", []Host{{MAC: "52:54:00:aa:00:01", IP: "192.168.123.10"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	n.ForwardMode = ForwardModeNAT
+	n.Labels = map[string]string{"team": "qa"}
+	r, err := c.UpdateNetwork(ctx, n, UpdateOptions{})
+	if err != nil {
+		t.Fatalf("UpdateNetwork() error = %v", err)
+	}
+	if len(r.Redefined) != 3 || !r.Pending || len(r.Recreated) != 0 {
+		t.Errorf("UpdateNetwork() = %+v, want DNS, forward mode and labels redefined pending a restart", r)
+	}
+	after, _ := b.PersistentNetworkXML("test-net")
+	if !strings.Contains(after, "mode=\"nat\"") && !strings.Contains(after, "mode='nat'") {
+		t.Errorf("forward mode not redefined:\n%s", after)
+	}
+	if !strings.Contains(after, "52:54:00:aa:00:01") {
+		t.Errorf("DHCP host dropped by the redefinition:\n%s", after)
+	}
+	m, err := networkMetadata(b, "test-net")
+	if err != nil || m == nil || m.Labels["team"] != "qa" {
+		t.Errorf("metadata after redefinition = %+v, %v", m, err)
+	}
+	if b.Calls["UndefineNetwork"] != 0 {
+		t.Error("UpdateNetwork() recreated the network")
+	}
+
+	// the changes are defined already, only waiting for a restart
+	defines := b.Calls["DefineNetwork"]
+	if r, err = c.UpdateNetwork(ctx, n, UpdateOptions{}); err != nil || len(r.Redefined) != 0 || !r.Pending {
+		t.Errorf("UpdateNetwork() again = %+v, %v, want the changes still pending", r, err)
+	}
+	if b.Calls["DefineNetwork"] != defines {
+		t.Error("UpdateNetwork() redefined the network again")
+	}
+}
+
+func TestUpdateNetworkRecreate(t *testing.T) {
+	b, c := createTestNetwork(t)
+	ctx := context.Background()
+	n := testNetwork()
+	n.Subnet = "10.89.0.1/24"
+
+	if _, err := c.UpdateNetwork(ctx, n, UpdateOptions{}); !errors.Is(err, ErrRecreateRequired) {
+		t.Errorf("UpdateNetwork() of the subnet error = %v, want ErrRecreateRequired", err)
+	}
+
+	b.AddDomain("vm", domainOn("test-net"))
+	var inUse *DomainsInUseError
+	if _, err := c.UpdateNetwork(ctx, n, UpdateOptions{Recreate: true}); !errors.As(err, &inUse) {
+		t.Errorf("UpdateNetwork(recreate) of a network in use error = %v, want in use error", err)
+	}
+
+	stubSubnets(t)
+	b = NewFakeBackend()
+	c = NewClientWithBackend(b)
+	n = testNetwork()
+	n.DNS = true
+	if _, err := c.EnsureNetwork(ctx, n); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddHosts(ctx, "test-net", []Host{{MAC: "52:54:00:aa:00:01", IP: "192.168.123.10"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddDNSRecord(ctx, "test-net", DNSRecord{Type: DNSRecordHost, IP: "192.168.123.10", Hostnames: []string{"node-1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddDNSRecord(ctx, "test-net", DNSRecord{Type: DNSRecordTXT, Name: "lab", Value: "owner=qa"}); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := networkMetadata(b, "test-net")
+
+	n.Subnet = "10.89.0.1/24"
+	r, err := c.UpdateNetwork(ctx, n, UpdateOptions{Recreate: true})
+	if err != nil {
+		t.Fatalf("UpdateNetwork(recreate) error = %v", err)
+	}
+	if len(r.Recreated) != 1 {
+		t.Errorf("UpdateNetwork(recreate) = %+v", r)
+	}
+	xml, _ := b.NetworkXML("test-net")
+	if !strings.Contains(xml, "10.89.0.1") {
+		t.Errorf("subnet not changed:\n%s", xml)
+	}
+	records, err := c.ListDNSRecords(ctx, "test-net")
+	if err != nil || len(records) != 2 || records[0].IP != "10.89.0.10" {
+		t.Errorf("DNS records after recreation = %v, %v, want the host moved to 10.89.0.10 and the TXT record", records, err)
+	}
+	if !strings.Contains(xml, "52:54:00:aa:00:01") || !strings.Contains(xml, "10.89.0.10") {
+		t.Errorf("DHCP host not kept by the recreation:\n%s", xml)
+	}
+	if after, _ := networkMetadata(b, "test-net"); after == nil || !after.Created.Equal(before.Created) {
+		t.Errorf("metadata after recreation = %+v, want created %s", after, before.Created)
+	}
+}
+
+func TestUpdateNetworkRecreateFailurePutsNetworkBack(t *testing.T) {
+	b, c := createTestNetwork(t)
+	ctx := context.Background()
+	before, _ := b.NetworkXML("test-net")
+	// only the previous subnet can be started
+	b.OnCreate = func(name string) error {
+		if !strings.Contains(b.networks[name].xml, "192.168.123.1") {
+			return errors.New("bridge is busy")
+		}
+		return nil
+	}
+
+	n := testNetwork()
+	n.Subnet = "10.89.0.1/24"
+	if _, err := c.UpdateNetwork(ctx, n, UpdateOptions{Recreate: true}); err == nil {
+		t.Fatal("UpdateNetwork(recreate) succeeded, want error")
+	}
+	after, _ := b.NetworkXML("test-net")
+	if after != before {
+		t.Errorf("network after a failed recreation =\n%s\nwant\n%s", after, before)
+	}
+	if state, _ := b.LookupNetwork("test-net"); !state.Active || !state.Autostart {
+		t.Errorf("network state after a failed recreation = %+v, want active and autostarted", state)
+	}
+}
@@ -0,0 +1,267 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Types of watched events
+const (
+	EventNetworkDefined    = "network-defined"
+	EventNetworkUndefined  = "network-undefined"
+	EventNetworkStarted    = "network-started"
+	EventNetworkStopped    = "network-stopped"
+	EventInterfaceAttached = "interface-attached"
+	EventInterfaceDetached = "interface-detached"
+)
+
+// Event is a change of a network created by netctl: its lifecycle, or a domain interface attached to or detached from it
+type Event struct {
+	Time    time.Time `json:"time" yaml:"time"`
+	Type    string    `json:"type" yaml:"type"`
+	Network string    `json:"network" yaml:"network"`
+	Domain  string    `json:"domain,omitempty" yaml:"domain,omitempty"` // set for interface events
+	MAC     string    `json:"mac,omitempty" yaml:"mac,omitempty"`       // set for interface events
+}
+
+func (e Event) String() string {
+	if e.Domain != "" {
+		return fmt.Sprintf("%s %s: domain %s interface %s", e.Type, e.Network, e.Domain, e.MAC)
+	}
+	return fmt.Sprintf("%s %s", e.Type, e.Network)
+}
+
+// watchedInterface is a domain interface attached to a watched network
+type watchedInterface struct {
+	network string
+	DomainInterface
+}
+
+func (i watchedInterface) key() string {
+	return i.network + "/" + i.Domain + "/" + strings.ToLower(i.MAC)
+}
+
+// watcher turns the events of a backend into the events of the watched networks
+type watcher struct {
+	c       *Client
+	names   map[string]bool   // networks to watch, every network created by netctl if empty
+	bridges map[string]string // bridge of each watched network
+	ifaces  []watchedInterface
+}
+
+// Watch calls f with every event of the networks created by netctl until ctx is done, or only with the events of
+// the given networks if any. Interfaces are tracked in the persistent definition of the domains, like DomainsUsing
+func (c *Client) Watch(ctx context.Context, networks []string, f func(Event) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	events, err := c.backend.WatchEvents(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := &watcher{c: c, names: map[string]bool{}, bridges: map[string]string{}}
+	for _, name := range networks {
+		w.names[name] = true
+	}
+	owned, err := c.ownedNetworks()
+	if err != nil {
+		return err
+	}
+	for _, name := range owned {
+		if err := w.track(name); err != nil {
+			return err
+		}
+	}
+	if w.ifaces, err = w.interfaces(); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e, ok := <-events:
+			if !ok {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return fmt.Errorf("stopped receiving events, the hypervisor connection was lost")
+			}
+			changes, err := w.handle(e)
+			if err != nil {
+				return err
+			}
+			for _, change := range changes {
+				if err := f(change); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// handle updates the watched networks and interfaces on e, returning the resulting events
+func (w *watcher) handle(e BackendEvent) ([]Event, error) {
+	now := time.Now()
+	if e.Type == EventDomainChanged {
+		ifaces, err := w.interfaces()
+		if err != nil {
+			return nil, err
+		}
+		events := diffInterfaces(w.ifaces, ifaces, now)
+		w.ifaces = ifaces
+		return events, nil
+	}
+
+	_, watched := w.bridges[e.Network]
+	switch e.Type {
+	case EventNetworkDefined:
+		if err := w.track(e.Network); err != nil {
+			return nil, err
+		}
+	case EventNetworkUndefined:
+		delete(w.bridges, e.Network)
+	}
+	if _, ok := w.bridges[e.Network]; !ok && !watched {
+		return nil, nil
+	}
+	if e.Type == EventNetworkDefined || e.Type == EventNetworkUndefined {
+		// interfaces of the domains already using a network (re)defined with another bridge are not attached now
+		ifaces, err := w.interfaces()
+		if err != nil {
+			return nil, err
+		}
+		w.ifaces = ifaces
+	}
+	return []Event{{Time: now, Type: e.Type, Network: e.Network}}, nil
+}
+
+// track watches the named network if it was created by netctl and selected, recording its bridge
+func (w *watcher) track(name string) error {
+	delete(w.bridges, name)
+	if len(w.names) > 0 && !w.names[name] {
+		return nil
+	}
+	xmlString, err := w.c.backend.NetworkXML(name)
+	if errors.Is(err, ErrNetworkNotFound) {
+		// undefined since
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	v, err := parseNetworkXML(xmlString)
+	if err != nil {
+		return errors.Wrapf(err, "network '%s'", name)
+	}
+	if v.metadata() != nil {
+		w.bridges[name] = v.Bridge.Name
+	}
+	return nil
+}
+
+// interfaces returns the domain interfaces attached to the watched networks
+func (w *watcher) interfaces() ([]watchedInterface, error) {
+	doms, err := listDomainXMLs(w.c.backend)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(w.bridges))
+	for name := range w.bridges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var ifaces []watchedInterface
+	for _, name := range names {
+		for _, i := range interfacesUsing(doms, name, w.bridges[name]) {
+			ifaces = append(ifaces, watchedInterface{network: name, DomainInterface: i})
+		}
+	}
+	return ifaces, nil
+}
+
+// diffInterfaces returns the events turning the interfaces before into the ones after
+func diffInterfaces(before, after []watchedInterface, now time.Time) []Event {
+	var events []Event
+	changed := func(from, to []watchedInterface, eventType string) {
+		keys := map[string]bool{}
+		for _, i := range to {
+			keys[i.key()] = true
+		}
+		for _, i := range from {
+			if !keys[i.key()] {
+				events = append(events, Event{Time: now, Type: eventType, Network: i.network, Domain: i.Domain, MAC: i.MAC})
+			}
+		}
+	}
+	changed(before, after, EventInterfaceDetached)
+	changed(after, before, EventInterfaceAttached)
+	return events
+}
+
+// eventQueue holds the events of a watch until its consumer takes them, so the hypervisor never waits for a slow
+// consumer. A domain change already pending is not queued again
+type eventQueue struct {
+	mu      sync.Mutex
+	pending []BackendEvent
+	ready   chan struct{} // signaled when an event is queued
+}
+
+func newEventQueue() *eventQueue {
+	return &eventQueue{ready: make(chan struct{}, 1)}
+}
+
+// push queues the event without blocking
+func (q *eventQueue) push(e BackendEvent) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if e.Type == EventDomainChanged && slices.Contains(q.pending, e) {
+		return
+	}
+	q.pending = append(q.pending, e)
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// pop returns the oldest pending event, if any
+func (q *eventQueue) pop() (BackendEvent, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.pending) == 0 {
+		return BackendEvent{}, false
+	}
+	e := q.pending[0]
+	q.pending = q.pending[1:]
+	return e, true
+}
+
+// forward sends the queued events to events until stop is closed, then closes events
+func (q *eventQueue) forward(events chan<- BackendEvent, stop <-chan struct{}) {
+	defer close(events)
+	for {
+		e, ok := q.pop()
+		if !ok {
+			select {
+			case <-q.ready:
+				continue
+			case <-stop:
+				return
+			}
+		}
+		select {
+		case events <- e:
+		case <-stop:
+			return
+		}
+	}
+}
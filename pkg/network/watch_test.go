@@ -0,0 +1,101 @@
+package network
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	b, c := createTestNetwork(t)
+	b.AddDomain("vm", `<domain><name>vm</name><devices>
+  <interface type='network'><mac address='52:54:00:00:00:01'/><source network='test-net'/></interface>
+</devices></domain>`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	events := make(chan Event, 10)
+	done := make(chan error)
+	go func() {
+		done <- c.Watch(ctx, nil, func(e Event) error {
+			events <- e
+			return nil
+		})
+	}()
+	for b.Watchers() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// unmanaged networks are not watched
+	if err := b.DefineNetwork(`<network><name>other</name></network>`); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.CreateNetwork("other"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.DeleteNetwork(ctx, "test-net", DeleteOptions{Cascade: CascadeDetach}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Event{
+		{Type: EventInterfaceDetached, Network: "test-net", Domain: "vm", MAC: "52:54:00:00:00:01"},
+		{Type: EventNetworkStopped, Network: "test-net"},
+		{Type: EventNetworkUndefined, Network: "test-net"},
+	}
+	for _, w := range want {
+		select {
+		case e := <-events:
+			e.Time = time.Time{}
+			if e != w {
+				t.Errorf("event = %+v, want %+v", e, w)
+			}
+		case <-ctx.Done():
+			t.Fatalf("no event, want %+v", w)
+		}
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Watch() error = %v", err)
+	}
+	select {
+	case e := <-events:
+		t.Errorf("unexpected event %+v", e)
+	default:
+	}
+}
+
+func TestEventQueueNeverBlocks(t *testing.T) {
+	q := newEventQueue()
+	// nobody consumes the events yet
+	for i := 0; i < 1000; i++ {
+		q.push(BackendEvent{Type: EventDomainChanged, Domain: "vm"})
+	}
+	q.push(BackendEvent{Type: EventNetworkStarted, Network: "test-net"})
+	q.push(BackendEvent{Type: EventDomainChanged, Domain: "vm"})
+
+	events := make(chan BackendEvent)
+	stop := make(chan struct{})
+	go q.forward(events, stop)
+	// the domain change pending already was not queued again
+	want := []BackendEvent{
+		{Type: EventDomainChanged, Domain: "vm"},
+		{Type: EventNetworkStarted, Network: "test-net"},
+	}
+	for _, w := range want {
+		if e := <-events; e != w {
+			t.Errorf("event = %+v, want %+v", e, w)
+		}
+	}
+
+	// a consumer that stopped reading doesn't keep the queue from stopping
+	q.push(BackendEvent{Type: EventDomainChanged, Domain: "vm"})
+	close(stop)
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal("events not closed once stopped")
+	case <-events:
+		for range events {
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package network
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net"
+)
+
+// networkXML is the subset of the libvirt network XML netctl reads back
+type networkXML struct {
+	// XMLName xml.Name `xml:"network"`
+	Name    string `xml:"name"`
+	UUID    string `xml:"uuid"`
+	Forward struct {
+		Mode string `xml:"mode,attr"`
+		Dev  string `xml:"dev,attr"`
+		NAT  struct {
+			Port struct {
+				Start int `xml:"start,attr"`
+				End   int `xml:"end,attr"`
+			} `xml:"port"`
+		} `xml:"nat"`
+	} `xml:"forward"`
+	Bridge struct {
+		Name string `xml:"name,attr"`
+	} `xml:"bridge"`
+	MAC struct {
+		Address string `xml:"address,attr"`
+	} `xml:"mac"`
+	DNS    dnsXML `xml:"dns"`
+	Domain struct {
+		Name      string `xml:"name,attr"`
+		LocalOnly string `xml:"localOnly,attr"`
+	} `xml:"domain"`
+	IPs      []networkIPXML `xml:"ip"`
+	Metadata struct {
+		Netctl *metadataXML `xml:"https://github.com/day0ops/netctl network"` // see config.MetadataNamespace
+	} `xml:"metadata"`
+}
+
+type networkIPXML struct {
+	// XMLName xml.Name `xml:"ip"`
+	Family  string `xml:"family,attr"`
+	Address string `xml:"address,attr"`
+	Netmask string `xml:"netmask,attr"`
+	Prefix  string `xml:"prefix,attr"`
+	DHCP    *struct {
+		Range struct {
+			Start string `xml:"start,attr"`
+			End   string `xml:"end,attr"`
+		} `xml:"range"`
+		Hosts []Host `xml:"host"`
+	} `xml:"dhcp"`
+}
+
+// parseNetworkXML unmarshals the XML description of a libvirt network
+func parseNetworkXML(xmlString string) (*networkXML, error) {
+	v := &networkXML{}
+	if err := xml.Unmarshal([]byte(xmlString), v); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal network XML: %w", err)
+	}
+	return v, nil
+}
+
+// ips returns the first IPv4 and the first IPv6 ip elements of the network, either of which may be nil
+func (v *networkXML) ips() (ip4, ip6 *networkIPXML) {
+	for i := range v.IPs {
+		ip, _, err := net.ParseCIDR(v.IPs[i].cidr())
+		if err != nil {
+			continue
+		}
+		if ip.To4() != nil && ip4 == nil {
+			ip4 = &v.IPs[i]
+		} else if ip.To4() == nil && ip6 == nil {
+			ip6 = &v.IPs[i]
+		}
+	}
+	return ip4, ip6
+}
+
+// cidr returns the address of the ip element in CIDR form ('a.b.c.d/n')
+func (ip networkIPXML) cidr() string {
+	if ip.Prefix != "" {
+		return ip.Address + "/" + ip.Prefix
+	}
+	if ip.Netmask != "" {
+		ones, _ := parseNetmask(ip.Netmask).Size()
+		return fmt.Sprintf("%s/%d", ip.Address, ones)
+	}
+	return ip.Address
+}
+
+// parseNetmask converts a dotted-decimal netmask ('a.b.c.d') to its IPMask form
+func parseNetmask(mask string) net.IPMask {
+	ip := net.ParseIP(mask).To4()
+	if ip == nil {
+		return nil
+	}
+	return net.IPMask(ip)
+}
+
+// xmlNode is a generic XML element, for editing network XML without knowing its whole schema
+type xmlNode struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Content string     `xml:",chardata"`
+	Nodes   []xmlNode  `xml:",any"`
+}
+
+// MarshalXML drops the namespace declarations read back as attributes, which encoding/xml can't write again.
+// Namespaced elements get their namespace declared on their own instead
+func (n xmlNode) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start.Name = n.XMLName
+	start.Attr = nil
+	for _, a := range n.Attrs {
+		if a.Name.Space != "xmlns" && a.Name.Local != "xmlns" {
+			start.Attr = append(start.Attr, a)
+		}
+	}
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	if n.Content != "" && len(n.Nodes) == 0 {
+		if err := e.EncodeToken(xml.CharData(n.Content)); err != nil {
+			return err
+		}
+	}
+	for _, child := range n.Nodes {
+		if err := e.Encode(child); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+func (n *xmlNode) attr(name string) string {
+	for _, a := range n.Attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// children returns the child elements with the given name
+func (n *xmlNode) children(name string) []*xmlNode {
+	var nodes []*xmlNode
+	for i := range n.Nodes {
+		if n.Nodes[i].XMLName.Local == name {
+			nodes = append(nodes, &n.Nodes[i])
+		}
+	}
+	return nodes
+}
+
+// child returns the first child element with the given name, adding it if missing
+func (n *xmlNode) child(name string) *xmlNode {
+	if nodes := n.children(name); len(nodes) > 0 {
+		return nodes[0]
+	}
+	n.Nodes = append(n.Nodes, xmlNode{XMLName: xml.Name{Local: name}})
+	return &n.Nodes[len(n.Nodes)-1]
+}
@@ -1,6 +1,7 @@
 package util
 
 import (
+	"context"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
@@ -10,14 +11,19 @@ import (
 
 // LocalRetry is back-off retry for local connections
 func LocalRetry(callback func() error, maxTime time.Duration) error {
+	return LocalRetryWithContext(context.Background(), callback, maxTime)
+}
+
+// LocalRetryWithContext is back-off retry for local connections that stops retrying once ctx is done
+func LocalRetryWithContext(ctx context.Context, callback func() error, maxTime time.Duration) error {
 	b := backoff.NewExponentialBackOff()
 	b.InitialInterval = 250 * time.Millisecond
 	b.RandomizationFactor = 0.25
 	b.Multiplier = 1.25
 	b.MaxElapsedTime = maxTime
-	return backoff.RetryNotify(callback, b, notify)
-}
-
-func notify(err error, d time.Duration) {
-	log.Infof("will retry after %s: %v", d, err)
+	attempt := 0
+	return backoff.RetryNotify(callback, backoff.WithContext(b, ctx), func(err error, d time.Duration) {
+		attempt++
+		log.With(log.Fields{log.FieldAttempt: attempt}).Infof("will retry after %s: %v", d, err)
+	})
 }
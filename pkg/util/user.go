@@ -0,0 +1,11 @@
+package util
+
+import "os/user"
+
+// CurrentUser returns the name of the user running the process, or "unknown" if it can't be determined
+func CurrentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return "unknown"
+}